@@ -11,11 +11,20 @@ import (
 // NewBuilderFor creates a new builder that builds objects of the same type as
 // the template object.
 func NewBuilderFor(template interface{}) *RootBuilder {
-	rv := reflect.ValueOf(template)
-	for rv.Kind() == reflect.Ptr {
-		rv = rv.Elem()
+	return NewBuilderWithOptions(template, nil)
+}
+
+// NewBuilderWithOptions creates a new builder that builds objects of the same
+// type as the template object, configured by options (nil means use defaults).
+func NewBuilderWithOptions(template interface{}, options *BuilderOptions) *RootBuilder {
+	t := reflect.TypeOf(template)
+	isPtr := t.Kind() == reflect.Ptr
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
-	return newRootBuilder(rv.Type())
+	this := newRootBuilder(t, options.withDefaultsApplied())
+	this.isPtr = isPtr
+	return this
 }
 
 // ObjectBuilder responds to external events to progressively build an object.
@@ -26,6 +35,7 @@ type ObjectBuilder interface {
 	Int(value int64, dst reflect.Value)
 	Uint(value uint64, dst reflect.Value)
 	Float(value float64, dst reflect.Value)
+	Complex(value complex128, dst reflect.Value)
 	String(value string, dst reflect.Value)
 	Bytes(value []byte, dst reflect.Value)
 	URI(value *url.URL, dst reflect.Value)
@@ -33,7 +43,10 @@ type ObjectBuilder interface {
 	List()
 	Map()
 	End()
-	Marker(id interface{})
+
+	// Store the object previously marked with id. Markers themselves are
+	// tracked by the RootBuilder, which associates the marker ID with the
+	// next object to be built.
 	Reference(id interface{})
 
 	// Prepare this builder for storing list contents, ultimately followed by End()
@@ -87,15 +100,104 @@ func init() {
 	}
 }
 
+// Defaulter can be implemented by a struct (with a pointer receiver) to supply
+// default values. When the struct is built, SetDefaults is called on a fresh
+// instance that already contains any `default:"..."` tag values, and every
+// field that didn't receive a value is then copied from that instance.
+type Defaulter interface {
+	SetDefaults()
+}
+
+var defaulterType = reflect.TypeOf((*Defaulter)(nil)).Elem()
+
+// SelfBuilder can be implemented by a type (with a pointer receiver) to take
+// full control of how it's built. NewSelfBuilder returns callbacks that build
+// the receiver from the events of exactly one object, which may be a container
+// of any depth.
+type SelfBuilder interface {
+	NewSelfBuilder() ObjectIteratorCallbacks
+}
+
+// OrderedMap can be used in place of a Go map when building maps into
+// interface{} (see BuilderOptions.NewOrderedMap), such as to preserve the order
+// of keys.
+type OrderedMap interface {
+	// Set is called for each key-value pair, in the order they're received.
+	Set(key interface{}, value interface{})
+}
+
+// BuildError is returned by RootBuilder when an event cannot be used to build
+// the destination object. Once a RootBuilder has returned a BuildError, it will
+// return the same error for all subsequent events.
+type BuildError struct {
+	// The event that could not be processed (Nil, Bool, Int, ListBegin, etc)
+	Event string
+	// The type that was being built when the error occurred
+	DstType reflect.Type
+	// Why the event could not be processed
+	Reason string
+	// Where in the object the error occurred
+	Path []PathElement
+}
+
+// PathString returns the error's path as a JSON Pointer style string.
+func (this *BuildError) PathString() string {
+	return PathToString(this.Path)
+}
+
+func (this *BuildError) Error() string {
+	if len(this.Path) == 0 {
+		return fmt.Sprintf("Cannot build %v from event %v: %v", this.DstType, this.Event, this.Reason)
+	}
+	return fmt.Sprintf("Cannot build %v from event %v at %v: %v", this.DstType, this.Event, this.PathString(), this.Reason)
+}
+
+// Builders report errors by panicking with a *BuildError, which RootBuilder
+// recovers from and returns to the caller.
+
 func builderPanicBadEvent(builder ObjectBuilder, dstType reflect.Type, containerMsg string) {
-	panic(fmt.Sprintf(`%v with type %v cannot respond to event "%v"`, reflect.TypeOf(builder), dstType, containerMsg))
+	panic(&BuildError{
+		DstType: dstType,
+		Reason:  fmt.Sprintf(`%v cannot respond to "%v"`, reflect.TypeOf(builder), containerMsg),
+	})
 }
 
 func builderPanicCannotConvert(value interface{}, dstType reflect.Type) {
-	panic(fmt.Errorf("[%v] cannot be safely converted to %v", value, dstType))
+	panic(&BuildError{
+		DstType: dstType,
+		Reason:  fmt.Sprintf("[%v] cannot be safely converted to %v", value, dstType),
+	})
+}
+
+// Build a nil value into dst. Container builders interpret Nil as a nil element
+// of their own container, so a nil slice or map is stored directly rather than
+// being passed to the destination's builder.
+func buildNil(builder ObjectBuilder, dst reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Slice, reflect.Map:
+		dst.Set(reflect.Zero(dst.Type()))
+	case reflect.Array:
+		builderPanicBadEvent(builder, dst.Type(), "Nil")
+	default:
+		builder.Nil(dst)
+	}
 }
 
 func generateBuilderForType(dstType reflect.Type) ObjectBuilder {
+	if builder := getCustomBuilder(dstType); builder != nil {
+		return builder
+	}
+	if isMarshalerType(dstType, selfBuilderType) {
+		return newSelfBuilderAdapter(dstType)
+	}
+	// Text takes precedence over binary if a type supports both
+	if isMarshalerType(dstType, textUnmarshalerType) {
+		return newTextUnmarshalerBuilder(dstType)
+	}
+	if isMarshalerType(dstType, binaryUnmarshalerType) {
+		return newBinaryUnmarshalerBuilder(dstType)
+	}
+
 	switch dstType.Kind() {
 	case reflect.Bool, reflect.String:
 		return newBasicBuilder(dstType)
@@ -105,27 +207,29 @@ func generateBuilderForType(dstType reflect.Type) ObjectBuilder {
 		return newUintBuilder(dstType)
 	case reflect.Float32, reflect.Float64:
 		return newFloatBuilder(dstType)
+	case reflect.Complex64, reflect.Complex128:
+		return newComplexBuilder(dstType)
 	case reflect.Interface:
-		return newInterfaceBuilder()
+		return newInterfaceBuilder(dstType)
 	case reflect.Array:
 		return newArrayBuilder(dstType)
 	case reflect.Slice:
-		switch dstType.Elem().Kind() {
-		case reflect.Uint8:
+		switch {
+		case dstType.Elem().Kind() == reflect.Uint8:
 			return newBytesBuilder()
-		case reflect.Interface:
+		case dstType == builderIntfSliceType:
 			return newIntfSliceBuilder()
 		default:
 			return newSliceBuilder(dstType)
 		}
 	case reflect.Map:
-		if dstType.Elem().Kind() == reflect.Interface && dstType.Key().Kind() == reflect.Interface {
+		if dstType == builderIntfIntfMapType {
 			return newIntfIntfMapBuilder()
 		}
 		return newMapBuilder(dstType)
 	case reflect.Struct:
 		if dstType == timeType {
-			return newBasicBuilder(dstType)
+			return newTimeBuilder()
 		}
 		if dstType == urlType {
 			return newURLBuilder()
@@ -152,6 +256,12 @@ func getBuilderForType(dstType reflect.Type) ObjectBuilder {
 }
 
 func getTopLevelBuilderForType(dstType reflect.Type) ObjectBuilder {
+	// Types that build from scalars, whatever their kind
+	switch builder := getBuilderForType(dstType); builder.(type) {
+	case *customBuilder, *selfBuilderAdapter, *textUnmarshalerBuilder, *binaryUnmarshalerBuilder:
+		return builder
+	}
+
 	switch dstType.Kind() {
 	case reflect.Slice:
 		if dstType.Elem().Kind() == reflect.Uint8 {
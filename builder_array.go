@@ -10,7 +10,10 @@ type arrayBuilder struct {
 	// Const data
 	dstType reflect.Type
 
-	// Cloned data (must be populated)
+	// Template data
+	elemTemplate ObjectBuilder
+
+	// Cloned data (created on demand)
 	elemBuilder ObjectBuilder
 
 	// Clone inserted data
@@ -29,20 +32,27 @@ func newArrayBuilder(dstType reflect.Type) ObjectBuilder {
 }
 
 func (this *arrayBuilder) PostCacheInitBuilder() {
-	this.elemBuilder = getBuilderForType(this.dstType.Elem())
+	this.elemTemplate = getBuilderForType(this.dstType.Elem())
 }
 
 func (this *arrayBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
 	that := &arrayBuilder{
-		dstType: this.dstType,
-		parent:  parent,
-		root:    root,
+		dstType:      this.dstType,
+		elemTemplate: this.elemTemplate,
+		parent:       parent,
+		root:         root,
 	}
-	that.elemBuilder = this.elemBuilder.CloneFromTemplate(root, that)
 	that.reset()
 	return that
 }
 
+func (this *arrayBuilder) getElemBuilder() ObjectBuilder {
+	if this.elemBuilder == nil {
+		this.elemBuilder = this.elemTemplate.CloneFromTemplate(this.root, this)
+	}
+	return this.elemBuilder
+}
+
 func (this *arrayBuilder) reset() {
 	this.container = reflect.New(this.dstType).Elem()
 	this.index = 0
@@ -52,34 +62,44 @@ func (this *arrayBuilder) currentElem() reflect.Value {
 	return this.container.Index(this.index)
 }
 
-func (this *arrayBuilder) Nil(ignored reflect.Value) {
-	this.elemBuilder.Nil(this.currentElem())
+func (this *arrayBuilder) finishElem() {
+	this.root.markObject(this.currentElem())
 	this.index++
 }
 
+func (this *arrayBuilder) Nil(ignored reflect.Value) {
+	buildNil(this.getElemBuilder(), this.currentElem())
+	this.finishElem()
+}
+
 func (this *arrayBuilder) Bool(value bool, ignored reflect.Value) {
-	this.elemBuilder.Bool(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().Bool(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) Int(value int64, ignored reflect.Value) {
-	this.elemBuilder.Int(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().Int(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) Uint(value uint64, ignored reflect.Value) {
-	this.elemBuilder.Uint(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().Uint(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) Float(value float64, ignored reflect.Value) {
-	this.elemBuilder.Float(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().Float(value, this.currentElem())
+	this.finishElem()
+}
+
+func (this *arrayBuilder) Complex(value complex128, ignored reflect.Value) {
+	this.getElemBuilder().Complex(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) String(value string, ignored reflect.Value) {
-	this.elemBuilder.String(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().String(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) Bytes(value []byte, dst reflect.Value) {
@@ -91,21 +111,21 @@ func (this *arrayBuilder) Bytes(value []byte, dst reflect.Value) {
 }
 
 func (this *arrayBuilder) URI(value *url.URL, ignored reflect.Value) {
-	this.elemBuilder.URI(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().URI(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) Time(value time.Time, ignored reflect.Value) {
-	this.elemBuilder.Time(value, this.currentElem())
-	this.index++
+	this.getElemBuilder().Time(value, this.currentElem())
+	this.finishElem()
 }
 
 func (this *arrayBuilder) List() {
-	this.elemBuilder.PrepareForListContents()
+	this.getElemBuilder().PrepareForListContents()
 }
 
 func (this *arrayBuilder) Map() {
-	this.elemBuilder.PrepareForMapContents()
+	this.getElemBuilder().PrepareForMapContents()
 }
 
 func (this *arrayBuilder) End() {
@@ -114,15 +134,16 @@ func (this *arrayBuilder) End() {
 	this.parent.NotifyChildContainerFinished(object)
 }
 
-func (this *arrayBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *arrayBuilder) Reference(id interface{}) {
-	panic("TODO")
+	if this.root.dereference(id, this.currentElem()) {
+		// The array will be copied into its parent before the fixup happens
+		this.root.addFixupCheck(id, this.currentElem())
+	}
+	this.finishElem()
 }
 
 func (this *arrayBuilder) PrepareForListContents() {
+	this.root.markObject(this.container)
 	this.root.setCurrentBuilder(this)
 }
 
@@ -1,12 +1,15 @@
 package reconstruct
 
 import (
+	"encoding/base64"
 	"net/url"
 	"reflect"
 	"time"
 )
 
 type bytesBuilder struct {
+	// Clone inserted data
+	root *RootBuilder
 }
 
 var globalBytesBuilder = &bytesBuilder{}
@@ -19,7 +22,7 @@ func (this *bytesBuilder) PostCacheInitBuilder() {
 }
 
 func (this *bytesBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
-	return this
+	return &bytesBuilder{root: root}
 }
 
 func (this *bytesBuilder) Nil(dst reflect.Value) {
@@ -42,8 +45,23 @@ func (this *bytesBuilder) Float(value float64, dst reflect.Value) {
 	builderPanicBadEvent(this, bytesType, "Float")
 }
 
+func (this *bytesBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, bytesType, "Complex")
+}
+
 func (this *bytesBuilder) String(value string, dst reflect.Value) {
-	builderPanicBadEvent(this, bytesType, "String")
+	if this.root.options.StringsAsBytes {
+		this.Bytes([]byte(value), dst)
+		return
+	}
+	if !this.root.options.ConvertStrings {
+		builderPanicBadEvent(this, bytesType, "String")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+	this.Bytes(decoded, dst)
 }
 
 func (this *bytesBuilder) Bytes(value []byte, dst reflect.Value) {
@@ -70,12 +88,8 @@ func (this *bytesBuilder) End() {
 	builderPanicBadEvent(this, bytesType, "ContainerEnd")
 }
 
-func (this *bytesBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *bytesBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, bytesType, "Reference")
 }
 
 func (this *bytesBuilder) PrepareForListContents() {
@@ -0,0 +1,110 @@
+package reconstruct
+
+import (
+	"math"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+type complexBuilder struct {
+	// Const data
+	dstType reflect.Type
+}
+
+func newComplexBuilder(dstType reflect.Type) ObjectBuilder {
+	return &complexBuilder{
+		dstType: dstType,
+	}
+}
+
+func (this *complexBuilder) PostCacheInitBuilder() {
+}
+
+func (this *complexBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return this
+}
+
+func (this *complexBuilder) Nil(dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Nil")
+}
+
+func (this *complexBuilder) Bool(value bool, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Bool")
+}
+
+func (this *complexBuilder) Int(value int64, dst reflect.Value) {
+	dst.SetComplex(complex(float64(value), 0))
+	if int64(real(dst.Complex())) != value {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+}
+
+func (this *complexBuilder) Uint(value uint64, dst reflect.Value) {
+	dst.SetComplex(complex(float64(value), 0))
+	if uint64(real(dst.Complex())) != value {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+}
+
+func (this *complexBuilder) Float(value float64, dst reflect.Value) {
+	this.Complex(complex(value, 0), dst)
+}
+
+func (this *complexBuilder) Complex(value complex128, dst reflect.Value) {
+	// Values too large for complex64 or too precise for it are both rejected,
+	// which shows up as a part changing when stored.
+	dst.SetComplex(value)
+	stored := dst.Complex()
+	if !isSameFloat(real(stored), real(value)) || !isSameFloat(imag(stored), imag(value)) {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+}
+
+func isSameFloat(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+func (this *complexBuilder) String(value string, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "String")
+}
+
+func (this *complexBuilder) Bytes(value []byte, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Bytes")
+}
+
+func (this *complexBuilder) URI(value *url.URL, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "URI")
+}
+
+func (this *complexBuilder) Time(value time.Time, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Time")
+}
+
+func (this *complexBuilder) List() {
+	builderPanicBadEvent(this, this.dstType, "ListBegin")
+}
+
+func (this *complexBuilder) Map() {
+	builderPanicBadEvent(this, this.dstType, "MapBegin")
+}
+
+func (this *complexBuilder) End() {
+	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
+}
+
+func (this *complexBuilder) Reference(id interface{}) {
+	builderPanicBadEvent(this, this.dstType, "Reference")
+}
+
+func (this *complexBuilder) PrepareForListContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForListContents")
+}
+
+func (this *complexBuilder) PrepareForMapContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForMapContents")
+}
+
+func (this *complexBuilder) NotifyChildContainerFinished(value reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "NotifyChildContainerFinished")
+}
@@ -51,6 +51,13 @@ func (this *floatBuilder) Float(value float64, dst reflect.Value) {
 	dst.SetFloat(value)
 }
 
+func (this *floatBuilder) Complex(value complex128, dst reflect.Value) {
+	if imag(value) != 0 {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+	this.Float(real(value), dst)
+}
+
 func (this *floatBuilder) String(value string, dst reflect.Value) {
 	builderPanicBadEvent(this, this.dstType, "String")
 }
@@ -79,12 +86,8 @@ func (this *floatBuilder) End() {
 	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
 }
 
-func (this *floatBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *floatBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
 
 func (this *floatBuilder) PrepareForListContents() {
@@ -28,76 +28,81 @@ func (this *ignoreBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBui
 	}
 }
 
+// Notify the parent that the ignored value is finished. The value is still
+// associated with any pending marker, since it may be referenced later on.
+func (this *ignoreBuilder) finish(value reflect.Value) {
+	this.root.markObject(value)
+	this.parent.NotifyChildContainerFinished(value)
+}
+
 func (this *ignoreBuilder) Nil(dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.Zero(builderIntfType))
 }
 
 func (this *ignoreBuilder) Bool(value bool, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) Int(value int64, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) Uint(value uint64, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) Float(value float64, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
+}
+
+func (this *ignoreBuilder) Complex(value complex128, dst reflect.Value) {
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) String(value string, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) Bytes(value []byte, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) URI(value *url.URL, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) Time(value time.Time, dst reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.finish(reflect.ValueOf(value))
 }
 
 func (this *ignoreBuilder) List() {
-	// TODO: Pre-generate these?
-	container := reflect.ValueOf(make([]interface{}, 0))
-	builder := getBuilderForType(container.Type())
-	builder.CloneFromTemplate(this.root, this)
-	builder.PrepareForListContents()
+	this.PrepareForListContents()
 }
 
 func (this *ignoreBuilder) Map() {
-	builder := getBuilderForType(reflect.TypeOf(map[interface{}]interface{}{}))
-	builder.CloneFromTemplate(this.root, this)
-	builder.PrepareForMapContents()
+	this.PrepareForMapContents()
 }
 
 func (this *ignoreBuilder) End() {
-	builderPanicBadEvent(this, reflect.TypeOf([]interface{}{}).Elem(), "End")
-}
-
-func (this *ignoreBuilder) Marker(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, builderIntfType, "End")
 }
 
 func (this *ignoreBuilder) Reference(id interface{}) {
-	panic("TODO")
+	this.parent.NotifyChildContainerFinished(reflect.Value{})
 }
 
+// Ignored containers are built as generic containers, which then notify our
+// parent directly when they're finished.
 func (this *ignoreBuilder) PrepareForListContents() {
-	this.root.setCurrentBuilder(this)
+	builder := getBuilderForType(builderIntfSliceType).CloneFromTemplate(this.root, this.parent)
+	builder.PrepareForListContents()
 }
 
 func (this *ignoreBuilder) PrepareForMapContents() {
-	this.root.setCurrentBuilder(this)
+	builder := getBuilderForType(builderIntfIntfMapType).CloneFromTemplate(this.root, this.parent)
+	builder.PrepareForMapContents()
 }
 
 func (this *ignoreBuilder) NotifyChildContainerFinished(value reflect.Value) {
-	this.root.setCurrentBuilder(this.parent)
+	this.parent.NotifyChildContainerFinished(value)
 }
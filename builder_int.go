@@ -56,6 +56,13 @@ func (this *intBuilder) Float(value float64, dst reflect.Value) {
 	}
 }
 
+func (this *intBuilder) Complex(value complex128, dst reflect.Value) {
+	if imag(value) != 0 {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+	this.Float(real(value), dst)
+}
+
 func (this *intBuilder) String(value string, dst reflect.Value) {
 	builderPanicBadEvent(this, this.dstType, "String")
 }
@@ -84,12 +91,8 @@ func (this *intBuilder) End() {
 	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
 }
 
-func (this *intBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *intBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
 
 func (this *intBuilder) PrepareForListContents() {
@@ -7,23 +7,32 @@ import (
 )
 
 var (
-	builderIntfIntfMapType = reflect.TypeOf(map[interface{}]interface{}{})
-	builderIntfSliceType   = reflect.TypeOf([]interface{}{})
-	builderIntfType        = builderIntfSliceType.Elem()
+	builderIntfIntfMapType   = reflect.TypeOf(map[interface{}]interface{}{})
+	builderStringIntfMapType = reflect.TypeOf(map[string]interface{}{})
+	builderStringType        = builderStringIntfMapType.Key()
+	builderIntfSliceType     = reflect.TypeOf([]interface{}{})
+	builderIntfType          = builderIntfSliceType.Elem()
 
-	globalIntfBuilder        = &intfBuilder{}
+	globalIntfBuilder        = &intfBuilder{dstType: builderIntfType}
 	globalIntfSliceBuilder   = &intfSliceBuilder{}
 	globalIntfIntfMapBuilder = &intfIntfMapBuilder{}
+	globalIntfMapBuilder     = &intfIntfMapBuilder{isForInterface: true}
 )
 
 type intfBuilder struct {
+	// Const data
+	dstType reflect.Type
+
 	// Clone inserted data
 	root   *RootBuilder
 	parent ObjectBuilder
 }
 
-func newInterfaceBuilder() ObjectBuilder {
-	return globalIntfBuilder
+func newInterfaceBuilder(dstType reflect.Type) ObjectBuilder {
+	if dstType == builderIntfType {
+		return globalIntfBuilder
+	}
+	return &intfBuilder{dstType: dstType}
 }
 
 func (this *intfBuilder) PostCacheInitBuilder() {
@@ -31,74 +40,104 @@ func (this *intfBuilder) PostCacheInitBuilder() {
 
 func (this *intfBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
 	return &intfBuilder{
-		parent: parent,
-		root:   root,
+		dstType: this.dstType,
+		parent:  parent,
+		root:    root,
 	}
 }
 
 func (this *intfBuilder) Nil(dst reflect.Value) {
-	dst.Set(reflect.Zero(builderIntfType))
+	dst.Set(this.root.intfValue(reflect.Zero(this.dstType), this.dstType))
 }
 
 func (this *intfBuilder) Bool(value bool, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) Int(value int64, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) Uint(value uint64, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) Float(value float64, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
+}
+
+func (this *intfBuilder) Complex(value complex128, dst reflect.Value) {
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) String(value string, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) Bytes(value []byte, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) URI(value *url.URL, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
 func (this *intfBuilder) Time(value time.Time, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	dst.Set(this.root.intfValue(reflect.ValueOf(value), this.dstType))
 }
 
+// Only reached when the interface is the top-level object.
 func (this *intfBuilder) List() {
-	builderPanicBadEvent(this, builderIntfType, "List")
+	this.PrepareForListContents()
 }
 
+// Only reached when the interface is the top-level object.
 func (this *intfBuilder) Map() {
-	builderPanicBadEvent(this, builderIntfType, "Map")
+	this.PrepareForMapContents()
 }
 
 func (this *intfBuilder) End() {
-	builderPanicBadEvent(this, builderIntfType, "ContainerEnd")
-}
-
-func (this *intfBuilder) Marker(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
 }
 
 func (this *intfBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
 
 func (this *intfBuilder) PrepareForListContents() {
+	if this.root.prepareHintedContainer(this.dstType, this.parent, false) {
+		return
+	}
+	if !builderIntfSliceType.AssignableTo(this.dstType) {
+		builderPanicBadEvent(this, this.dstType, "PrepareForListContents")
+	}
 	builder := globalIntfSliceBuilder.CloneFromTemplate(this.root, this.parent)
 	builder.PrepareForListContents()
 }
 
 func (this *intfBuilder) PrepareForMapContents() {
-	builder := globalIntfIntfMapBuilder.CloneFromTemplate(this.root, this.parent)
+	prepareIntfMapContents(this.root, this.parent, this.dstType)
+}
+
+// Begin building a map into an interface of type dstType. A type hint takes
+// precedence over everything else. Otherwise if there's a type registry, the
+// map may contain a discriminator naming its concrete type.
+func prepareIntfMapContents(root *RootBuilder, parent ObjectBuilder, dstType reflect.Type) {
+	if root.prepareHintedContainer(dstType, parent, true) {
+		return
+	}
+	if root.options.TypeRegistry != nil {
+		builder := newPolymorphicBuilder(root, parent, dstType)
+		builder.PrepareForMapContents()
+		return
+	}
+	if !builderIntfIntfMapType.AssignableTo(dstType) {
+		panic(&BuildError{
+			DstType: dstType,
+			Reason:  "Cannot build a map into a non-empty interface without a type registry",
+		})
+	}
+	builder := globalIntfMapBuilder.CloneFromTemplate(root, parent)
 	builder.PrepareForMapContents()
 }
 
@@ -117,6 +156,9 @@ type intfSliceBuilder struct {
 
 	// Variable data (must be reset)
 	container reflect.Value
+	markerID  interface{}
+	isMarked  bool
+	fixups    []entryFixup
 }
 
 func newIntfSliceBuilder() ObjectBuilder {
@@ -137,9 +179,14 @@ func (this *intfSliceBuilder) CloneFromTemplate(root *RootBuilder, parent Object
 
 func (this *intfSliceBuilder) reset() {
 	this.container = reflect.MakeSlice(builderIntfSliceType, 0, defaultSliceCap)
+	this.markerID = nil
+	this.isMarked = false
+	this.fixups = nil
 }
 
 func (this *intfSliceBuilder) storeRValue(value reflect.Value) {
+	value = this.root.intfValue(value, builderIntfType)
+	this.root.markObject(value)
 	this.container = reflect.Append(this.container, value)
 }
 
@@ -148,7 +195,7 @@ func (this *intfSliceBuilder) storeValue(value interface{}) {
 }
 
 func (this *intfSliceBuilder) Nil(ignored reflect.Value) {
-	this.storeRValue(reflect.New(builderIntfSliceType).Elem())
+	this.storeRValue(reflect.Zero(builderIntfType))
 }
 
 func (this *intfSliceBuilder) Bool(value bool, ignored reflect.Value) {
@@ -167,6 +214,10 @@ func (this *intfSliceBuilder) Float(value float64, ignored reflect.Value) {
 	this.storeValue(value)
 }
 
+func (this *intfSliceBuilder) Complex(value complex128, ignored reflect.Value) {
+	this.storeValue(value)
+}
+
 func (this *intfSliceBuilder) String(value string, ignored reflect.Value) {
 	this.storeValue(value)
 }
@@ -184,30 +235,40 @@ func (this *intfSliceBuilder) Time(value time.Time, ignored reflect.Value) {
 }
 
 func (this *intfSliceBuilder) List() {
+	if this.root.prepareHintedContainer(builderIntfType, this, false) {
+		return
+	}
 	builder := globalIntfSliceBuilder.CloneFromTemplate(this.root, this)
 	builder.PrepareForListContents()
 }
 
 func (this *intfSliceBuilder) Map() {
-	builder := globalIntfIntfMapBuilder.CloneFromTemplate(this.root, this)
-	builder.PrepareForMapContents()
+	prepareIntfMapContents(this.root, this, builderIntfType)
 }
 
 func (this *intfSliceBuilder) End() {
 	object := this.container
+	this.root.addEntryFixups(object, this.fixups)
+	if this.isMarked {
+		this.root.finishUnfinishedObject(this.markerID, object)
+	}
 	this.reset()
 	this.parent.NotifyChildContainerFinished(object)
 }
 
-func (this *intfSliceBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *intfSliceBuilder) Reference(id interface{}) {
-	panic("TODO")
+	object := reflect.New(builderIntfType).Elem()
+	if this.root.dereference(id, object) {
+		this.fixups = append(this.fixups, entryFixup{id: id, index: this.container.Len()})
+	}
+	this.storeRValue(object)
 }
 
 func (this *intfSliceBuilder) PrepareForListContents() {
+	this.markerID, this.isMarked = this.root.takePendingMarker()
+	if this.isMarked {
+		this.root.beginUnfinishedObject(this.markerID, reflect.New(builderIntfSliceType).Elem())
+	}
 	this.root.setCurrentBuilder(this)
 }
 
@@ -224,15 +285,24 @@ func (this *intfSliceBuilder) NotifyChildContainerFinished(value reflect.Value)
 // Map
 // ---
 
+// Builds map[interface{}]interface{}. When building a map into an interface,
+// the options may call for an OrderedMap or map[string]interface{} instead.
 type intfIntfMapBuilder struct {
+	// Const data
+	isForInterface bool
+
 	// Clone inserted data
 	root   *RootBuilder
 	parent ObjectBuilder
 
 	// Variable data (must be reset)
-	container reflect.Value
-	key       reflect.Value
-	nextIsKey bool
+	container  reflect.Value
+	orderedMap OrderedMap
+	key        reflect.Value
+	nextIsKey  bool
+	markerID   interface{}
+	isMarked   bool
+	fixups     []entryFixup
 }
 
 func newIntfIntfMapBuilder() ObjectBuilder {
@@ -244,28 +314,78 @@ func (this *intfIntfMapBuilder) PostCacheInitBuilder() {
 
 func (this *intfIntfMapBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
 	that := &intfIntfMapBuilder{
-		parent: parent,
-		root:   root,
+		isForInterface: this.isForInterface,
+		parent:         parent,
+		root:           root,
 	}
 	that.reset()
 	return that
 }
 
 func (this *intfIntfMapBuilder) reset() {
-	this.container = reflect.MakeMap(builderIntfIntfMapType)
+	this.orderedMap = nil
+	switch {
+	case this.isForInterface && this.root.options.NewOrderedMap != nil:
+		this.orderedMap = this.root.options.NewOrderedMap()
+		this.container = reflect.ValueOf(this.orderedMap)
+	case this.isForInterface && this.root.options.StringKeyedMaps:
+		// Switches to map[interface{}]interface{} if a non-string key arrives
+		this.container = reflect.MakeMap(builderStringIntfMapType)
+	default:
+		this.container = reflect.MakeMap(builderIntfIntfMapType)
+	}
 	this.key = reflect.Value{}
 	this.nextIsKey = true
+	this.markerID = nil
+	this.isMarked = false
+	this.fixups = nil
 }
 
 func (this *intfIntfMapBuilder) storeValue(value reflect.Value) {
+	value = this.root.intfValue(value, builderIntfType)
+	this.root.markObject(value)
 	if this.nextIsKey {
 		this.key = value
 	} else {
-		this.container.SetMapIndex(this.key, value)
+		this.setEntry(this.key, value)
 	}
 	this.nextIsKey = !this.nextIsKey
 }
 
+func (this *intfIntfMapBuilder) entryKey(key reflect.Value) reflect.Value {
+	if key.Kind() == reflect.Interface && !key.IsNil() {
+		return key.Elem()
+	}
+	return key
+}
+
+func (this *intfIntfMapBuilder) setEntry(key reflect.Value, value reflect.Value) {
+	key = this.entryKey(key)
+	if this.orderedMap != nil {
+		this.orderedMap.Set(key.Interface(), value.Interface())
+		return
+	}
+	if this.container.Type() == builderStringIntfMapType && key.Type() != builderStringType {
+		this.convertToIntfIntfMap()
+	}
+	this.container.SetMapIndex(key, value)
+}
+
+// Whether the map being built may still be replaced by convertToIntfIntfMap.
+// This is decided when the map begins, since only string keyed maps convert.
+func (this *intfIntfMapBuilder) canConvert() bool {
+	return this.isForInterface && this.orderedMap == nil && this.root.options.StringKeyedMaps
+}
+
+func (this *intfIntfMapBuilder) convertToIntfIntfMap() {
+	container := reflect.MakeMap(builderIntfIntfMapType)
+	iter := mapRange(this.container)
+	for iter.Next() {
+		container.SetMapIndex(iter.Key(), iter.Value())
+	}
+	this.container = container
+}
+
 func (this *intfIntfMapBuilder) Nil(ignored reflect.Value) {
 	this.storeValue(reflect.Zero(builderIntfType))
 }
@@ -286,6 +406,10 @@ func (this *intfIntfMapBuilder) Float(value float64, ignored reflect.Value) {
 	this.storeValue(reflect.ValueOf(value))
 }
 
+func (this *intfIntfMapBuilder) Complex(value complex128, ignored reflect.Value) {
+	this.storeValue(reflect.ValueOf(value))
+}
+
 func (this *intfIntfMapBuilder) String(value string, ignored reflect.Value) {
 	this.storeValue(reflect.ValueOf(value))
 }
@@ -303,27 +427,39 @@ func (this *intfIntfMapBuilder) Time(value time.Time, ignored reflect.Value) {
 }
 
 func (this *intfIntfMapBuilder) List() {
+	if this.root.prepareHintedContainer(builderIntfType, this, false) {
+		return
+	}
 	builder := globalIntfSliceBuilder.CloneFromTemplate(this.root, this)
 	builder.PrepareForListContents()
 }
 
 func (this *intfIntfMapBuilder) Map() {
-	builder := globalIntfIntfMapBuilder.CloneFromTemplate(this.root, this)
-	builder.PrepareForMapContents()
+	prepareIntfMapContents(this.root, this, builderIntfType)
 }
 
 func (this *intfIntfMapBuilder) End() {
 	object := this.container
+	if this.orderedMap == nil {
+		this.root.addEntryFixups(object, this.fixups)
+	}
+	if this.isMarked && this.canConvert() {
+		this.root.finishUnfinishedObject(this.markerID, object)
+	}
 	this.reset()
 	this.parent.NotifyChildContainerFinished(object)
 }
 
-func (this *intfIntfMapBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *intfIntfMapBuilder) Reference(id interface{}) {
-	panic("TODO")
+	object := reflect.New(builderIntfType).Elem()
+	if this.root.dereference(id, object) {
+		if this.nextIsKey || this.orderedMap != nil {
+			this.root.addFixupCheck(id, object)
+		} else {
+			this.fixups = append(this.fixups, entryFixup{id: id, key: this.entryKey(this.key)})
+		}
+	}
+	this.storeValue(object)
 }
 
 func (this *intfIntfMapBuilder) PrepareForListContents() {
@@ -331,6 +467,15 @@ func (this *intfIntfMapBuilder) PrepareForListContents() {
 }
 
 func (this *intfIntfMapBuilder) PrepareForMapContents() {
+	this.markerID, this.isMarked = this.root.takePendingMarker()
+	if this.isMarked {
+		if this.canConvert() {
+			// References to the map taken before conversion must be updated
+			this.root.beginUnfinishedObject(this.markerID, this.container)
+		} else {
+			this.root.setMarkedObject(this.markerID, this.container)
+		}
+	}
 	this.root.setCurrentBuilder(this)
 }
 
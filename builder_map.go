@@ -16,7 +16,10 @@ type mapBuilder struct {
 	dstType reflect.Type
 	kvTypes [2]reflect.Type
 
-	// Cloned data (must be populated)
+	// Template data
+	kvTemplates [2]ObjectBuilder
+
+	// Cloned data (created on demand)
 	kvBuilders [2]ObjectBuilder
 
 	// Clone inserted data
@@ -37,19 +40,18 @@ func newMapBuilder(dstType reflect.Type) ObjectBuilder {
 }
 
 func (this *mapBuilder) PostCacheInitBuilder() {
-	this.kvBuilders[kvBuilderKey] = getBuilderForType(this.dstType.Key())
-	this.kvBuilders[kvBuilderValue] = getBuilderForType(this.dstType.Elem())
+	this.kvTemplates[kvBuilderKey] = getBuilderForType(this.dstType.Key())
+	this.kvTemplates[kvBuilderValue] = getBuilderForType(this.dstType.Elem())
 }
 
 func (this *mapBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
 	that := &mapBuilder{
-		dstType: this.dstType,
-		kvTypes: this.kvTypes,
-		parent:  parent,
-		root:    root,
+		dstType:     this.dstType,
+		kvTypes:     this.kvTypes,
+		kvTemplates: this.kvTemplates,
+		parent:      parent,
+		root:        root,
 	}
-	that.kvBuilders[kvBuilderKey] = this.kvBuilders[kvBuilderKey].CloneFromTemplate(root, that)
-	that.kvBuilders[kvBuilderValue] = this.kvBuilders[kvBuilderValue].CloneFromTemplate(root, that)
 	that.reset()
 	return that
 }
@@ -61,10 +63,16 @@ func (this *mapBuilder) reset() {
 }
 
 func (this *mapBuilder) getBuilder() ObjectBuilder {
-	return this.kvBuilders[this.builderIndex]
+	builder := this.kvBuilders[this.builderIndex]
+	if builder == nil {
+		builder = this.kvTemplates[this.builderIndex].CloneFromTemplate(this.root, this)
+		this.kvBuilders[this.builderIndex] = builder
+	}
+	return builder
 }
 
 func (this *mapBuilder) storeValue(value reflect.Value) {
+	this.root.markObject(value)
 	if this.builderIndex == kvBuilderKey {
 		this.key = value
 	} else {
@@ -79,7 +87,7 @@ func (this *mapBuilder) newElem() reflect.Value {
 
 func (this *mapBuilder) Nil(ignored reflect.Value) {
 	object := this.newElem()
-	this.getBuilder().Nil(object)
+	buildNil(this.getBuilder(), object)
 	this.storeValue(object)
 }
 
@@ -107,6 +115,12 @@ func (this *mapBuilder) Float(value float64, ignored reflect.Value) {
 	this.storeValue(object)
 }
 
+func (this *mapBuilder) Complex(value complex128, ignored reflect.Value) {
+	object := this.newElem()
+	this.getBuilder().Complex(value, object)
+	this.storeValue(object)
+}
+
 func (this *mapBuilder) String(value string, ignored reflect.Value) {
 	object := this.newElem()
 	this.getBuilder().String(value, object)
@@ -141,12 +155,16 @@ func (this *mapBuilder) End() {
 	this.parent.NotifyChildContainerFinished(object)
 }
 
-func (this *mapBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *mapBuilder) Reference(id interface{}) {
-	panic("TODO")
+	object := this.newElem()
+	if this.root.dereference(id, object) {
+		if this.builderIndex == kvBuilderKey {
+			this.root.addFixupCheck(id, object)
+		} else {
+			this.root.addEntryFixups(this.container, []entryFixup{{id: id, key: this.key}})
+		}
+	}
+	this.storeValue(object)
 }
 
 func (this *mapBuilder) PrepareForListContents() {
@@ -154,6 +172,7 @@ func (this *mapBuilder) PrepareForListContents() {
 }
 
 func (this *mapBuilder) PrepareForMapContents() {
+	this.root.markObject(this.container)
 	this.root.setCurrentBuilder(this)
 }
 
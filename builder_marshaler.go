@@ -0,0 +1,204 @@
+package reconstruct
+
+import (
+	"encoding"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Builds types that implement encoding.TextUnmarshaler from String events.
+type textUnmarshalerBuilder struct {
+	// Const data
+	dstType reflect.Type
+}
+
+func newTextUnmarshalerBuilder(dstType reflect.Type) ObjectBuilder {
+	return &textUnmarshalerBuilder{
+		dstType: dstType,
+	}
+}
+
+func (this *textUnmarshalerBuilder) PostCacheInitBuilder() {
+}
+
+func (this *textUnmarshalerBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return this
+}
+
+func (this *textUnmarshalerBuilder) Nil(dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Nil")
+}
+
+func (this *textUnmarshalerBuilder) Bool(value bool, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Bool")
+}
+
+func (this *textUnmarshalerBuilder) Int(value int64, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Int")
+}
+
+func (this *textUnmarshalerBuilder) Uint(value uint64, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Uint")
+}
+
+func (this *textUnmarshalerBuilder) Float(value float64, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Float")
+}
+
+func (this *textUnmarshalerBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Complex")
+}
+
+func (this *textUnmarshalerBuilder) String(value string, dst reflect.Value) {
+	unmarshalInto(dst, func(unmarshaler interface{}) error {
+		return unmarshaler.(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	})
+}
+
+func (this *textUnmarshalerBuilder) Bytes(value []byte, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Bytes")
+}
+
+func (this *textUnmarshalerBuilder) URI(value *url.URL, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "URI")
+}
+
+func (this *textUnmarshalerBuilder) Time(value time.Time, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Time")
+}
+
+func (this *textUnmarshalerBuilder) List() {
+	builderPanicBadEvent(this, this.dstType, "ListBegin")
+}
+
+func (this *textUnmarshalerBuilder) Map() {
+	builderPanicBadEvent(this, this.dstType, "MapBegin")
+}
+
+func (this *textUnmarshalerBuilder) End() {
+	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
+}
+
+func (this *textUnmarshalerBuilder) Reference(id interface{}) {
+	builderPanicBadEvent(this, this.dstType, "Reference")
+}
+
+func (this *textUnmarshalerBuilder) PrepareForListContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForListContents")
+}
+
+func (this *textUnmarshalerBuilder) PrepareForMapContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForMapContents")
+}
+
+func (this *textUnmarshalerBuilder) NotifyChildContainerFinished(value reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "NotifyChildContainerFinished")
+}
+
+// Builds types that implement encoding.BinaryUnmarshaler from Bytes events.
+type binaryUnmarshalerBuilder struct {
+	// Const data
+	dstType reflect.Type
+}
+
+func newBinaryUnmarshalerBuilder(dstType reflect.Type) ObjectBuilder {
+	return &binaryUnmarshalerBuilder{
+		dstType: dstType,
+	}
+}
+
+func (this *binaryUnmarshalerBuilder) PostCacheInitBuilder() {
+}
+
+func (this *binaryUnmarshalerBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return this
+}
+
+func (this *binaryUnmarshalerBuilder) Nil(dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Nil")
+}
+
+func (this *binaryUnmarshalerBuilder) Bool(value bool, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Bool")
+}
+
+func (this *binaryUnmarshalerBuilder) Int(value int64, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Int")
+}
+
+func (this *binaryUnmarshalerBuilder) Uint(value uint64, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Uint")
+}
+
+func (this *binaryUnmarshalerBuilder) Float(value float64, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Float")
+}
+
+func (this *binaryUnmarshalerBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Complex")
+}
+
+func (this *binaryUnmarshalerBuilder) String(value string, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "String")
+}
+
+func (this *binaryUnmarshalerBuilder) Bytes(value []byte, dst reflect.Value) {
+	unmarshalInto(dst, func(unmarshaler interface{}) error {
+		return unmarshaler.(encoding.BinaryUnmarshaler).UnmarshalBinary(value)
+	})
+}
+
+func (this *binaryUnmarshalerBuilder) URI(value *url.URL, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "URI")
+}
+
+func (this *binaryUnmarshalerBuilder) Time(value time.Time, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Time")
+}
+
+func (this *binaryUnmarshalerBuilder) List() {
+	builderPanicBadEvent(this, this.dstType, "ListBegin")
+}
+
+func (this *binaryUnmarshalerBuilder) Map() {
+	builderPanicBadEvent(this, this.dstType, "MapBegin")
+}
+
+func (this *binaryUnmarshalerBuilder) End() {
+	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
+}
+
+func (this *binaryUnmarshalerBuilder) Reference(id interface{}) {
+	builderPanicBadEvent(this, this.dstType, "Reference")
+}
+
+func (this *binaryUnmarshalerBuilder) PrepareForListContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForListContents")
+}
+
+func (this *binaryUnmarshalerBuilder) PrepareForMapContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForMapContents")
+}
+
+func (this *binaryUnmarshalerBuilder) NotifyChildContainerFinished(value reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "NotifyChildContainerFinished")
+}
+
+// Call unmarshal with a pointer to dst (or to a temporary copy if dst isn't
+// addressable), converting any error into a BuildError.
+func unmarshalInto(dst reflect.Value, unmarshal func(unmarshaler interface{}) error) {
+	ptr := reflect.New(dst.Type())
+	if dst.CanAddr() {
+		ptr = dst.Addr()
+	}
+	if err := unmarshal(ptr.Interface()); err != nil {
+		panic(&BuildError{
+			DstType: dst.Type(),
+			Reason:  err.Error(),
+		})
+	}
+	if !dst.CanAddr() {
+		dst.Set(ptr.Elem())
+	}
+}
@@ -0,0 +1,159 @@
+package reconstruct
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Builds a map into an interface, using a type discriminator as the first key
+// to select the registered concrete type to build. A map with no discriminator
+// is built as map[interface{}]interface{} if the interface can hold one.
+//
+// This builder is created on demand for each map, rather than being cached.
+type polymorphicBuilder struct {
+	dstType reflect.Type
+	root    *RootBuilder
+	parent  ObjectBuilder
+
+	nextIsTypeName bool
+	concreteType   reflect.Type
+}
+
+func newPolymorphicBuilder(root *RootBuilder, parent ObjectBuilder, dstType reflect.Type) ObjectBuilder {
+	return &polymorphicBuilder{
+		dstType: dstType,
+		root:    root,
+		parent:  parent,
+	}
+}
+
+// Build the map as a generic map, passing it the event that we couldn't use.
+func (this *polymorphicBuilder) fallBack(event string) ObjectBuilder {
+	if this.nextIsTypeName {
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  fmt.Sprintf("Type discriminator %v must be a string, not %v", this.root.options.TypeKey, event),
+		})
+	}
+	if !builderIntfIntfMapType.AssignableTo(this.dstType) {
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  fmt.Sprintf("Expected type discriminator %v as the first key", this.root.options.TypeKey),
+		})
+	}
+	builder := globalIntfMapBuilder.CloneFromTemplate(this.root, this.parent)
+	builder.PrepareForMapContents()
+	return builder
+}
+
+func (this *polymorphicBuilder) beginConcreteType(name string) {
+	registry := this.root.options.TypeRegistry
+	concreteType, ok := registry.TypeForName(name)
+	if !ok {
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  fmt.Sprintf("Type name %v is not registered", name),
+		})
+	}
+	if !concreteType.AssignableTo(this.dstType) {
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  fmt.Sprintf("Registered type %v (%v) is not assignable to %v", concreteType, name, this.dstType),
+		})
+	}
+
+	this.concreteType = concreteType
+	structType := concreteType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	builder := getBuilderForType(structType).CloneFromTemplate(this.root, this)
+	builder.PrepareForMapContents()
+}
+
+func (this *polymorphicBuilder) PostCacheInitBuilder() {
+}
+
+func (this *polymorphicBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return newPolymorphicBuilder(root, parent, this.dstType)
+}
+
+func (this *polymorphicBuilder) Nil(dst reflect.Value) {
+	this.fallBack("Nil").Nil(dst)
+}
+
+func (this *polymorphicBuilder) Bool(value bool, dst reflect.Value) {
+	this.fallBack("Bool").Bool(value, dst)
+}
+
+func (this *polymorphicBuilder) Int(value int64, dst reflect.Value) {
+	this.fallBack("Int").Int(value, dst)
+}
+
+func (this *polymorphicBuilder) Uint(value uint64, dst reflect.Value) {
+	this.fallBack("Uint").Uint(value, dst)
+}
+
+func (this *polymorphicBuilder) Float(value float64, dst reflect.Value) {
+	this.fallBack("Float").Float(value, dst)
+}
+
+func (this *polymorphicBuilder) Complex(value complex128, dst reflect.Value) {
+	this.fallBack("Complex").Complex(value, dst)
+}
+
+func (this *polymorphicBuilder) String(value string, dst reflect.Value) {
+	switch {
+	case this.nextIsTypeName:
+		this.beginConcreteType(value)
+	case value == this.root.options.TypeKey:
+		this.nextIsTypeName = true
+	default:
+		this.fallBack("String").String(value, dst)
+	}
+}
+
+func (this *polymorphicBuilder) Bytes(value []byte, dst reflect.Value) {
+	this.fallBack("Bytes").Bytes(value, dst)
+}
+
+func (this *polymorphicBuilder) URI(value *url.URL, dst reflect.Value) {
+	this.fallBack("URI").URI(value, dst)
+}
+
+func (this *polymorphicBuilder) Time(value time.Time, dst reflect.Value) {
+	this.fallBack("Time").Time(value, dst)
+}
+
+func (this *polymorphicBuilder) List() {
+	this.fallBack("ListBegin").List()
+}
+
+func (this *polymorphicBuilder) Map() {
+	this.fallBack("MapBegin").Map()
+}
+
+func (this *polymorphicBuilder) End() {
+	this.fallBack("ContainerEnd").End()
+}
+
+func (this *polymorphicBuilder) Reference(id interface{}) {
+	this.fallBack("Reference").Reference(id)
+}
+
+func (this *polymorphicBuilder) PrepareForListContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForListContents")
+}
+
+func (this *polymorphicBuilder) PrepareForMapContents() {
+	this.root.setCurrentBuilder(this)
+}
+
+func (this *polymorphicBuilder) NotifyChildContainerFinished(value reflect.Value) {
+	if this.concreteType.Kind() == reflect.Ptr {
+		value = value.Addr()
+	}
+	this.parent.NotifyChildContainerFinished(value)
+}
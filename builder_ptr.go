@@ -10,7 +10,10 @@ type ptrBuilder struct {
 	// Const data
 	dstType reflect.Type
 
-	// Cloned data (must be populated)
+	// Template data
+	elemTemplate ObjectBuilder
+
+	// Cloned data (created on demand)
 	elemBuilder ObjectBuilder
 
 	// Clone inserted data
@@ -25,19 +28,29 @@ func newPtrBuilder(dstType reflect.Type) ObjectBuilder {
 }
 
 func (this *ptrBuilder) PostCacheInitBuilder() {
-	this.elemBuilder = getBuilderForType(this.dstType.Elem())
+	this.elemTemplate = getBuilderForType(this.dstType.Elem())
 }
 
 func (this *ptrBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
 	that := &ptrBuilder{
-		dstType: this.dstType,
-		parent:  parent,
-		root:    root,
+		dstType:      this.dstType,
+		elemTemplate: this.elemTemplate,
+		parent:       parent,
+		root:         root,
 	}
-	that.elemBuilder = this.elemBuilder.CloneFromTemplate(root, that)
 	return that
 }
 
+// The element builder is cloned on first use rather than in CloneFromTemplate,
+// since a recursive type (such as a linked list node) would otherwise clone
+// forever.
+func (this *ptrBuilder) getElemBuilder() ObjectBuilder {
+	if this.elemBuilder == nil {
+		this.elemBuilder = this.elemTemplate.CloneFromTemplate(this.root, this)
+	}
+	return this.elemBuilder
+}
+
 func (this *ptrBuilder) newElem() reflect.Value {
 	return reflect.New(this.dstType.Elem())
 }
@@ -48,49 +61,55 @@ func (this *ptrBuilder) Nil(dst reflect.Value) {
 
 func (this *ptrBuilder) Bool(value bool, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.Bool(value, ptr.Elem())
+	this.getElemBuilder().Bool(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) Int(value int64, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.Int(value, ptr.Elem())
+	this.getElemBuilder().Int(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) Uint(value uint64, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.Uint(value, ptr.Elem())
+	this.getElemBuilder().Uint(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) Float(value float64, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.Float(value, ptr.Elem())
+	this.getElemBuilder().Float(value, ptr.Elem())
+	dst.Set(ptr)
+}
+
+func (this *ptrBuilder) Complex(value complex128, dst reflect.Value) {
+	ptr := this.newElem()
+	this.getElemBuilder().Complex(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) String(value string, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.String(value, ptr.Elem())
+	this.getElemBuilder().String(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) Bytes(value []byte, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.Bytes(value, ptr.Elem())
+	this.getElemBuilder().Bytes(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) URI(value *url.URL, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.URI(value, ptr.Elem())
+	this.getElemBuilder().URI(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
 func (this *ptrBuilder) Time(value time.Time, dst reflect.Value) {
 	ptr := this.newElem()
-	this.elemBuilder.Time(value, ptr.Elem())
+	this.getElemBuilder().Time(value, ptr.Elem())
 	dst.Set(ptr)
 }
 
@@ -106,20 +125,16 @@ func (this *ptrBuilder) End() {
 	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
 }
 
-func (this *ptrBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *ptrBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
 
 func (this *ptrBuilder) PrepareForListContents() {
-	this.elemBuilder.PrepareForListContents()
+	this.getElemBuilder().PrepareForListContents()
 }
 
 func (this *ptrBuilder) PrepareForMapContents() {
-	this.elemBuilder.PrepareForMapContents()
+	this.getElemBuilder().PrepareForMapContents()
 }
 
 func (this *ptrBuilder) NotifyChildContainerFinished(value reflect.Value) {
@@ -1,13 +1,21 @@
 package reconstruct
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/url"
 	"reflect"
+	"strconv"
 	"time"
 )
 
+// GetBuiltObject returns the object that was built, or nil if the build failed.
 func (this *RootBuilder) GetBuiltObject() interface{} {
 	// TODO: Verify this behavior
+	if this.err != nil || this.isNil {
+		return nil
+	}
 	if this.object.IsValid() {
 		v := this.object
 		switch v.Kind() {
@@ -22,22 +30,70 @@ func (this *RootBuilder) GetBuiltObject() interface{} {
 	return nil
 }
 
+// StoreBuiltObject stores the object that was built into target, which must be
+// a non-nil pointer to a variable of the type being built (or of a pointer to
+// that type). A nil built from a pointer template can only be stored into a
+// pointer, interface, map, or slice.
+func (this *RootBuilder) StoreBuiltObject(target interface{}) error {
+	if this.err != nil {
+		return this.err
+	}
+	dst := reflect.ValueOf(target)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("Cannot store into %v: Target must be a non-nil pointer", reflect.TypeOf(target))
+	}
+	dst = dst.Elem()
+
+	object := this.GetBuiltObject()
+	if object == nil {
+		switch dst.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		default:
+			return fmt.Errorf("Cannot store nil into %v", dst.Type())
+		}
+	}
+	value := reflect.ValueOf(object)
+	if !value.Type().AssignableTo(dst.Type()) && value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if !value.Type().AssignableTo(dst.Type()) {
+		return fmt.Errorf("Cannot store built %v into %v", value.Type(), dst.Type())
+	}
+	dst.Set(value)
+	return nil
+}
+
 // RootBuilder adapts ObjectIteratorCallbacks to ObjectBuilder, coordinates the
 // build, and provides GetBuiltObject() for fetching the final result.
 type RootBuilder struct {
-	dstType        reflect.Type
-	currentBuilder ObjectBuilder
-	object         reflect.Value
+	dstType          reflect.Type
+	currentBuilder   ObjectBuilder
+	object           reflect.Value
+	markedObjects    map[interface{}]reflect.Value
+	unfinished       map[interface{}][]func(object reflect.Value)
+	pendingMarkerID  interface{}
+	hasPendingMarker bool
+	typeHint         reflect.Type
+	path             pathTracker
+	isPtr            bool
+	isNil            bool
+	options          BuilderOptions
+	err              error
 }
 
 // -----------
 // RootBuilder
 // -----------
 
-func newRootBuilder(dstType reflect.Type) *RootBuilder {
+func newRootBuilder(dstType reflect.Type, options BuilderOptions) *RootBuilder {
 	this := &RootBuilder{
-		dstType: dstType,
-		object:  reflect.New(dstType).Elem(),
+		dstType:       dstType,
+		options:       options,
+		object:        reflect.New(dstType).Elem(),
+		markedObjects: make(map[interface{}]reflect.Value),
+		unfinished:    make(map[interface{}][]func(object reflect.Value)),
 	}
 
 	builder := getTopLevelBuilderForType(dstType)
@@ -50,6 +106,289 @@ func (this *RootBuilder) setCurrentBuilder(builder ObjectBuilder) {
 	this.currentBuilder = builder
 }
 
+// Converts a BuildError panic from a builder into an error, leaving the
+// RootBuilder in a failed state. Any other panic is a bug, and is passed on.
+// This must be deferred directly by the event handler.
+func (this *RootBuilder) recoverBuildError(event string, err *error) {
+	if r := recover(); r != nil {
+		buildErr, ok := r.(*BuildError)
+		if !ok {
+			panic(r)
+		}
+		buildErr.Event = event
+		buildErr.Path = this.path.path()
+		this.err = buildErr
+		*err = buildErr
+	}
+}
+
+// Take ownership of the marker ID (if any) that applies to the next object.
+// Builders that can't know their final object until they're finished (such as
+// slices) call this when they begin, followed by beginUnfinishedObject.
+func (this *RootBuilder) takePendingMarker() (id interface{}, isMarked bool) {
+	id, isMarked = this.pendingMarkerID, this.hasPendingMarker
+	this.pendingMarkerID = nil
+	this.hasPendingMarker = false
+	return
+}
+
+func (this *RootBuilder) setMarkedObject(id interface{}, object reflect.Value) {
+	this.markedObjects[id] = object
+}
+
+// Mark an object that can be referenced from inside itself, but whose final
+// value isn't known until it ends (a slice may be reallocated as it grows).
+// References get placeholder until then. If placeholder is addressable,
+// pointers to it remain valid because it will be set to the final object.
+func (this *RootBuilder) beginUnfinishedObject(id interface{}, placeholder reflect.Value) {
+	this.markedObjects[id] = placeholder
+	this.unfinished[id] = nil
+}
+
+// Replace the placeholder for an unfinished object with the final object, and
+// fix up any copies of the placeholder that references have stored.
+func (this *RootBuilder) finishUnfinishedObject(id interface{}, object reflect.Value) {
+	placeholder := this.markedObjects[id]
+	if placeholder.CanSet() && placeholder.Type() == object.Type() {
+		placeholder.Set(object)
+	} else {
+		this.markedObjects[id] = object
+	}
+	fixups := this.unfinished[id]
+	delete(this.unfinished, id)
+	for _, fixup := range fixups {
+		fixup(object)
+	}
+}
+
+// Arrange for fixup to be called with the final object once the unfinished
+// object marked with id has been finished.
+func (this *RootBuilder) addFixup(id interface{}, fixup func(object reflect.Value)) {
+	this.unfinished[id] = append(this.unfinished[id], fixup)
+}
+
+// A copy of an unfinished object that was stored into a container entry while
+// the container was being built. The fixup can't be set up until the container
+// is finished, because until then it may be reallocated or replaced.
+type entryFixup struct {
+	id    interface{}
+	index int
+	key   reflect.Value
+}
+
+// Arrange for the entries of a finished slice or map container to be fixed up.
+func (this *RootBuilder) addEntryFixups(container reflect.Value, fixups []entryFixup) {
+	for _, fixup := range fixups {
+		if container.Kind() == reflect.Map {
+			key := fixup.key
+			this.addFixup(fixup.id, func(object reflect.Value) {
+				container.SetMapIndex(key, object)
+			})
+		} else {
+			elem := container.Index(fixup.index)
+			this.addFixup(fixup.id, func(object reflect.Value) {
+				elem.Set(object)
+			})
+		}
+	}
+}
+
+// Arrange for a copy of an unfinished object, stored somewhere that can't be
+// updated later, to cause an error if it turns out not to be the final object.
+func (this *RootBuilder) addFixupCheck(id interface{}, copied reflect.Value) {
+	if copied.Kind() == reflect.Interface {
+		copied = copied.Elem()
+	}
+	dstType, pointer, length := copied.Type(), copied.Pointer(), 0
+	if copied.Kind() == reflect.Slice {
+		length = copied.Len()
+	}
+	this.addFixup(id, func(object reflect.Value) {
+		if object.Kind() == reflect.Slice && object.Len() != length || object.Pointer() != pointer {
+			panic(&BuildError{
+				DstType: dstType,
+				Reason:  fmt.Sprintf("Cannot store a copy of marked object %v from within itself", id),
+			})
+		}
+	})
+}
+
+// Associate object with the pending marker ID, if any. Container builders call
+// this as early as possible so that cyclic references can be resolved while
+// the container is still being built.
+func (this *RootBuilder) markObject(object reflect.Value) {
+	if id, isMarked := this.takePendingMarker(); isMarked {
+		this.setMarkedObject(id, object)
+	}
+}
+
+// Store the object marked with id into dst. Addressable objects will be stored
+// by pointer if that's what dst requires, which preserves the identity of
+// shared and cyclic pointers.
+//
+// Returns true if dst received a copy of an unfinished object, in which case
+// the caller must use addFixup or addFixupCheck once dst's final location is
+// known.
+func (this *RootBuilder) dereference(id interface{}, dst reflect.Value) (isUnfinished bool) {
+	object, ok := this.markedObjects[id]
+	if !ok {
+		panic(&BuildError{
+			DstType: dst.Type(),
+			Reason:  fmt.Sprintf("Reference to unknown marker ID %v", id),
+		})
+	}
+
+	dstType := dst.Type()
+	if dstType.Kind() == reflect.Interface {
+		if hint := this.takeTypeHint(dstType); hint != nil {
+			hinted := reflect.New(hint).Elem()
+			isUnfinished = this.dereference(id, hinted)
+			dst.Set(hinted)
+			return
+		}
+	}
+
+	objectType := object.Type()
+	switch {
+	case objectType.AssignableTo(dstType):
+		dst.Set(object)
+		_, isUnfinished = this.unfinished[id]
+	case object.CanAddr() && reflect.PtrTo(objectType).AssignableTo(dstType):
+		dst.Set(object.Addr())
+	case objectType.Kind() == reflect.Ptr && objectType.Elem().AssignableTo(dstType):
+		dst.Set(object.Elem())
+	default:
+		builderPanicCannotConvert(object, dstType)
+	}
+	return
+}
+
+// Take the pending type hint, provided that the hinted type can be stored in
+// an interface of type intfType. Returns nil if there's no usable hint.
+func (this *RootBuilder) takeTypeHint(intfType reflect.Type) reflect.Type {
+	hint := this.typeHint
+	this.typeHint = nil
+	if hint == nil || !hint.AssignableTo(intfType) {
+		return nil
+	}
+	return hint
+}
+
+// Convert a scalar value that is about to be stored in an interface of type
+// intfType into the hinted type if there is one, or otherwise into the type
+// that the options call for.
+func (this *RootBuilder) intfValue(value reflect.Value, intfType reflect.Type) reflect.Value {
+	hint := this.takeTypeHint(intfType)
+	if hint == nil {
+		if converted := this.convertNumber(value); converted.Type().AssignableTo(intfType) {
+			return converted
+		}
+		return value
+	}
+
+	dst := reflect.New(hint).Elem()
+	builder := getBuilderForType(hint).CloneFromTemplate(this, this)
+	var v interface{}
+	if value.IsValid() && value.CanInterface() {
+		v = value.Interface()
+	}
+	switch v := v.(type) {
+	case nil:
+		builder.Nil(dst)
+	case bool:
+		builder.Bool(v, dst)
+	case int64:
+		builder.Int(v, dst)
+	case uint64:
+		builder.Uint(v, dst)
+	case float64:
+		builder.Float(v, dst)
+	case complex128:
+		builder.Complex(v, dst)
+	case string:
+		builder.String(v, dst)
+	case []byte:
+		builder.Bytes(v, dst)
+	case *url.URL:
+		builder.URI(v, dst)
+	case time.Time:
+		builder.Time(v, dst)
+	default:
+		return value
+	}
+	return dst
+}
+
+const (
+	maxInt = int64(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+func (this *RootBuilder) convertNumber(value reflect.Value) reflect.Value {
+	if !value.CanInterface() {
+		return value
+	}
+	switch v := value.Interface().(type) {
+	case int64:
+		switch {
+		case this.options.UseNumber:
+			return reflect.ValueOf(json.Number(strconv.FormatInt(v, 10)))
+		case this.options.UseInt && v >= minInt && v <= maxInt:
+			return reflect.ValueOf(int(v))
+		}
+	case uint64:
+		switch {
+		case this.options.UseNumber:
+			return reflect.ValueOf(json.Number(strconv.FormatUint(v, 10)))
+		case this.options.UseInt && v <= uint64(maxInt):
+			return reflect.ValueOf(int(v))
+		}
+	case float64:
+		// NaN and infinity have no numeric representation
+		if this.options.UseNumber && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return reflect.ValueOf(json.Number(strconv.FormatFloat(v, 'g', -1, 64)))
+		}
+	}
+	return value
+}
+
+// Begin building a container into an interface of type intfType as the hinted
+// type, if there is one. Returns false if the caller should build the
+// container generically.
+func (this *RootBuilder) prepareHintedContainer(intfType reflect.Type, parent ObjectBuilder, isMap bool) bool {
+	hint := this.takeTypeHint(intfType)
+	if hint == nil {
+		return false
+	}
+	if isMap && this.options.TypeRegistry != nil {
+		// Registered types are iterated with a discriminator, which the
+		// polymorphic builder knows how to consume.
+		if _, ok := this.options.TypeRegistry.NameForType(hint); ok {
+			return false
+		}
+	}
+
+	builder := getBuilderForType(hint).CloneFromTemplate(this, parent)
+	if isMap {
+		builder.PrepareForMapContents()
+	} else {
+		builder.PrepareForListContents()
+	}
+	return true
+}
+
+// Every value event consumes the type hint, whether the destination used it or
+// not.
+func (this *RootBuilder) onValue(value interface{}) {
+	this.typeHint = nil
+	this.path.onValue(value)
+}
+
+func (this *RootBuilder) onContainerBegin(isMap bool) {
+	this.typeHint = nil
+	this.path.onContainerBegin(isMap)
+}
+
 // -------------
 // ObjectBuilder
 // -------------
@@ -75,6 +414,9 @@ func (this *RootBuilder) Uint(value uint64, dst reflect.Value) {
 func (this *RootBuilder) Float(value float64, dst reflect.Value) {
 	this.currentBuilder.Float(value, dst)
 }
+func (this *RootBuilder) Complex(value complex128, dst reflect.Value) {
+	this.currentBuilder.Complex(value, dst)
+}
 func (this *RootBuilder) String(value string, dst reflect.Value) {
 	this.currentBuilder.String(value, dst)
 }
@@ -97,10 +439,11 @@ func (this *RootBuilder) End() {
 	this.currentBuilder.End()
 }
 func (this *RootBuilder) Marker(id interface{}) {
-	panic("TODO")
+	this.pendingMarkerID = id
+	this.hasPendingMarker = true
 }
 func (this *RootBuilder) Reference(id interface{}) {
-	panic("TODO")
+	this.currentBuilder.Reference(id)
 }
 func (this *RootBuilder) PrepareForListContents() {
 	panic("BUG")
@@ -116,63 +459,154 @@ func (this *RootBuilder) NotifyChildContainerFinished(value reflect.Value) {
 // ObjectIteratorCallbacks
 // -----------------------
 
-func (this *RootBuilder) OnNil() error {
-	this.Nil(this.object)
-	return nil
+func (this *RootBuilder) OnNil() (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Nil", &err)
+	if this.isPtr && len(this.path.containers) == 0 {
+		// Builders are made for the pointed-to type, so a top-level nil
+		// pointer is recorded here instead.
+		this.isNil = true
+	} else {
+		this.Nil(this.object)
+	}
+	this.onValue(nil)
+	return
 }
-func (this *RootBuilder) OnBool(value bool) error {
+func (this *RootBuilder) OnBool(value bool) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Bool", &err)
 	this.Bool(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnInt(value int64) error {
+func (this *RootBuilder) OnInt(value int64) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Int", &err)
 	this.Int(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnUint(value uint64) error {
+func (this *RootBuilder) OnUint(value uint64) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Uint", &err)
 	this.Uint(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnFloat(value float64) error {
+func (this *RootBuilder) OnFloat(value float64) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Float", &err)
 	this.Float(value, this.object)
-	return nil
-}
-func (this *RootBuilder) OnComplex(value complex128) error {
-	panic("TODO")
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnString(value string) error {
+func (this *RootBuilder) OnComplex(value complex128) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Complex", &err)
+	this.Complex(value, this.object)
+	this.onValue(value)
+	return
+}
+func (this *RootBuilder) OnString(value string) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("String", &err)
 	this.String(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnBytes(value []byte) error {
+func (this *RootBuilder) OnBytes(value []byte) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Bytes", &err)
 	this.Bytes(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnURI(value *url.URL) error {
+func (this *RootBuilder) OnURI(value *url.URL) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("URI", &err)
 	this.URI(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnTime(value time.Time) error {
+func (this *RootBuilder) OnTime(value time.Time) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Time", &err)
 	this.Time(value, this.object)
-	return nil
+	this.onValue(value)
+	return
 }
-func (this *RootBuilder) OnListBegin() error {
+func (this *RootBuilder) OnListBegin() (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("ListBegin", &err)
 	this.List()
-	return nil
+	this.onContainerBegin(false)
+	return
 }
-func (this *RootBuilder) OnMapBegin() error {
+func (this *RootBuilder) OnMapBegin() (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("MapBegin", &err)
 	this.Map()
-	return nil
+	this.onContainerBegin(true)
+	return
 }
-func (this *RootBuilder) OnContainerEnd() error {
+func (this *RootBuilder) OnContainerEnd() (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("ContainerEnd", &err)
 	this.End()
-	return nil
+	this.typeHint = nil
+	this.path.onContainerEnd()
+	return
 }
-func (this *RootBuilder) OnMarker(id interface{}) error {
+func (this *RootBuilder) OnMarker(id interface{}) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Marker", &err)
 	this.Marker(id)
-	return nil
+	return
 }
-func (this *RootBuilder) OnReference(id interface{}) error {
+func (this *RootBuilder) OnReference(id interface{}) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	defer this.recoverBuildError("Reference", &err)
 	this.Reference(id)
-	return nil
+	this.onValue(id)
+	return
+}
+
+// Type hints are consumed by the builders for interfaces, causing the next
+// value to be built as the hinted type rather than its default type.
+func (this *RootBuilder) OnTypeHint(t reflect.Type) (err error) {
+	if this.err != nil {
+		return this.err
+	}
+	this.typeHint = t
+	return
 }
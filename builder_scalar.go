@@ -29,22 +29,32 @@ func (this *scalarBuilder) Nil(dst reflect.Value) {
 }
 
 func (this *scalarBuilder) Bool(value bool, dst reflect.Value) {
+	if this.dstType.Kind() != reflect.Bool {
+		builderPanicBadEvent(this, this.dstType, "Bool")
+	}
 	dst.SetBool(value)
 }
 
 func (this *scalarBuilder) Int(value int64, dst reflect.Value) {
-	dst.SetInt(value)
+	builderPanicBadEvent(this, this.dstType, "Int")
 }
 
 func (this *scalarBuilder) Uint(value uint64, dst reflect.Value) {
-	dst.SetUint(value)
+	builderPanicBadEvent(this, this.dstType, "Uint")
 }
 
 func (this *scalarBuilder) Float(value float64, dst reflect.Value) {
-	dst.SetFloat(value)
+	builderPanicBadEvent(this, this.dstType, "Float")
+}
+
+func (this *scalarBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Complex")
 }
 
 func (this *scalarBuilder) String(value string, dst reflect.Value) {
+	if this.dstType.Kind() != reflect.String {
+		builderPanicBadEvent(this, this.dstType, "String")
+	}
 	dst.SetString(value)
 }
 
@@ -57,7 +67,7 @@ func (this *scalarBuilder) URI(value *url.URL, dst reflect.Value) {
 }
 
 func (this *scalarBuilder) Time(value time.Time, dst reflect.Value) {
-	dst.Set(reflect.ValueOf(value))
+	builderPanicBadEvent(this, this.dstType, "Time")
 }
 
 func (this *scalarBuilder) List() {
@@ -72,12 +82,8 @@ func (this *scalarBuilder) End() {
 	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
 }
 
-func (this *scalarBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *scalarBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
 
 func (this *scalarBuilder) PrepareForListContents() {
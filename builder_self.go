@@ -0,0 +1,181 @@
+package reconstruct
+
+import (
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// Forwards the events for one object to the callbacks returned by a
+// SelfBuilder, then passes the built object on like any other builder would.
+type selfBuilderAdapter struct {
+	// Const data
+	dstType reflect.Type
+
+	// Clone inserted data
+	root   *RootBuilder
+	parent ObjectBuilder
+
+	// Variable data (must be reset)
+	container reflect.Value
+	callbacks ObjectIteratorCallbacks
+	depth     int
+}
+
+func newSelfBuilderAdapter(dstType reflect.Type) ObjectBuilder {
+	return &selfBuilderAdapter{
+		dstType: dstType,
+	}
+}
+
+func (this *selfBuilderAdapter) PostCacheInitBuilder() {
+}
+
+func (this *selfBuilderAdapter) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return &selfBuilderAdapter{
+		dstType: this.dstType,
+		root:    root,
+		parent:  parent,
+	}
+}
+
+func (this *selfBuilderAdapter) reset() {
+	this.container = reflect.Value{}
+	this.callbacks = nil
+	this.depth = 0
+}
+
+func (this *selfBuilderAdapter) newSelfBuilder(dst reflect.Value) ObjectIteratorCallbacks {
+	return dst.Addr().Interface().(SelfBuilder).NewSelfBuilder()
+}
+
+func (this *selfBuilderAdapter) check(err error) {
+	if err != nil {
+		if buildErr, ok := err.(*BuildError); ok {
+			panic(buildErr)
+		}
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  err.Error(),
+		})
+	}
+}
+
+// Forward any pending marker that applies to an object inside our container.
+func (this *selfBuilderAdapter) forwardMarker() {
+	if id, isMarked := this.root.takePendingMarker(); isMarked {
+		this.check(this.callbacks.OnMarker(id))
+	}
+}
+
+// Build a scalar, either as the whole object or as part of our container.
+func (this *selfBuilderAdapter) scalar(dst reflect.Value, event func(callbacks ObjectIteratorCallbacks) error) {
+	if this.depth > 0 {
+		this.forwardMarker()
+		this.check(event(this.callbacks))
+		return
+	}
+
+	object := reflect.New(this.dstType).Elem()
+	this.check(event(this.newSelfBuilder(object)))
+	dst.Set(object)
+}
+
+func (this *selfBuilderAdapter) beginContainer(isMap bool) {
+	if this.depth > 0 {
+		this.forwardMarker()
+	} else {
+		this.container = reflect.New(this.dstType).Elem()
+		this.root.markObject(this.container)
+		this.callbacks = this.newSelfBuilder(this.container)
+		this.root.setCurrentBuilder(this)
+	}
+	this.depth++
+	if isMap {
+		this.check(this.callbacks.OnMapBegin())
+	} else {
+		this.check(this.callbacks.OnListBegin())
+	}
+}
+
+func (this *selfBuilderAdapter) Nil(dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnNil() })
+}
+
+func (this *selfBuilderAdapter) Bool(value bool, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnBool(value) })
+}
+
+func (this *selfBuilderAdapter) Int(value int64, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnInt(value) })
+}
+
+func (this *selfBuilderAdapter) Uint(value uint64, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnUint(value) })
+}
+
+func (this *selfBuilderAdapter) Float(value float64, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnFloat(value) })
+}
+
+func (this *selfBuilderAdapter) Complex(value complex128, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnComplex(value) })
+}
+
+func (this *selfBuilderAdapter) String(value string, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnString(value) })
+}
+
+func (this *selfBuilderAdapter) Bytes(value []byte, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnBytes(value) })
+}
+
+func (this *selfBuilderAdapter) URI(value *url.URL, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnURI(value) })
+}
+
+func (this *selfBuilderAdapter) Time(value time.Time, dst reflect.Value) {
+	this.scalar(dst, func(callbacks ObjectIteratorCallbacks) error { return callbacks.OnTime(value) })
+}
+
+// At depth 0, only reached when this is the top-level object.
+func (this *selfBuilderAdapter) List() {
+	this.beginContainer(false)
+}
+
+// At depth 0, only reached when this is the top-level object.
+func (this *selfBuilderAdapter) Map() {
+	this.beginContainer(true)
+}
+
+func (this *selfBuilderAdapter) End() {
+	if this.depth == 0 {
+		builderPanicBadEvent(this, this.dstType, "ContainerEnd")
+	}
+	this.check(this.callbacks.OnContainerEnd())
+	this.depth--
+	if this.depth == 0 {
+		object := this.container
+		this.reset()
+		this.parent.NotifyChildContainerFinished(object)
+	}
+}
+
+func (this *selfBuilderAdapter) Reference(id interface{}) {
+	if this.depth == 0 {
+		builderPanicBadEvent(this, this.dstType, "Reference")
+	}
+	this.check(this.callbacks.OnReference(id))
+}
+
+func (this *selfBuilderAdapter) PrepareForListContents() {
+	this.beginContainer(false)
+}
+
+func (this *selfBuilderAdapter) PrepareForMapContents() {
+	this.beginContainer(true)
+}
+
+func (this *selfBuilderAdapter) NotifyChildContainerFinished(value reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "NotifyChildContainerFinished")
+}
@@ -12,7 +12,10 @@ type sliceBuilder struct {
 	// Const data
 	dstType reflect.Type
 
-	// Cloned data (must be populated)
+	// Template data
+	elemTemplate ObjectBuilder
+
+	// Cloned data (created on demand)
 	elemBuilder ObjectBuilder
 
 	// Clone inserted data
@@ -21,6 +24,9 @@ type sliceBuilder struct {
 
 	// Variable data (must be reset)
 	container reflect.Value
+	markerID  interface{}
+	isMarked  bool
+	fixups    []entryFixup
 }
 
 func newSliceBuilder(dstType reflect.Type) ObjectBuilder {
@@ -30,22 +36,32 @@ func newSliceBuilder(dstType reflect.Type) ObjectBuilder {
 }
 
 func (this *sliceBuilder) PostCacheInitBuilder() {
-	this.elemBuilder = getBuilderForType(this.dstType.Elem())
+	this.elemTemplate = getBuilderForType(this.dstType.Elem())
 }
 
 func (this *sliceBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
 	that := &sliceBuilder{
-		dstType: this.dstType,
-		parent:  parent,
-		root:    root,
+		dstType:      this.dstType,
+		elemTemplate: this.elemTemplate,
+		parent:       parent,
+		root:         root,
 	}
-	that.elemBuilder = this.elemBuilder.CloneFromTemplate(root, that)
 	that.reset()
 	return that
 }
 
+func (this *sliceBuilder) getElemBuilder() ObjectBuilder {
+	if this.elemBuilder == nil {
+		this.elemBuilder = this.elemTemplate.CloneFromTemplate(this.root, this)
+	}
+	return this.elemBuilder
+}
+
 func (this *sliceBuilder) reset() {
 	this.container = reflect.MakeSlice(this.dstType, 0, defaultSliceCap)
+	this.markerID = nil
+	this.isMarked = false
+	this.fixups = nil
 }
 
 func (this *sliceBuilder) newElem() reflect.Value {
@@ -53,86 +69,101 @@ func (this *sliceBuilder) newElem() reflect.Value {
 }
 
 func (this *sliceBuilder) storeValue(value reflect.Value) {
+	this.root.markObject(value)
 	this.container = reflect.Append(this.container, value)
 }
 
 func (this *sliceBuilder) Nil(ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Nil(object)
+	buildNil(this.getElemBuilder(), object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) Bool(value bool, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Bool(value, object)
+	this.getElemBuilder().Bool(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) Int(value int64, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Int(value, object)
+	this.getElemBuilder().Int(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) Uint(value uint64, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Uint(value, object)
+	this.getElemBuilder().Uint(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) Float(value float64, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Float(value, object)
+	this.getElemBuilder().Float(value, object)
+	this.storeValue(object)
+}
+
+func (this *sliceBuilder) Complex(value complex128, ignored reflect.Value) {
+	object := this.newElem()
+	this.getElemBuilder().Complex(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) String(value string, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.String(value, object)
+	this.getElemBuilder().String(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) Bytes(value []byte, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Bytes(value, object)
+	this.getElemBuilder().Bytes(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) URI(value *url.URL, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.URI(value, object)
+	this.getElemBuilder().URI(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) Time(value time.Time, ignored reflect.Value) {
 	object := this.newElem()
-	this.elemBuilder.Time(value, object)
+	this.getElemBuilder().Time(value, object)
 	this.storeValue(object)
 }
 
 func (this *sliceBuilder) List() {
-	this.elemBuilder.PrepareForListContents()
+	this.getElemBuilder().PrepareForListContents()
 }
 
 func (this *sliceBuilder) Map() {
-	this.elemBuilder.PrepareForMapContents()
+	this.getElemBuilder().PrepareForMapContents()
 }
 
 func (this *sliceBuilder) End() {
 	object := this.container
+	this.root.addEntryFixups(object, this.fixups)
+	if this.isMarked {
+		this.root.finishUnfinishedObject(this.markerID, object)
+	}
 	this.reset()
 	this.parent.NotifyChildContainerFinished(object)
 }
 
-func (this *sliceBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *sliceBuilder) Reference(id interface{}) {
-	panic("TODO")
+	object := this.newElem()
+	if this.root.dereference(id, object) {
+		this.fixups = append(this.fixups, entryFixup{id: id, index: this.container.Len()})
+	}
+	this.storeValue(object)
 }
 
 func (this *sliceBuilder) PrepareForListContents() {
+	this.markerID, this.isMarked = this.root.takePendingMarker()
+	if this.isMarked {
+		this.root.beginUnfinishedObject(this.markerID, reflect.New(this.dstType).Elem())
+	}
 	this.root.setCurrentBuilder(this)
 }
 
@@ -1,25 +1,53 @@
 package reconstruct
 
 import (
+	"fmt"
 	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type structBuilderDesc struct {
-	builder ObjectBuilder
-	index   int
+	builder      ObjectBuilder
+	index        []int
+	ordinal      int
+	required     bool
+	defaultValue string
+	hasDefault   bool
+}
+
+type structBuilderFields struct {
+	descs map[string]*structBuilderDesc
+	// Receives unrecognized keys (nil if there's no remain field)
+	remain *structBuilderDesc
+	// True if there are default tags or the struct is a Defaulter
+	hasDefaults bool
+	// Why the struct can't be built (empty if it can)
+	invalidReason string
 }
 
 type structBuilder struct {
 	// Const data
 	dstType reflect.Type
 
+	// Template data
+	fieldsByResolver resolverCache
+
 	// Cloned data (must be populated)
 	builderDescs  map[string]*structBuilderDesc
+	remainDesc    *structBuilderDesc
+	hasDefaults   bool
+	invalidReason string
 	nameBuilder   ObjectBuilder
 	ignoreBuilder ObjectBuilder
 
+	// Cloned data (created on demand)
+	fieldBuilders map[string]ObjectBuilder
+	remainBuilder ObjectBuilder
+
 	// Clone inserted data
 	root   *RootBuilder
 	parent ObjectBuilder
@@ -30,6 +58,8 @@ type structBuilder struct {
 	nextValue     reflect.Value
 	nextIsKey     bool
 	nextIsIgnored bool
+	remainKey     reflect.Value
+	received      []bool
 }
 
 func newStructBuilder(dstType reflect.Type) ObjectBuilder {
@@ -40,53 +70,122 @@ func newStructBuilder(dstType reflect.Type) ObjectBuilder {
 
 func (this *structBuilder) PostCacheInitBuilder() {
 	this.nameBuilder = getBuilderForType(reflect.TypeOf(""))
-	this.builderDescs = make(map[string]*structBuilderDesc)
 	this.ignoreBuilder = newIgnoreBuilder()
-	for i := 0; i < this.dstType.NumField(); i++ {
-		field := this.dstType.Field(i)
-		if field.PkgPath == "" {
-			builder := getBuilderForType(field.Type)
-			this.builderDescs[field.Name] = &structBuilderDesc{
-				builder: builder,
-				index:   i,
-			}
+	this.getFields(ReconstructTagResolver)
+}
+
+// Get the fields as named by resolver, generating them on first use.
+func (this *structBuilder) getFields(resolver FieldNameResolver) *structBuilderFields {
+	return this.fieldsByResolver.get(resolver, func() interface{} {
+		return this.generateFields(resolver)
+	}).(*structBuilderFields)
+}
+
+func (this *structBuilder) generateFields(resolver FieldNameResolver) *structBuilderFields {
+	structFields, remain := getStructFields(this.dstType, resolver)
+	fields := &structBuilderFields{
+		descs:       make(map[string]*structBuilderDesc),
+		hasDefaults: reflect.PtrTo(this.dstType).Implements(defaulterType),
+	}
+	for i, field := range structFields {
+		fields.descs[field.Name] = &structBuilderDesc{
+			builder:      getBuilderForType(field.Type),
+			index:        field.Index,
+			ordinal:      i,
+			required:     field.Required,
+			defaultValue: field.Default,
+			hasDefault:   field.HasDefault,
+		}
+		fields.hasDefaults = fields.hasDefaults || field.HasDefault
+		if field.HasDefault && !canHaveDefault(field.Type) && fields.invalidReason == "" {
+			fields.invalidReason = fmt.Sprintf("Field %v of type %v cannot have a default value", field.Name, field.Type)
+		}
+	}
+	if remain != nil {
+		fields.remain = &structBuilderDesc{
+			builder: getBuilderForType(remain.Type.Elem()),
+			index:   remain.Index,
 		}
 	}
+	return fields
 }
 
 func (this *structBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	fields := this.getFields(root.options.FieldNameResolver)
 	that := &structBuilder{
-		dstType:      this.dstType,
-		builderDescs: make(map[string]*structBuilderDesc),
-		parent:       parent,
-		root:         root,
+		dstType:       this.dstType,
+		builderDescs:  fields.descs,
+		remainDesc:    fields.remain,
+		hasDefaults:   fields.hasDefaults,
+		invalidReason: fields.invalidReason,
+		fieldBuilders: make(map[string]ObjectBuilder),
+		received:      make([]bool, len(fields.descs)),
+		parent:        parent,
+		root:          root,
 	}
 	that.nameBuilder = this.nameBuilder.CloneFromTemplate(root, that)
 	that.ignoreBuilder = this.ignoreBuilder.CloneFromTemplate(root, that)
-	for k, builderElem := range this.builderDescs {
-		that.builderDescs[k] = &structBuilderDesc{
-			builder: builderElem.builder.CloneFromTemplate(root, that),
-			index:   builderElem.index,
-		}
-	}
 	that.reset()
 	return that
 }
 
+func (this *structBuilder) getFieldBuilder(name string, desc *structBuilderDesc) ObjectBuilder {
+	builder, ok := this.fieldBuilders[name]
+	if !ok {
+		builder = desc.builder.CloneFromTemplate(this.root, this)
+		this.fieldBuilders[name] = builder
+	}
+	return builder
+}
+
 func (this *structBuilder) reset() {
 	this.nextBuilder = this.nameBuilder
 	this.container = reflect.New(this.dstType).Elem()
 	this.nextValue = reflect.Value{}
 	this.nextIsKey = true
 	this.nextIsIgnored = false
+	this.remainKey = reflect.Value{}
+	for i := range this.received {
+		this.received[i] = false
+	}
 }
 
 func (this *structBuilder) swapKeyValue() {
+	if !this.nextIsKey {
+		this.root.markObject(this.nextValue)
+		if this.remainKey.IsValid() {
+			this.getRemainMap().SetMapIndex(this.remainKey, this.nextValue)
+			this.remainKey = reflect.Value{}
+		}
+	}
 	this.nextIsKey = !this.nextIsKey
 }
 
+// Get the map that receives unrecognized keys, allocating it if necessary.
+func (this *structBuilder) getRemainMap() reflect.Value {
+	remainMap := fieldByIndexAlloc(this.container, this.remainDesc.index)
+	if remainMap.IsNil() {
+		remainMap.Set(reflect.MakeMap(remainMap.Type()))
+	}
+	return remainMap
+}
+
+// Prepare to build the value of an unrecognized key into the remain map.
+func (this *structBuilder) beginRemainValue(key string) {
+	if this.remainBuilder == nil {
+		this.remainBuilder = this.remainDesc.builder.CloneFromTemplate(this.root, this)
+	}
+	remainMap := this.getRemainMap()
+	this.remainKey = reflect.ValueOf(key).Convert(remainMap.Type().Key())
+	if remainMap.MapIndex(this.remainKey).IsValid() {
+		this.panicDuplicateField(key)
+	}
+	this.nextBuilder = this.remainBuilder
+	this.nextValue = reflect.New(remainMap.Type().Elem()).Elem()
+}
+
 func (this *structBuilder) Nil(ignored reflect.Value) {
-	this.nextBuilder.Nil(this.nextValue)
+	buildNil(this.nextBuilder, this.nextValue)
 	this.swapKeyValue()
 }
 
@@ -110,11 +209,24 @@ func (this *structBuilder) Float(value float64, ignored reflect.Value) {
 	this.swapKeyValue()
 }
 
+func (this *structBuilder) Complex(value complex128, ignored reflect.Value) {
+	this.nextBuilder.Complex(value, this.nextValue)
+	this.swapKeyValue()
+}
+
 func (this *structBuilder) String(value string, ignored reflect.Value) {
 	if this.nextIsKey {
 		if builderDesc, ok := this.builderDescs[value]; ok {
-			this.nextBuilder = builderDesc.builder
-			this.nextValue = this.container.Field(builderDesc.index)
+			if this.received[builderDesc.ordinal] {
+				this.panicDuplicateField(value)
+			}
+			this.received[builderDesc.ordinal] = true
+			this.nextBuilder = this.getFieldBuilder(value, builderDesc)
+			this.nextValue = fieldByIndexAlloc(this.container, builderDesc.index)
+		} else if this.remainDesc != nil {
+			this.beginRemainValue(value)
+		} else if this.root.options.DisallowUnknownFields {
+			this.panicUnknownField(value)
 		} else {
 			this.root.setCurrentBuilder(this.ignoreBuilder)
 			this.nextBuilder = this.ignoreBuilder
@@ -128,6 +240,144 @@ func (this *structBuilder) String(value string, ignored reflect.Value) {
 	this.swapKeyValue()
 }
 
+func (this *structBuilder) panicUnknownField(name string) {
+	names := make([]string, 0, len(this.builderDescs))
+	for fieldName := range this.builderDescs {
+		names = append(names, fieldName)
+	}
+	sort.Strings(names)
+	panic(&BuildError{
+		DstType: this.dstType,
+		Reason:  fmt.Sprintf("Unknown field %q (valid fields: %v)", name, strings.Join(names, ", ")),
+	})
+}
+
+func (this *structBuilder) panicDuplicateField(name string) {
+	panic(&BuildError{
+		DstType: this.dstType,
+		Reason:  fmt.Sprintf("Duplicate field %q", name),
+	})
+}
+
+// Fail if any required fields didn't receive a value.
+func (this *structBuilder) checkRequiredFields() {
+	var missing []string
+	for name, desc := range this.builderDescs {
+		if desc.required && !this.received[desc.ordinal] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  fmt.Sprintf("Missing required fields: %v", strings.Join(missing, ", ")),
+		})
+	}
+}
+
+// Populate any fields that didn't receive a value with their defaults.
+func (this *structBuilder) applyDefaults() {
+	if !this.hasDefaults {
+		return
+	}
+
+	defaults := reflect.New(this.dstType)
+	for name, desc := range this.builderDescs {
+		if desc.hasDefault {
+			this.buildDefault(name, desc, fieldByIndexAlloc(defaults.Elem(), desc.index))
+		}
+	}
+	if defaulter, ok := defaults.Interface().(Defaulter); ok {
+		defaulter.SetDefaults()
+	}
+
+	for _, desc := range this.builderDescs {
+		if !this.received[desc.ordinal] {
+			if value := fieldByIndex(defaults.Elem(), desc.index); value.IsValid() {
+				fieldByIndexAlloc(this.container, desc.index).Set(value)
+			}
+		}
+	}
+}
+
+// Only fields that build from a single scalar can have a default value.
+func canHaveDefault(fieldType reflect.Type) bool {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == urlType || fieldType == timeType {
+		return true
+	}
+	switch fieldType.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// Parse a default value according to the field's type, and then build it
+// into dst using a fresh builder, so that nothing is left behind in the
+// builder that the field's received values use.
+func (this *structBuilder) buildDefault(name string, desc *structBuilderDesc, dst reflect.Value) {
+	builder := desc.builder.CloneFromTemplate(this.root, this)
+	text := desc.defaultValue
+	valueType := dst.Type()
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	var err error
+	switch valueType {
+	case urlType:
+		var value *url.URL
+		if value, err = url.Parse(text); err == nil {
+			builder.URI(value, dst)
+		}
+	case timeType:
+		var value time.Time
+		if value, err = time.Parse(time.RFC3339Nano, text); err == nil {
+			builder.Time(value, dst)
+		}
+	default:
+		switch valueType.Kind() {
+		case reflect.Bool:
+			var value bool
+			if value, err = strconv.ParseBool(text); err == nil {
+				builder.Bool(value, dst)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var value int64
+			if value, err = strconv.ParseInt(text, 0, 64); err == nil {
+				builder.Int(value, dst)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var value uint64
+			if value, err = strconv.ParseUint(text, 0, 64); err == nil {
+				builder.Uint(value, dst)
+			}
+		case reflect.Float32, reflect.Float64:
+			var value float64
+			if value, err = strconv.ParseFloat(text, 64); err == nil {
+				builder.Float(value, dst)
+			}
+		default:
+			builder.String(text, dst)
+		}
+	}
+
+	if err != nil {
+		panic(&BuildError{
+			DstType: dst.Type(),
+			Reason:  fmt.Sprintf("Invalid default value %q for field %v: %v", text, name, err),
+		})
+	}
+}
+
 func (this *structBuilder) Bytes(value []byte, ignored reflect.Value) {
 	this.nextBuilder.Bytes(value, this.nextValue)
 	this.swapKeyValue()
@@ -152,17 +402,22 @@ func (this *structBuilder) Map() {
 }
 
 func (this *structBuilder) End() {
+	this.checkRequiredFields()
+	this.applyDefaults()
 	object := this.container
 	this.reset()
 	this.parent.NotifyChildContainerFinished(object)
 }
 
-func (this *structBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *structBuilder) Reference(id interface{}) {
-	panic("TODO")
+	if this.nextIsKey {
+		builderPanicBadEvent(this, this.dstType, "Reference")
+	}
+	if this.root.dereference(id, this.nextValue) {
+		// The struct will be copied into its parent before the fixup happens
+		this.root.addFixupCheck(id, this.nextValue)
+	}
+	this.swapKeyValue()
 }
 
 func (this *structBuilder) PrepareForListContents() {
@@ -170,6 +425,13 @@ func (this *structBuilder) PrepareForListContents() {
 }
 
 func (this *structBuilder) PrepareForMapContents() {
+	if this.invalidReason != "" {
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  this.invalidReason,
+		})
+	}
+	this.root.markObject(this.container)
 	this.root.setCurrentBuilder(this)
 }
 
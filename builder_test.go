@@ -1,7 +1,10 @@
 package reconstruct
 
 import (
+	"encoding/json"
+	"math"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
@@ -9,68 +12,97 @@ import (
 	"github.com/kstenerud/go-equivalence"
 )
 
-func n() func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnNil() }
+func n() func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnNil() }
 }
-func b(value bool) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnBool(value) }
+func b(value bool) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnBool(value) }
 }
-func i(value int64) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnInt(value) }
+func i(value int64) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnInt(value) }
 }
-func u(value uint64) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnUint(value) }
+func u(value uint64) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnUint(value) }
 }
-func f(value float64) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnFloat(value) }
+func f(value float64) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnFloat(value) }
 }
-func s(value string) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnString(value) }
+func c(value complex128) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnComplex(value) }
 }
-func bin(value []byte) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnBytes(value) }
+func s(value string) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnString(value) }
 }
-func uri(value string) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnURI(newURI(value)) }
+func bin(value []byte) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnBytes(value) }
 }
-func tm(value time.Time) func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnTime(value) }
+func uri(value string) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnURI(newURI(value)) }
 }
-func l() func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnListBegin() }
+func tm(value time.Time) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnTime(value) }
 }
-func m() func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnMapBegin() }
+func l() func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnListBegin() }
 }
-func e() func(builder *RootBuilder) {
-	return func(builder *RootBuilder) { builder.OnContainerEnd() }
+func m() func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnMapBegin() }
+}
+func e() func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnContainerEnd() }
 }
 
-// TODO: Marker, reference
+func mark(id interface{}) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnMarker(id) }
+}
+func ref(id interface{}) func(builder *RootBuilder) error {
+	return func(builder *RootBuilder) error { return builder.OnReference(id) }
+}
 
-func runBuildCmds(builder *RootBuilder, commands ...func(*RootBuilder)) {
+func runBuildCmds(builder *RootBuilder, commands ...func(*RootBuilder) error) error {
 	for _, cmd := range commands {
-		cmd(builder)
+		if err := cmd(builder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runBuild(template interface{}, commands ...func(*RootBuilder) error) (interface{}, error) {
+	builder := NewBuilderFor(template)
+	if err := runBuildCmds(builder, commands...); err != nil {
+		return nil, err
 	}
+	return builder.GetBuiltObject(), nil
 }
 
-func runBuild(expected interface{}, commands ...func(*RootBuilder)) interface{} {
-	builder := NewBuilderFor(expected)
-	runBuildCmds(builder, commands...)
-	return builder.GetBuiltObject()
+func mustBuild(t *testing.T, template interface{}, commands ...func(*RootBuilder) error) interface{} {
+	actual, err := runBuild(template, commands...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return actual
 }
 
-func assertBuild(t *testing.T, expected interface{}, commands ...func(*RootBuilder)) {
-	actual := runBuild(expected, commands...)
+func assertBuild(t *testing.T, expected interface{}, commands ...func(*RootBuilder) error) {
+	actual, err := runBuild(expected, commands...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
 	if !equivalence.IsEquivalent(expected, actual) {
 		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
 	}
 }
 
-func assertBuildPanics(t *testing.T, template interface{}, commands ...func(*RootBuilder)) {
-	assertPanics(t, func() {
-		runBuild(template, commands...)
-	})
+func assertBuildFails(t *testing.T, template interface{}, commands ...func(*RootBuilder) error) {
+	if err := reportPanic(func() {
+		if _, err := runBuild(template, commands...); err == nil {
+			t.Errorf("Expected build of %v to fail", describe.D(template))
+		}
+	}); err != nil {
+		t.Errorf("Expected an error rather than a panic, but got panic: %v", err)
+	}
 }
 
 func TestBuilderBasic(t *testing.T) {
@@ -103,122 +135,122 @@ func TestBuilderURI(t *testing.T) {
 }
 
 func TestBuilderBasicTypeFail(t *testing.T) {
-	assertBuildPanics(t, true, n())
-	assertBuildPanics(t, true, i(1))
-	assertBuildPanics(t, true, u(1))
-	assertBuildPanics(t, true, f(1))
-	assertBuildPanics(t, true, s("1"))
-	assertBuildPanics(t, true, bin([]byte{1}))
-	assertBuildPanics(t, true, uri("x://x"))
-	assertBuildPanics(t, true, tm(time.Now()))
-	assertBuildPanics(t, true, l())
-	assertBuildPanics(t, true, m())
-	assertBuildPanics(t, true, e())
-
-	assertBuildPanics(t, int(1), n())
-	assertBuildPanics(t, int(1), b(true))
-	assertBuildPanics(t, int(1), s("1"))
-	assertBuildPanics(t, int(1), bin([]byte{1}))
-	assertBuildPanics(t, int(1), uri("x://x"))
-	assertBuildPanics(t, int(1), tm(time.Now()))
-	assertBuildPanics(t, int(1), l())
-	assertBuildPanics(t, int(1), m())
-	assertBuildPanics(t, int(1), e())
-
-	assertBuildPanics(t, uint(1), n())
-	assertBuildPanics(t, uint(1), b(true))
-	assertBuildPanics(t, uint(1), s("1"))
-	assertBuildPanics(t, uint(1), bin([]byte{1}))
-	assertBuildPanics(t, uint(1), uri("x://x"))
-	assertBuildPanics(t, uint(1), tm(time.Now()))
-	assertBuildPanics(t, uint(1), l())
-	assertBuildPanics(t, uint(1), m())
-	assertBuildPanics(t, uint(1), e())
-
-	assertBuildPanics(t, float64(1), n())
-	assertBuildPanics(t, float64(1), b(true))
-	assertBuildPanics(t, float64(1), s("1"))
-	assertBuildPanics(t, float64(1), bin([]byte{1}))
-	assertBuildPanics(t, float64(1), uri("x://x"))
-	assertBuildPanics(t, float64(1), tm(time.Now()))
-	assertBuildPanics(t, float64(1), l())
-	assertBuildPanics(t, float64(1), m())
-	assertBuildPanics(t, float64(1), e())
+	assertBuildFails(t, true, n())
+	assertBuildFails(t, true, i(1))
+	assertBuildFails(t, true, u(1))
+	assertBuildFails(t, true, f(1))
+	assertBuildFails(t, true, s("1"))
+	assertBuildFails(t, true, bin([]byte{1}))
+	assertBuildFails(t, true, uri("x://x"))
+	assertBuildFails(t, true, tm(time.Now()))
+	assertBuildFails(t, true, l())
+	assertBuildFails(t, true, m())
+	assertBuildFails(t, true, e())
+
+	assertBuildFails(t, int(1), n())
+	assertBuildFails(t, int(1), b(true))
+	assertBuildFails(t, int(1), s("1"))
+	assertBuildFails(t, int(1), bin([]byte{1}))
+	assertBuildFails(t, int(1), uri("x://x"))
+	assertBuildFails(t, int(1), tm(time.Now()))
+	assertBuildFails(t, int(1), l())
+	assertBuildFails(t, int(1), m())
+	assertBuildFails(t, int(1), e())
+
+	assertBuildFails(t, uint(1), n())
+	assertBuildFails(t, uint(1), b(true))
+	assertBuildFails(t, uint(1), s("1"))
+	assertBuildFails(t, uint(1), bin([]byte{1}))
+	assertBuildFails(t, uint(1), uri("x://x"))
+	assertBuildFails(t, uint(1), tm(time.Now()))
+	assertBuildFails(t, uint(1), l())
+	assertBuildFails(t, uint(1), m())
+	assertBuildFails(t, uint(1), e())
+
+	assertBuildFails(t, float64(1), n())
+	assertBuildFails(t, float64(1), b(true))
+	assertBuildFails(t, float64(1), s("1"))
+	assertBuildFails(t, float64(1), bin([]byte{1}))
+	assertBuildFails(t, float64(1), uri("x://x"))
+	assertBuildFails(t, float64(1), tm(time.Now()))
+	assertBuildFails(t, float64(1), l())
+	assertBuildFails(t, float64(1), m())
+	assertBuildFails(t, float64(1), e())
 
 	// TODO: What should allow nil?
-	// assertBuildPanics(t, "", n())
-	assertBuildPanics(t, "", b(true))
-	assertBuildPanics(t, "", i(1))
-	assertBuildPanics(t, "", u(1))
-	assertBuildPanics(t, "", f(1))
-	assertBuildPanics(t, "", bin([]byte{1}))
-	assertBuildPanics(t, "", uri("x://x"))
-	assertBuildPanics(t, "", tm(time.Now()))
-	assertBuildPanics(t, "", l())
-	assertBuildPanics(t, "", m())
-	assertBuildPanics(t, "", e())
+	// assertBuildFails(t, "", n())
+	assertBuildFails(t, "", b(true))
+	assertBuildFails(t, "", i(1))
+	assertBuildFails(t, "", u(1))
+	assertBuildFails(t, "", f(1))
+	assertBuildFails(t, "", bin([]byte{1}))
+	assertBuildFails(t, "", uri("x://x"))
+	assertBuildFails(t, "", tm(time.Now()))
+	assertBuildFails(t, "", l())
+	assertBuildFails(t, "", m())
+	assertBuildFails(t, "", e())
 
 	// TODO: What should allow nil?
-	// assertBuildPanics(t, []byte{}, n())
-	assertBuildPanics(t, []byte{}, b(true))
-	assertBuildPanics(t, []byte{}, i(1))
-	assertBuildPanics(t, []byte{}, u(1))
-	assertBuildPanics(t, []byte{}, f(1))
-	assertBuildPanics(t, []byte{}, s("1"))
-	assertBuildPanics(t, []byte{}, uri("x://x"))
-	assertBuildPanics(t, []byte{}, tm(time.Now()))
-	assertBuildPanics(t, []byte{}, l())
-	assertBuildPanics(t, []byte{}, m())
-	assertBuildPanics(t, []byte{}, e())
+	// assertBuildFails(t, []byte{}, n())
+	assertBuildFails(t, []byte{}, b(true))
+	assertBuildFails(t, []byte{}, i(1))
+	assertBuildFails(t, []byte{}, u(1))
+	assertBuildFails(t, []byte{}, f(1))
+	assertBuildFails(t, []byte{}, s("1"))
+	assertBuildFails(t, []byte{}, uri("x://x"))
+	assertBuildFails(t, []byte{}, tm(time.Now()))
+	assertBuildFails(t, []byte{}, l())
+	assertBuildFails(t, []byte{}, m())
+	assertBuildFails(t, []byte{}, e())
 
 	// TODO: What should allow nil?
-	// assertBuildPanics(t, newURI("x://x"), n())
-	assertBuildPanics(t, newURI("x://x"), b(true))
-	assertBuildPanics(t, newURI("x://x"), i(1))
-	assertBuildPanics(t, newURI("x://x"), u(1))
-	assertBuildPanics(t, newURI("x://x"), f(1))
-	assertBuildPanics(t, newURI("x://x"), s("1"))
-	assertBuildPanics(t, newURI("x://x"), bin([]byte{1}))
-	assertBuildPanics(t, newURI("x://x"), tm(time.Now()))
-	assertBuildPanics(t, newURI("x://x"), l())
-	assertBuildPanics(t, newURI("x://x"), m())
-	assertBuildPanics(t, newURI("x://x"), e())
-
-	assertBuildPanics(t, time.Now(), n())
-	assertBuildPanics(t, time.Now(), b(true))
-	assertBuildPanics(t, time.Now(), i(1))
-	assertBuildPanics(t, time.Now(), u(1))
-	assertBuildPanics(t, time.Now(), f(1))
-	assertBuildPanics(t, time.Now(), s("1"))
-	assertBuildPanics(t, time.Now(), bin([]byte{1}))
-	assertBuildPanics(t, time.Now(), uri("x://x"))
-	assertBuildPanics(t, time.Now(), l())
-	assertBuildPanics(t, time.Now(), m())
-	assertBuildPanics(t, time.Now(), e())
-
-	assertBuildPanics(t, []int{}, n())
-	assertBuildPanics(t, []int{}, b(true))
-	assertBuildPanics(t, []int{}, i(1))
-	assertBuildPanics(t, []int{}, u(1))
-	assertBuildPanics(t, []int{}, f(1))
-	assertBuildPanics(t, []int{}, s("1"))
-	assertBuildPanics(t, []int{}, bin([]byte{1}))
-	assertBuildPanics(t, []int{}, uri("x://x"))
-	assertBuildPanics(t, []int{}, tm(time.Now()))
-	assertBuildPanics(t, []int{}, m())
-	assertBuildPanics(t, []int{}, e())
+	// assertBuildFails(t, newURI("x://x"), n())
+	assertBuildFails(t, newURI("x://x"), b(true))
+	assertBuildFails(t, newURI("x://x"), i(1))
+	assertBuildFails(t, newURI("x://x"), u(1))
+	assertBuildFails(t, newURI("x://x"), f(1))
+	assertBuildFails(t, newURI("x://x"), s("1"))
+	assertBuildFails(t, newURI("x://x"), bin([]byte{1}))
+	assertBuildFails(t, newURI("x://x"), tm(time.Now()))
+	assertBuildFails(t, newURI("x://x"), l())
+	assertBuildFails(t, newURI("x://x"), m())
+	assertBuildFails(t, newURI("x://x"), e())
+
+	assertBuildFails(t, time.Now(), n())
+	assertBuildFails(t, time.Now(), b(true))
+	assertBuildFails(t, time.Now(), i(1))
+	assertBuildFails(t, time.Now(), u(1))
+	assertBuildFails(t, time.Now(), f(1))
+	assertBuildFails(t, time.Now(), s("1"))
+	assertBuildFails(t, time.Now(), bin([]byte{1}))
+	assertBuildFails(t, time.Now(), uri("x://x"))
+	assertBuildFails(t, time.Now(), l())
+	assertBuildFails(t, time.Now(), m())
+	assertBuildFails(t, time.Now(), e())
+
+	assertBuildFails(t, []int{}, n())
+	assertBuildFails(t, []int{}, b(true))
+	assertBuildFails(t, []int{}, i(1))
+	assertBuildFails(t, []int{}, u(1))
+	assertBuildFails(t, []int{}, f(1))
+	assertBuildFails(t, []int{}, s("1"))
+	assertBuildFails(t, []int{}, bin([]byte{1}))
+	assertBuildFails(t, []int{}, uri("x://x"))
+	assertBuildFails(t, []int{}, tm(time.Now()))
+	assertBuildFails(t, []int{}, m())
+	assertBuildFails(t, []int{}, e())
 
 	// TODO: Check if this is correct behavior to not panic
-	// assertBuildPanics(t, map[int]int{}, n())
-	assertBuildPanics(t, map[int]int{}, i(1))
-	assertBuildPanics(t, map[int]int{}, u(1))
-	assertBuildPanics(t, map[int]int{}, f(1))
-	assertBuildPanics(t, map[int]int{}, s("1"))
-	assertBuildPanics(t, map[int]int{}, bin([]byte{1}))
-	assertBuildPanics(t, map[int]int{}, uri("x://x"))
-	assertBuildPanics(t, map[int]int{}, tm(time.Now()))
-	assertBuildPanics(t, map[int]int{}, l())
-	assertBuildPanics(t, map[int]int{}, e())
+	// assertBuildFails(t, map[int]int{}, n())
+	assertBuildFails(t, map[int]int{}, i(1))
+	assertBuildFails(t, map[int]int{}, u(1))
+	assertBuildFails(t, map[int]int{}, f(1))
+	assertBuildFails(t, map[int]int{}, s("1"))
+	assertBuildFails(t, map[int]int{}, bin([]byte{1}))
+	assertBuildFails(t, map[int]int{}, uri("x://x"))
+	assertBuildFails(t, map[int]int{}, tm(time.Now()))
+	assertBuildFails(t, map[int]int{}, l())
+	assertBuildFails(t, map[int]int{}, e())
 }
 
 func TestBuilderNumericConversion(t *testing.T) {
@@ -231,12 +263,64 @@ func TestBuilderNumericConversion(t *testing.T) {
 }
 
 func TestBuilderNumericConversionFail(t *testing.T) {
-	assertBuildPanics(t, int8(0), i(300))
-	assertBuildPanics(t, int(0), f(3.5))
-	assertBuildPanics(t, uint(0), i(-1))
-	assertBuildPanics(t, uint(0), f(3.5))
-	assertBuildPanics(t, float32(0), i(0x7fffffffffffffff))
-	assertBuildPanics(t, float64(0), u(0xffffffffffffffff))
+	assertBuildFails(t, int8(0), i(300))
+	assertBuildFails(t, int(0), f(3.5))
+	assertBuildFails(t, uint(0), i(-1))
+	assertBuildFails(t, uint(0), f(3.5))
+	assertBuildFails(t, float32(0), i(0x7fffffffffffffff))
+	assertBuildFails(t, float64(0), u(0xffffffffffffffff))
+}
+
+func TestBuilderError(t *testing.T) {
+	builder := NewBuilderFor(int8(0))
+	err := builder.OnInt(300)
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+		return
+	}
+	if buildErr.Event != "Int" || buildErr.DstType != reflect.TypeOf(int8(0)) {
+		t.Errorf("Unexpected error contents: %v", buildErr)
+	}
+
+	if builder.OnInt(1) != err {
+		t.Errorf("Expected builder to remain in a failed state")
+	}
+	if builder.GetBuiltObject() != nil {
+		t.Errorf("Expected a failed build to produce no object")
+	}
+}
+
+func assertBuildErrorPath(t *testing.T, expectedPath string, template interface{}, commands ...func(*RootBuilder) error) {
+	_, err := runBuild(template, commands...)
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+		return
+	}
+	if buildErr.PathString() != expectedPath {
+		t.Errorf("Expected error path [%v] but got [%v]", expectedPath, buildErr.PathString())
+	}
+}
+
+func TestBuilderErrorPath(t *testing.T) {
+	assertBuildErrorPath(t, "", int8(0), i(300))
+	assertBuildErrorPath(t, "/AnInt", BuilderTestStruct{},
+		m(), s("ABool"), b(true), s("AnInt"), s("x"))
+	assertBuildErrorPath(t, "/AMap/1", BuilderTestStruct{},
+		m(), s("AMap"), m(), i(1), i(300))
+	assertBuildErrorPath(t, "/1/Value", []SmallStruct{},
+		l(), m(), s("Value"), i(1), e(), m(), s("Value"), s("x"))
+	assertBuildErrorPath(t, "/a~1b/0", map[string][]int{},
+		m(), s("a/b"), l(), b(true))
+}
+
+func TestIterateBuildError(t *testing.T) {
+	builder := NewBuilderFor(SmallStruct{})
+	err := IterateObject(map[string]interface{}{"Value": "x"}, false, builder)
+	if _, ok := err.(*BuildError); !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+	}
 }
 
 func TestBuilderSlice(t *testing.T) {
@@ -374,6 +458,478 @@ func TestBuilderMultipleComplexBuilds(t *testing.T) {
 	}
 }
 
+type TaggedStruct struct {
+	Renamed   int    `reconstruct:"renamed"`
+	OmitEmpty string `reconstruct:",omitempty"`
+	Skipped   int    `reconstruct:"-"`
+	Dash      int    `reconstruct:"-,"`
+	Untagged  bool
+}
+
+func TestBuilderStructTags(t *testing.T) {
+	assertBuild(t, TaggedStruct{Renamed: 1, OmitEmpty: "x", Dash: 3, Untagged: true},
+		m(),
+		s("renamed"), i(1),
+		s("Renamed"), i(100),
+		s("OmitEmpty"), s("x"),
+		s("Skipped"), i(5),
+		s("-"), i(3),
+		s("Untagged"), b(true),
+		e())
+}
+
+func TestBuilderEmbedded(t *testing.T) {
+	assertBuild(t, EmbeddingStruct{
+		EmbeddedInner:  EmbeddedInner{A: 1},
+		EmbeddedOther:  &EmbeddedOther{E: 5},
+		EmbeddedTagged: EmbeddedTagged{D: 6},
+		C:              "x",
+	},
+		m(),
+		s("A"), i(1),
+		s("B"), i(2),
+		s("E"), i(5),
+		s("tagged"), m(), s("D"), i(6), e(),
+		s("C"), s("x"),
+		e())
+
+	// The embedded pointer is only allocated if one of its fields is built.
+	assertBuild(t, EmbeddingStruct{EmbeddedInner: EmbeddedInner{A: 1}},
+		m(),
+		s("A"), i(1),
+		e())
+}
+
+func TestBuilderIgnoredFields(t *testing.T) {
+	assertBuild(t, EmbeddedInner{A: 1, B: 2},
+		m(),
+		s("x"), l(), i(1), m(), s("y"), l(), e(), e(), e(),
+		s("A"), i(1),
+		s("z"), m(), s("a"), i(100), e(),
+		s("n"), n(),
+		s("B"), i(2),
+		e())
+}
+
+func TestBuilderDisallowUnknownFields(t *testing.T) {
+	options := &BuilderOptions{DisallowUnknownFields: true}
+
+	builder := NewBuilderWithOptions(EmbeddedInner{}, options)
+	if err := runBuildCmds(builder, m(), s("A"), i(1), s("B"), i(2), e()); err != nil {
+		t.Fatal(err)
+	}
+	expected := EmbeddedInner{A: 1, B: 2}
+	if actual := builder.GetBuiltObject(); !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+
+	builder = NewBuilderWithOptions([]EmbeddedInner{}, options)
+	err := runBuildCmds(builder, l(), m(), s("A"), i(1), s("Bb"), i(2), e(), e())
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Fatalf("Expected a *BuildError but got %v", err)
+	}
+	if buildErr.PathString() != "/0" {
+		t.Errorf("Expected error path [/0] but got [%v]", buildErr.PathString())
+	}
+	expectedReason := `Unknown field "Bb" (valid fields: A, B)`
+	if buildErr.Reason != expectedReason {
+		t.Errorf("Expected reason [%v] but got [%v]", expectedReason, buildErr.Reason)
+	}
+}
+
+func TestBuilderRemain(t *testing.T) {
+	assertBuild(t, RemainStruct{A: 1, Extra: map[string]interface{}{
+		"x": 2,
+		"y": []interface{}{3},
+		"z": map[interface{}]interface{}{"q": true},
+		"n": nil,
+	}},
+		m(),
+		s("x"), i(2),
+		s("A"), i(1),
+		s("y"), l(), i(3), e(),
+		s("z"), m(), s("q"), b(true), e(),
+		s("n"), n(),
+		e())
+
+	// Unknown keys are captured rather than rejected in strict mode.
+	builder := NewBuilderWithOptions(RemainStruct{}, &BuilderOptions{DisallowUnknownFields: true})
+	if err := runBuildCmds(builder, m(), s("x"), i(2), e()); err != nil {
+		t.Error(err)
+	}
+}
+
+type RequiredStruct struct {
+	A int `reconstruct:",required"`
+	B int `reconstruct:"b,required"`
+	C int
+}
+
+func assertBuildErrorReason(t *testing.T, expectedReason string, template interface{}, commands ...func(*RootBuilder) error) {
+	_, err := runBuild(template, commands...)
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+		return
+	}
+	if buildErr.Reason != expectedReason {
+		t.Errorf("Expected reason [%v] but got [%v]", expectedReason, buildErr.Reason)
+	}
+}
+
+func TestBuilderRequiredFields(t *testing.T) {
+	assertBuild(t, RequiredStruct{A: 1, B: 2},
+		m(), s("b"), i(2), s("A"), i(1), e())
+	assertBuild(t, []RequiredStruct{{A: 1, B: 2}, {A: 3, B: 4}},
+		l(),
+		m(), s("A"), i(1), s("b"), i(2), e(),
+		m(), s("A"), i(3), s("b"), i(4), e(),
+		e())
+	assertBuildErrorReason(t, "Missing required fields: A, b", RequiredStruct{},
+		m(), s("C"), i(1), e())
+	assertBuildErrorReason(t, "Missing required fields: b", []RequiredStruct{},
+		l(),
+		m(), s("A"), i(1), s("b"), i(2), e(),
+		m(), s("A"), i(3), e(),
+		e())
+	assertBuildErrorPath(t, "/1", []RequiredStruct{},
+		l(),
+		m(), s("A"), i(1), s("b"), i(2), e(),
+		m(), s("A"), i(3), e(),
+		e())
+}
+
+func TestBuilderDuplicateFields(t *testing.T) {
+	assertBuildErrorReason(t, `Duplicate field "C"`, RequiredStruct{},
+		m(), s("C"), i(1), s("A"), i(1), s("C"), i(2), e())
+	assertBuildErrorReason(t, `Duplicate field "x"`, RemainStruct{},
+		m(), s("x"), i(1), s("x"), i(2), e())
+}
+
+type DefaultsStruct struct {
+	Port    int      `default:"8080"`
+	Host    string   `default:"localhost"`
+	Ratio   *float64 `default:"0.5"`
+	Enabled bool     `default:"true"`
+	Site    *url.URL `default:"http://example.com"`
+	None    int
+}
+
+type DefaulterStruct struct {
+	A int `default:"1"`
+	B int
+	C string
+}
+
+func (this *DefaulterStruct) SetDefaults() {
+	this.B = this.A + 1
+	this.C = "c"
+}
+
+type BadDefaultStruct struct {
+	A int `default:"x"`
+}
+
+type OverflowDefaultStruct struct {
+	A int8 `default:"300"`
+}
+
+type SliceDefaultStruct struct {
+	Tags []string `default:"a"`
+}
+
+type StructDefaultStruct struct {
+	Sub struct{ X int } `default:"X"`
+}
+
+func TestBuilderDefaults(t *testing.T) {
+	ratio := 0.5
+	assertBuild(t, DefaultsStruct{
+		Port:    9000,
+		Host:    "localhost",
+		Ratio:   &ratio,
+		Enabled: true,
+		Site:    newURI("http://example.com"),
+	},
+		m(), s("Port"), i(9000), e())
+
+	assertBuild(t, DefaultsStruct{Port: 8080, Host: "", Enabled: false, Site: newURI("http://example.com"), Ratio: &ratio},
+		m(), s("Host"), s(""), s("Enabled"), b(false), e())
+
+	assertBuild(t, DefaulterStruct{A: 1, B: 2, C: "x"},
+		m(), s("C"), s("x"), e())
+	assertBuild(t, DefaulterStruct{A: 5, B: 2, C: "c"},
+		m(), s("A"), i(5), e())
+
+	assertBuildErrorReason(t, `Invalid default value "x" for field A: strconv.ParseInt: parsing "x": invalid syntax`,
+		BadDefaultStruct{}, m(), e())
+	assertBuildFails(t, OverflowDefaultStruct{}, m(), e())
+
+	// Defaults don't leak between the elements of a container
+	assertBuild(t, []DefaultsStruct{
+		{Port: 8080, Host: "localhost", Ratio: &ratio, Enabled: true, Site: newURI("http://example.com")},
+		{Port: 1, Host: "x", Ratio: &ratio, Enabled: true, Site: newURI("http://example.com")},
+		{Port: 8080, Host: "localhost", Ratio: &ratio, Enabled: true, Site: newURI("http://example.com")},
+	},
+		l(), m(), e(), m(), s("Port"), i(1), s("Host"), s("x"), e(), m(), e(), e())
+
+	// Only fields that build from a scalar can have a default
+	assertBuildErrorReason(t, "Field Tags of type []string cannot have a default value",
+		[]SliceDefaultStruct{}, l(), m(), e(), m(), s("Tags"), l(), s("x"), e(), e(), e())
+	assertBuildErrorReason(t, "Field Tags of type []string cannot have a default value",
+		SliceDefaultStruct{}, m(), s("Tags"), l(), s("x"), e(), e())
+	assertBuildErrorReason(t, "Field Sub of type struct { X int } cannot have a default value",
+		StructDefaultStruct{}, m(), e())
+}
+
+func TestBuilderComplex(t *testing.T) {
+	assertBuild(t, complex64(1+2i), c(1+2i))
+	assertBuild(t, complex128(1+2i), c(1+2i))
+	assertBuild(t, complex128(-5), i(-5))
+	assertBuild(t, complex128(5), u(5))
+	assertBuild(t, complex64(1.5), f(1.5))
+	assertBuild(t, []complex128{1, 2i}, l(), i(1), c(2i), e())
+	assertBuild(t, []interface{}{1 + 1i}, l(), c(1+1i), e())
+	assertBuild(t, map[interface{}]interface{}{1: 1i}, m(), i(1), c(1i), e())
+
+	// Real-valued complex events convert to other numeric types
+	assertBuild(t, 1.5, c(1.5))
+	assertBuild(t, int(3), c(3))
+	assertBuild(t, uint8(3), c(3))
+
+	assertBuildFails(t, complex64(0), f(math.MaxFloat64))
+	assertBuildFails(t, complex64(0), c(complex(1, math.MaxFloat64)))
+	assertBuildFails(t, complex64(0), i(1<<40+1))
+
+	// Parts that complex64 can't represent exactly are rejected, but those of
+	// complex128 are always exact
+	assertBuild(t, complex64(complex(math.Inf(1), -0.25)), c(complex(math.Inf(1), -0.25)))
+	assertBuild(t, complex128(1.1+2.2i), c(1.1+2.2i))
+	assertBuildFails(t, complex64(0), c(1.1+2i))
+	assertBuildFails(t, complex64(0), c(1+2.2i))
+	assertBuildFails(t, complex64(0), f(1.1))
+	nan := mustBuild(t, complex64(0), c(complex(math.NaN(), 1))).(complex64)
+	if !math.IsNaN(float64(real(nan))) || imag(nan) != 1 {
+		t.Errorf("Expected NaN+1i but got %v", nan)
+	}
+	assertBuildFails(t, 1.5, c(1.5+1i))
+	assertBuildFails(t, int(0), c(1+1i))
+	assertBuildFails(t, uint(0), c(-1))
+	assertBuildFails(t, "", c(1))
+}
+
+func runBuildWithRegistry(template interface{}, commands ...func(*RootBuilder) error) (interface{}, error) {
+	builder := NewBuilderWithOptions(template, &BuilderOptions{TypeRegistry: testTypeRegistry})
+	if err := runBuildCmds(builder, commands...); err != nil {
+		return nil, err
+	}
+	return builder.GetBuiltObject(), nil
+}
+
+func TestBuilderPolymorphic(t *testing.T) {
+	actual, err := runBuildWithRegistry(Drawing{},
+		m(),
+		s("Main"), m(), s("@type"), s("circle"), s("Radius"), f(1), e(),
+		s("Shapes"), l(), m(), s("@type"), s("square"), s("Side"), f(2), e(), n(), e(),
+		s("Any"), m(), s("x"), i(1), e(),
+		e())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Drawing{
+		Main:   &Circle{Radius: 1},
+		Shapes: []Shape{Square{Side: 2}, nil},
+		Any:    map[interface{}]interface{}{"x": 1},
+	}
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+	if _, ok := actual.(*Drawing).Main.(*Circle); !ok {
+		t.Errorf("Expected Main to be a *Circle but got %v", describe.D(actual.(*Drawing).Main))
+	}
+}
+
+func TestBuilderPolymorphicFail(t *testing.T) {
+	failures := [][]func(*RootBuilder) error{
+		{m(), s("Main"), m(), s("Radius"), f(1), e(), e()},
+		{m(), s("Main"), m(), s("@type"), s("triangle"), e(), e()},
+		{m(), s("Main"), m(), s("@type"), i(1), e(), e()},
+		{m(), s("Main"), l(), e(), e()},
+	}
+	for _, commands := range failures {
+		if _, err := runBuildWithRegistry(Drawing{}, commands...); err == nil {
+			t.Errorf("Expected build to fail")
+		}
+	}
+
+	// Non-empty interfaces can't be built without a registry
+	assertBuildFails(t, Drawing{}, m(), s("Main"), m(), s("@type"), s("circle"), e(), e())
+}
+
+func runBuildWithOptions(template interface{}, options *BuilderOptions, commands ...func(*RootBuilder) error) (interface{}, error) {
+	builder := NewBuilderWithOptions(template, options)
+	if err := runBuildCmds(builder, commands...); err != nil {
+		return nil, err
+	}
+	return builder.GetBuiltObject(), nil
+}
+
+func assertBuildWithOptionsExact(t *testing.T, template interface{}, options *BuilderOptions, expected interface{}, commands ...func(*RootBuilder) error) {
+	actual, err := runBuildWithOptions(template, options, commands...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+type testOrderedMap struct {
+	Keys   []interface{}
+	Values []interface{}
+}
+
+func (this *testOrderedMap) Set(key interface{}, value interface{}) {
+	this.Keys = append(this.Keys, key)
+	this.Values = append(this.Values, value)
+}
+
+func TestBuilderIntfMapTypes(t *testing.T) {
+	var intf interface{}
+	stringKeyed := &BuilderOptions{StringKeyedMaps: true}
+	assertBuildWithOptionsExact(t, &intf, stringKeyed,
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{}},
+		m(), s("a"), i(1), s("b"), m(), e(), e())
+	assertBuildWithOptionsExact(t, &intf, stringKeyed,
+		map[interface{}]interface{}{"a": int64(1), int64(2): "b"},
+		m(), s("a"), i(1), i(2), s("b"), e())
+	assertBuildWithOptionsExact(t, []interface{}{}, stringKeyed,
+		[]interface{}{map[string]interface{}{"a": "b"}},
+		l(), m(), s("a"), s("b"), e(), e())
+
+	// Only maps built into interfaces are affected
+	assertBuildWithOptionsExact(t, map[interface{}]interface{}{}, stringKeyed,
+		map[interface{}]interface{}{"a": map[string]interface{}{"b": true}},
+		m(), s("a"), m(), s("b"), b(true), e(), e())
+
+	ordered := &BuilderOptions{
+		StringKeyedMaps: true,
+		NewOrderedMap:   func() OrderedMap { return &testOrderedMap{} },
+	}
+	assertBuildWithOptionsExact(t, map[string]interface{}{}, ordered,
+		map[string]interface{}{"x": &testOrderedMap{
+			Keys:   []interface{}{"b", int64(1), "a"},
+			Values: []interface{}{"1", &testOrderedMap{}, nil},
+		}},
+		m(), s("x"), m(), s("b"), s("1"), i(1), m(), e(), s("a"), n(), e(), e())
+}
+
+func TestBuilderIntfMapTypesReferences(t *testing.T) {
+	options := &BuilderOptions{StringKeyedMaps: true}
+	actual, err := runBuildWithOptions([]interface{}{}, options,
+		l(), mark(1), m(), s("a"), i(1), i(2), s("b"), e(), ref(1), e())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := actual.([]interface{})
+	first := list[0].(map[interface{}]interface{})
+	second := list[1].(map[interface{}]interface{})
+	first["c"] = 3
+	if second["c"] != 3 {
+		t.Errorf("Expected reference to the converted map but got %v", describe.D(second))
+	}
+
+	// References taken before the map was converted
+	var intf interface{}
+	actual, err = runBuildWithOptions(&intf, options,
+		mark(1), m(), s("a"), ref(1), s("b"), l(), ref(1), e(), i(1), i(2), e())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := actual.(map[interface{}]interface{})
+	self, ok := outer["a"].(map[interface{}]interface{})
+	if !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(outer).Pointer() {
+		t.Errorf("Expected the converted map to contain itself but got %v", describe.D(outer["a"]))
+	}
+	inList, ok := outer["b"].([]interface{})[0].(map[interface{}]interface{})
+	if !ok || reflect.ValueOf(inList).Pointer() != reflect.ValueOf(outer).Pointer() {
+		t.Errorf("Expected the list to contain the converted map but got %v", describe.D(outer["b"]))
+	}
+}
+
+func TestBuilderIntfNumberTypes(t *testing.T) {
+	var intf interface{}
+	useInt := &BuilderOptions{UseInt: true}
+	assertBuildWithOptionsExact(t, []interface{}{}, useInt,
+		[]interface{}{-1, 1, uint64(math.MaxUint64), 1.5},
+		l(), i(-1), u(1), u(math.MaxUint64), f(1.5), e())
+	assertBuildWithOptionsExact(t, &intf, useInt, 5, i(5))
+
+	useNumber := &BuilderOptions{UseInt: true, UseNumber: true}
+	assertBuildWithOptionsExact(t, map[string]interface{}{}, useNumber,
+		map[string]interface{}{
+			"i": json.Number("-1"),
+			"u": json.Number("18446744073709551615"),
+			"f": json.Number("1.5e+100"),
+			"n": math.Inf(1),
+			"c": 1 + 2i,
+		},
+		m(), s("i"), i(-1), s("u"), u(math.MaxUint64), s("f"), f(1.5e100),
+		s("n"), f(math.Inf(1)), s("c"), c(1+2i), e())
+	assertBuildWithOptionsExact(t, &intf, useNumber, json.Number("10"), u(10))
+
+	// Concrete destinations are unaffected
+	assertBuildWithOptionsExact(t, map[string]int64{}, useNumber,
+		map[string]int64{"a": 1}, m(), s("a"), i(1), e())
+}
+
+type ConvertStringsStruct struct {
+	Time  time.Time
+	URL   url.URL
+	PURL  *url.URL
+	Bytes []byte
+}
+
+func TestBuilderConvertStrings(t *testing.T) {
+	options := &BuilderOptions{ConvertStrings: true}
+	actual, err := runBuildWithOptions(ConvertStringsStruct{}, options,
+		m(),
+		s("Time"), s("2020-01-01T10:00:00.5+01:00"),
+		s("URL"), s("http://example.com/a"),
+		s("PURL"), s("x://y"),
+		s("Bytes"), s("AQID"),
+		e())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTime, _ := time.Parse(time.RFC3339, "2020-01-01T10:00:00.5+01:00")
+	expected := &ConvertStringsStruct{
+		Time:  expectedTime,
+		URL:   *newURI("http://example.com/a"),
+		PURL:  newURI("x://y"),
+		Bytes: []byte{1, 2, 3},
+	}
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+
+	for _, template := range []interface{}{time.Time{}, []byte{}} {
+		if _, err := runBuildWithOptions(template, options, s("not valid")); err == nil {
+			t.Errorf("Expected building %v from an invalid string to fail", describe.D(template))
+		}
+	}
+	assertBuildFails(t, ConvertStringsStruct{}, m(), s("Time"), s("2020-01-01T10:00:00Z"), e())
+}
+
+func TestBuilderStringsAsBytes(t *testing.T) {
+	options := &BuilderOptions{StringsAsBytes: true, ConvertStrings: true}
+	assertBuildWithOptionsExact(t, []byte{}, options, []byte("AQID"), s("AQID"))
+	assertBuildWithOptionsExact(t, []byte{}, options, []byte{0xff}, s("\xff"))
+	assertBuildFails(t, []byte{}, s("AQID"))
+}
+
 type BuilderPtrTestStruct struct {
 	internal    string
 	ABool       *bool
@@ -559,15 +1115,169 @@ func TestByteArrayBytes(t *testing.T) {
 		bin([]byte{1, 2}))
 }
 
-// TODO
-// type SelfReferential struct {
-// 	Self *SelfReferential
-// }
+type SharedPointerStruct struct {
+	A *SmallStruct
+	B *SmallStruct
+}
 
-// func TestSelfReferential(t *testing.T) {
-// 	assertBuild(t, SelfReferential{},
-// 		m(),
-// 		s("Self"),
-// 		n(),
-// 		e())
-// }
+func TestBuilderReferenceStruct(t *testing.T) {
+	v := mustBuild(t, SharedPointerStruct{},
+		m(),
+		s("A"), mark(1), m(), s("Value"), i(5), e(),
+		s("B"), ref(1),
+		e()).(*SharedPointerStruct)
+	if v.A == nil || v.A.Value != 5 {
+		t.Errorf("Expected A to be built, but got %v", describe.D(v))
+	}
+	if v.A != v.B {
+		t.Errorf("Expected A and B to point to the same object")
+	}
+}
+
+func TestBuilderReferenceSlice(t *testing.T) {
+	v := mustBuild(t, []*int{}, l(), mark(0), i(1), ref(0), e()).([]*int)
+	if len(v) != 2 || *v[0] != 1 {
+		t.Errorf("Expected [1 1] but got %v", describe.D(v))
+		return
+	}
+	if v[0] != v[1] {
+		t.Errorf("Expected both elements to point to the same int")
+	}
+}
+
+func TestBuilderReferenceCyclicMap(t *testing.T) {
+	v := mustBuild(t, map[string]interface{}{},
+		mark(0), m(), s("self"), ref(0), e()).(map[string]interface{})
+	self, ok := v["self"].(map[string]interface{})
+	if !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(v).Pointer() {
+		t.Errorf("Expected map to contain itself")
+	}
+}
+
+type SelfSlice []*SelfSlice
+
+type IntfValueStruct struct {
+	Value interface{}
+}
+
+func TestBuilderReferenceCyclicSlice(t *testing.T) {
+	v := mustBuild(t, []interface{}{},
+		mark(0), l(), i(1), i(2), i(3), i(4), i(5), ref(0), e()).([]interface{})
+	self, ok := v[5].([]interface{})
+	if !ok || len(self) != 6 || reflect.ValueOf(self).Pointer() != reflect.ValueOf(v).Pointer() {
+		t.Errorf("Expected slice to contain itself but got %v", describe.D(v))
+	}
+
+	var intf interface{}
+	nested := mustBuild(t, &intf,
+		mark(0), l(), l(), ref(0), e(), e()).([]interface{})
+	inner := nested[0].([]interface{})
+	self, ok = inner[0].([]interface{})
+	if !ok || len(self) != 1 || reflect.ValueOf(self).Pointer() != reflect.ValueOf(nested).Pointer() {
+		t.Errorf("Expected inner slice to contain the outer slice but got %v", describe.D(nested))
+	}
+
+	ptrs := mustBuild(t, SelfSlice{},
+		mark(0), l(), ref(0), n(), ref(0), e()).(SelfSlice)
+	if len(ptrs) != 3 || ptrs[0] == nil || ptrs[0] != ptrs[2] || len(*ptrs[0]) != 3 || (*ptrs[0])[0] != ptrs[0] {
+		t.Errorf("Expected slice to contain pointers to itself but got %v", describe.D(ptrs))
+	}
+
+	byMap := mustBuild(t, map[string][]interface{}{},
+		m(), s("a"), mark(0), l(), m(), s("b"), ref(0), e(), e(), e()).(map[string][]interface{})
+	inMap := byMap["a"][0].(map[interface{}]interface{})["b"].([]interface{})
+	if len(inMap) != 1 || reflect.ValueOf(inMap).Pointer() != reflect.ValueOf(byMap["a"]).Pointer() {
+		t.Errorf("Expected map inside slice to contain the slice but got %v", describe.D(byMap))
+	}
+}
+
+func TestBuilderReferenceCyclicSliceFail(t *testing.T) {
+	// A struct is copied into the slice before the slice is finished
+	assertBuildFails(t, []IntfValueStruct{},
+		mark(0), l(), m(), s("Value"), ref(0), e(), e())
+}
+
+func TestBuilderReferenceFail(t *testing.T) {
+	assertBuildFails(t, []*int{}, l(), ref(0), e())
+	assertBuildFails(t, PointerStruct{}, m(), s("PStruct"), mark(0), m(), e(), s("PInt"), ref(0), e())
+}
+
+type SelfReferential struct {
+	Self *SelfReferential
+}
+
+func TestSelfReferential(t *testing.T) {
+	assertBuild(t, SelfReferential{},
+		m(),
+		s("Self"),
+		n(),
+		e())
+
+	assertBuild(t, SelfReferential{&SelfReferential{}},
+		m(),
+		s("Self"),
+		m(),
+		s("Self"),
+		n(),
+		e(),
+		e())
+}
+
+func TestSelfReferentialCycle(t *testing.T) {
+	v := mustBuild(t, SelfReferential{},
+		mark(0),
+		m(),
+		s("Self"),
+		ref(0),
+		e()).(*SelfReferential)
+	if v.Self != v {
+		t.Errorf("Expected Self to point to the containing object")
+	}
+}
+
+func TestBuilderStoreBuiltObject(t *testing.T) {
+	var value int
+	builder := NewBuilderFor(&value)
+	if err := runBuildCmds(builder, i(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.StoreBuiltObject(&value); err != nil || value != 5 {
+		t.Errorf("Expected 5 but got %v (%v)", value, err)
+	}
+
+	var pStruct *SmallStruct
+	builder = NewBuilderFor(&pStruct)
+	if err := runBuildCmds(builder, m(), s("Value"), i(1), e()); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.StoreBuiltObject(&pStruct); err != nil || pStruct == nil || pStruct.Value != 1 {
+		t.Errorf("Expected &{1} but got %v (%v)", pStruct, err)
+	}
+	var st SmallStruct
+	if err := builder.StoreBuiltObject(&st); err != nil || st.Value != 1 {
+		t.Errorf("Expected {1} but got %v (%v)", st, err)
+	}
+
+	if err := builder.StoreBuiltObject(st); err == nil {
+		t.Errorf("Expected storing into a non-pointer to fail")
+	}
+	var str string
+	if err := builder.StoreBuiltObject(&str); err == nil {
+		t.Errorf("Expected storing into the wrong type to fail")
+	}
+
+	pStruct = &SmallStruct{}
+	builder = NewBuilderFor(&pStruct)
+	if err := runBuildCmds(builder, n()); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.StoreBuiltObject(&pStruct); err != nil || pStruct != nil {
+		t.Errorf("Expected nil but got %v (%v)", pStruct, err)
+	}
+	if err := builder.StoreBuiltObject(&st); err == nil {
+		t.Errorf("Expected storing nil into a struct to fail")
+	}
+	if err := runBuildCmds(NewBuilderFor(st), n()); err == nil {
+		t.Errorf("Expected building nil into a struct to fail")
+	}
+}
@@ -0,0 +1,98 @@
+package reconstruct
+
+import (
+	"net/url"
+	"reflect"
+	"time"
+)
+
+type timeBuilder struct {
+	// Clone inserted data
+	root *RootBuilder
+}
+
+func newTimeBuilder() ObjectBuilder {
+	return &timeBuilder{}
+}
+
+func (this *timeBuilder) PostCacheInitBuilder() {
+}
+
+func (this *timeBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return &timeBuilder{root: root}
+}
+
+func (this *timeBuilder) Nil(dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Nil")
+}
+
+func (this *timeBuilder) Bool(value bool, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Bool")
+}
+
+func (this *timeBuilder) Int(value int64, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Int")
+}
+
+func (this *timeBuilder) Uint(value uint64, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Uint")
+}
+
+func (this *timeBuilder) Float(value float64, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Float")
+}
+
+func (this *timeBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Complex")
+}
+
+func (this *timeBuilder) String(value string, dst reflect.Value) {
+	if !this.root.options.ConvertStrings {
+		builderPanicBadEvent(this, timeType, "String")
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		builderPanicCannotConvert(value, timeType)
+	}
+	this.Time(t, dst)
+}
+
+func (this *timeBuilder) Bytes(value []byte, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "Bytes")
+}
+
+func (this *timeBuilder) URI(value *url.URL, dst reflect.Value) {
+	builderPanicBadEvent(this, timeType, "URI")
+}
+
+func (this *timeBuilder) Time(value time.Time, dst reflect.Value) {
+	dst.Set(reflect.ValueOf(value))
+}
+
+func (this *timeBuilder) List() {
+	builderPanicBadEvent(this, timeType, "List")
+}
+
+func (this *timeBuilder) Map() {
+	builderPanicBadEvent(this, timeType, "Map")
+}
+
+func (this *timeBuilder) End() {
+	builderPanicBadEvent(this, timeType, "ContainerEnd")
+}
+
+func (this *timeBuilder) Reference(id interface{}) {
+	builderPanicBadEvent(this, timeType, "Reference")
+}
+
+func (this *timeBuilder) PrepareForListContents() {
+	builderPanicBadEvent(this, timeType, "PrepareForListContents")
+}
+
+func (this *timeBuilder) PrepareForMapContents() {
+	builderPanicBadEvent(this, timeType, "PrepareForMapContents")
+}
+
+func (this *timeBuilder) NotifyChildContainerFinished(value reflect.Value) {
+	builderPanicBadEvent(this, timeType, "NotifyChildContainerFinished")
+}
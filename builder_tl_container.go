@@ -59,6 +59,10 @@ func (this *tlContainerBuilder) Float(value float64, ignored reflect.Value) {
 	builderPanicBadEvent(this, this.dstType, "Float")
 }
 
+func (this *tlContainerBuilder) Complex(value complex128, ignored reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "Complex")
+}
+
 func (this *tlContainerBuilder) String(value string, ignored reflect.Value) {
 	builderPanicBadEvent(this, this.dstType, "String")
 }
@@ -103,10 +107,6 @@ func (this *tlContainerBuilder) NotifyChildContainerFinished(value reflect.Value
 	builderPanicBadEvent(this, this.dstType, "NotifyChildContainerFinished")
 }
 
-func (this *tlContainerBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *tlContainerBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
@@ -59,6 +59,13 @@ func (this *uintBuilder) Float(value float64, dst reflect.Value) {
 	}
 }
 
+func (this *uintBuilder) Complex(value complex128, dst reflect.Value) {
+	if imag(value) != 0 {
+		builderPanicCannotConvert(value, dst.Type())
+	}
+	this.Float(real(value), dst)
+}
+
 func (this *uintBuilder) String(value string, dst reflect.Value) {
 	builderPanicBadEvent(this, this.dstType, "String")
 }
@@ -87,12 +94,8 @@ func (this *uintBuilder) End() {
 	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
 }
 
-func (this *uintBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *uintBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, this.dstType, "Reference")
 }
 
 func (this *uintBuilder) PrepareForListContents() {
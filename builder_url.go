@@ -7,6 +7,8 @@ import (
 )
 
 type urlBuilder struct {
+	// Clone inserted data
+	root *RootBuilder
 }
 
 func newURLBuilder() ObjectBuilder {
@@ -17,7 +19,7 @@ func (this *urlBuilder) PostCacheInitBuilder() {
 }
 
 func (this *urlBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
-	return this
+	return &urlBuilder{root: root}
 }
 
 func (this *urlBuilder) Nil(dst reflect.Value) {
@@ -40,8 +42,15 @@ func (this *urlBuilder) Float(value float64, dst reflect.Value) {
 	builderPanicBadEvent(this, urlType, "Float")
 }
 
+func (this *urlBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, urlType, "Complex")
+}
+
 func (this *urlBuilder) String(value string, dst reflect.Value) {
-	builderPanicBadEvent(this, urlType, "String")
+	if !this.root.options.ConvertStrings {
+		builderPanicBadEvent(this, urlType, "String")
+	}
+	this.URI(parseURLString(value), dst)
 }
 
 func (this *urlBuilder) Bytes(value []byte, dst reflect.Value) {
@@ -68,12 +77,8 @@ func (this *urlBuilder) End() {
 	builderPanicBadEvent(this, urlType, "End")
 }
 
-func (this *urlBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *urlBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, urlType, "Reference")
 }
 
 func (this *urlBuilder) PrepareForListContents() {
@@ -88,9 +93,19 @@ func (this *urlBuilder) NotifyChildContainerFinished(value reflect.Value) {
 	builderPanicBadEvent(this, urlType, "NotifyChildContainerFinished")
 }
 
+func parseURLString(value string) *url.URL {
+	uri, err := url.Parse(value)
+	if err != nil {
+		builderPanicCannotConvert(value, pURLType)
+	}
+	return uri
+}
+
 // Pointer
 
 type pURLBuilder struct {
+	// Clone inserted data
+	root *RootBuilder
 }
 
 func newPURLBuilder() ObjectBuilder {
@@ -101,7 +116,7 @@ func (this *pURLBuilder) PostCacheInitBuilder() {
 }
 
 func (this *pURLBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
-	return this
+	return &pURLBuilder{root: root}
 }
 
 func (this *pURLBuilder) Nil(dst reflect.Value) {
@@ -124,8 +139,15 @@ func (this *pURLBuilder) Float(value float64, dst reflect.Value) {
 	builderPanicBadEvent(this, pURLType, "Float")
 }
 
+func (this *pURLBuilder) Complex(value complex128, dst reflect.Value) {
+	builderPanicBadEvent(this, pURLType, "Complex")
+}
+
 func (this *pURLBuilder) String(value string, dst reflect.Value) {
-	builderPanicBadEvent(this, pURLType, "String")
+	if !this.root.options.ConvertStrings {
+		builderPanicBadEvent(this, pURLType, "String")
+	}
+	this.URI(parseURLString(value), dst)
 }
 
 func (this *pURLBuilder) Bytes(value []byte, dst reflect.Value) {
@@ -152,12 +174,8 @@ func (this *pURLBuilder) End() {
 	builderPanicBadEvent(this, pURLType, "End")
 }
 
-func (this *pURLBuilder) Marker(id interface{}) {
-	panic("TODO")
-}
-
 func (this *pURLBuilder) Reference(id interface{}) {
-	panic("TODO")
+	builderPanicBadEvent(this, pURLType, "Reference")
 }
 
 func (this *pURLBuilder) PrepareForListContents() {
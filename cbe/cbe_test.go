@@ -0,0 +1,420 @@
+package cbe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+const header = "8101"
+
+// Documents are written in hex without their version specifier, and may
+// contain spaces for readability
+func decodeHex(t *testing.T, document string) []byte {
+	data, err := hex.DecodeString(header + strings.Replace(document, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func assertEncoded(t *testing.T, value interface{}, expected string) {
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, value); err != nil {
+		t.Errorf("Encoding %v: %v", value, err)
+		return
+	}
+	expected = header + strings.Replace(expected, " ", "", -1)
+	if actual := hex.EncodeToString(buffer.Bytes()); actual != expected {
+		t.Errorf("Encoding %v: Expected %v but got %v", value, expected, actual)
+	}
+}
+
+func assertDecoded(t *testing.T, document string, target interface{}, expected interface{}) {
+	if err := Decode(bytes.NewReader(decodeHex(t, document)), target); err != nil {
+		t.Errorf("Decoding %v: %v", document, err)
+		return
+	}
+	actual := reflect.ValueOf(target).Elem().Interface()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoding %v: Expected %#v but got %#v", document, expected, actual)
+	}
+}
+
+func assertDecodeFails(t *testing.T, document string, target interface{}) {
+	if err := Decode(bytes.NewReader(decodeHex(t, document)), target); err == nil {
+		t.Errorf("Expected decoding %v to fail", document)
+	}
+}
+
+type CBEStruct struct {
+	Name  string
+	Count int64
+	Big   uint64
+	Ratio float64
+	Data  []byte
+	When  time.Time
+	Where *url.URL
+	Inner *CBEStruct
+	List  []int
+	Map   map[int]string
+}
+
+type CBENode struct {
+	Value int
+	Next  *CBENode
+}
+
+type SelfSlice []*SelfSlice
+
+// Each integer type code at the edges of the range that it's chosen for
+func TestIntegers(t *testing.T) {
+	for _, example := range []struct {
+		value    int64
+		expected string
+	}{
+		{0, "00"},
+		{100, "64"},
+		{101, "6865"},
+		{math.MaxUint8, "68ff"},
+		{math.MaxUint8 + 1, "6a0001"},
+		{math.MaxUint16, "6affff"},
+		{math.MaxUint16 + 1, "6c00000100"},
+		{math.MaxUint32, "6cffffffff"},
+		{math.MaxUint32 + 1, "6e0000000001000000"},
+		{math.MaxInt64, "6effffffffffffff7f"},
+		{-1, "ff"},
+		{-100, "9c"},
+		{-101, "6965"},
+		{-math.MaxUint8, "69ff"},
+		{-math.MaxUint8 - 1, "6b0001"},
+		{-math.MaxUint16 - 1, "6d00000100"},
+		{-math.MaxUint32 - 1, "6f0000000001000000"},
+		{math.MinInt64, "6f0000000000000080"},
+	} {
+		assertEncoded(t, example.value, example.expected)
+		var value interface{}
+		assertDecoded(t, example.expected, &value, example.value)
+	}
+	assertEncoded(t, uint64(math.MaxUint64), "6effffffffffffffff")
+	var value interface{}
+	assertDecoded(t, "6effffffffffffffff", &value, uint64(math.MaxUint64))
+
+	// Variable width integers are only decoded, and may be wider than needed
+	var i int
+	assertDecoded(t, "66 00", &i, 0)
+	assertDecoded(t, "66 02 e803", &i, 1000)
+	assertDecoded(t, "67 01 05", &i, -5)
+	assertDecoded(t, "66 08 0100000000000000", &i, 1)
+	assertDecoded(t, "6a 0100", &i, 1)
+
+	var i8 int8
+	assertDecodeFails(t, "6a e803", &i8)
+	var u uint
+	assertDecodeFails(t, "ff", &u)
+}
+
+func TestFloats(t *testing.T) {
+	// Float 32 is only used when it's lossless
+	assertEncoded(t, 1.5, "71 0000c03f")
+	assertEncoded(t, math.Inf(-1), "71 000080ff")
+	assertEncoded(t, 1.1, "72 9a9999999999f13f")
+
+	var f float64
+	assertDecoded(t, "71 0000c03f", &f, 1.5)
+	assertDecoded(t, "72 9a9999999999f13f", &f, 1.1)
+	assertDecoded(t, "70 c03f", &f, 1.5)
+	assertDecoded(t, "70 80bf", &f, -1.0)
+	if err := Decode(bytes.NewReader(decodeHex(t, "70 c07f")), &f); err != nil || !math.IsNaN(f) {
+		t.Errorf("Expected NaN but got %v (%v)", f, err)
+	}
+
+	var i int
+	assertDecoded(t, "70 0040", &i, 2)
+	assertDecodeFails(t, "71 0000c03f", &i)
+}
+
+func TestArrays(t *testing.T) {
+	assertEncoded(t, "", "80")
+	assertEncoded(t, "abc", "83 616263")
+	assertEncoded(t, strings.Repeat("a", 15), "8f"+strings.Repeat("61", 15))
+	assertEncoded(t, strings.Repeat("a", 16), "90 20"+strings.Repeat("61", 16))
+	assertEncoded(t, strings.Repeat("a", 64), "90 8001"+strings.Repeat("61", 64))
+	assertEncoded(t, []byte{}, "94 00")
+	assertEncoded(t, []byte{1, 2}, "94 04 0102")
+	assertEncoded(t, []byte(nil), "7e")
+	uri, _ := url.Parse("http://a.b")
+	assertEncoded(t, uri, "91 14 687474703a2f2f612e62")
+
+	// Any array may be split into chunks, ending with a chunk that has no
+	// continuation bit
+	var s string
+	assertDecoded(t, "90 06 616263", &s, "abc")
+	assertDecoded(t, "90 03 61 04 6263", &s, "abc")
+	assertDecoded(t, "90 01 03 61 01 04 6263", &s, "abc")
+	assertDecoded(t, "90 03 61 00", &s, "a")
+	var data []byte
+	assertDecoded(t, "94 00", &data, []byte{})
+	assertDecoded(t, "94 03 01 03 02 00", &data, []byte{1, 2})
+	var decodedURI *url.URL
+	assertDecoded(t, "91 03 68 12 7474703a2f2f612e62", &decodedURI, uri)
+
+	// Chunks are joined before the string is checked
+	assertDecoded(t, "90 03 c3 02 bc", &s, "ü")
+	assertDecodeFails(t, "90 02 c3", &s)
+	assertDecodeFails(t, "82 ff00", &s)
+	// Bytes are never strings
+	assertDecodeFails(t, "94 02 61", &s)
+}
+
+func TestTimestamps(t *testing.T) {
+	for _, example := range []struct {
+		value    time.Time
+		expected string
+	}{
+		{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), "9b 00001002 00"},
+		{time.Date(2000, 1, 1, 0, 0, 0, 1, time.UTC), "9b 0e00000000008400 00"},
+		{time.Date(1999, 12, 31, 23, 59, 59, 999999999, time.UTC), "9b fe4fd6dcf7fd7e06 01"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", -5*3600)), "9b c3b2208608 28 0a 2d30353030"},
+		{time.Date(2000, 1, 1, 0, 0, 0, 0, time.FixedZone("", 5*3600+30*60)), "9b 01001002 00 0a 2b30353330"},
+	} {
+		assertEncoded(t, example.value, example.expected)
+		var value time.Time
+		if err := Decode(bytes.NewReader(decodeHex(t, example.expected)), &value); err != nil {
+			t.Errorf("Decoding %v: %v", example.expected, err)
+			continue
+		}
+		_, expectedOffset := example.value.Zone()
+		if _, offset := value.Zone(); !value.Equal(example.value) || offset != expectedOffset {
+			t.Errorf("Decoding %v: Expected %v but got %v", example.expected, example.value, value)
+		}
+	}
+
+	var value time.Time
+	for _, document := range []string{
+		"9b e0011002 00",           // Second 60
+		"9b 00001c02 00",           // Hour 24
+		"9b 0000101a 00",           // Month 13
+		"9b 00000002 00",           // Day 0
+		"9b 0000e005 00",           // February 30
+		"9b 421f004008 00",         // 1000 milliseconds
+		"9b 01001002 00 01",        // Latitude/longitude
+		"9b 01001002 00 06 616263", // Not a UTC offset
+		"9b 01001002 00 0a 2b32343030",
+		"9b 000010",
+		"9b 00001002",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+}
+
+func TestContainers(t *testing.T) {
+	assertEncoded(t, []int{}, "7a 7b")
+	assertEncoded(t, []interface{}{1, []int{2, 3}}, "7a 01 7a 02 03 7b 7b")
+	assertEncoded(t, map[string]int{"a": 1}, "79 8161 01 7b")
+	assertEncoded(t, CBENode{Value: 1}, "79 8556616c7565 01 844e657874 7e 7b")
+
+	var value interface{}
+	assertDecoded(t, "7a 01 7f 7a 02 7f 7f 03 7b 7b", &value,
+		[]interface{}{int64(1), []interface{}{int64(2), int64(3)}})
+	assertDecoded(t, "79 8161 01 01 7d 7b", &value, map[interface{}]interface{}{"a": int64(1), int64(1): true})
+	assertDecoded(t, "7f 7e", &value, nil)
+	var p *int
+	assertDecoded(t, "7e", &p, (*int)(nil))
+	var b bool
+	assertDecoded(t, "7c", &b, false)
+}
+
+func TestMarkersAndReferences(t *testing.T) {
+	shared := &CBENode{Value: 1}
+	sharedHex := "7a 9700 79 8556616c7565 01 844e657874 7e 7b 9800 7b"
+	assertEncoded(t, []*CBENode{shared, shared}, sharedHex)
+	var nodes []*CBENode
+	assertDecoded(t, sharedHex, &nodes, []*CBENode{shared, shared})
+	if nodes[0] != nodes[1] {
+		t.Errorf("Expected both elements to point to the same node")
+	}
+
+	cycle := &CBENode{Value: 1, Next: &CBENode{Value: 2}}
+	cycle.Next.Next = cycle
+	assertEncoded(t, cycle, "9700 79 8556616c7565 01 844e657874 79 8556616c7565 02 844e657874 9800 7b 7b")
+	// Marker IDs don't have to be small, or start at 0
+	for _, document := range []string{
+		"9700 79 8556616c7565 01 844e657874 79 8556616c7565 02 844e657874 9800 7b 7b",
+		"97 68c8 79 844e657874 79 844e657874 98 68c8 8556616c7565 02 7b 8556616c7565 01 7b",
+		"97 66 02 e803 79 844e657874 79 844e657874 98 6ae803 8556616c7565 02 7b 8556616c7565 01 7b",
+	} {
+		var rebuilt *CBENode
+		if err := Decode(bytes.NewReader(decodeHex(t, document)), &rebuilt); err != nil {
+			t.Errorf("Decoding %v: %v", document, err)
+			continue
+		}
+		if rebuilt.Value != 1 || rebuilt.Next.Value != 2 || rebuilt.Next.Next != rebuilt {
+			t.Errorf("Decoding %v: Expected a two element cycle", document)
+		}
+	}
+
+	// Lists that contain themselves
+	s := SelfSlice{nil, nil}
+	s[1] = &s
+	assertEncoded(t, s, "9700 7a 7e 9800 7b")
+	var rebuiltSlice SelfSlice
+	if err := Decode(bytes.NewReader(decodeHex(t, "9700 7a 7e 9800 7b")), &rebuiltSlice); err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuiltSlice) != 2 || rebuiltSlice[0] != nil || (*rebuiltSlice[1])[1] != rebuiltSlice[1] {
+		t.Errorf("Expected a slice containing a pointer to itself but got %v", rebuiltSlice)
+	}
+
+	var value interface{}
+	for _, document := range []string{
+		"9700 9800",          // A marker must mark an object
+		"9700 7b",            // ...and so it can't end a container
+		"7a 9700 7b",         // ...even inside one
+		"9700 9701 01",       // ...or be another marker
+		"7a 9800 9700 01 7b", // References must come after their marker
+		"97ff 01",            // Marker IDs can't be negative
+		"97 8161 01",         // ...or strings
+		"9700",
+		"98",
+		"7a 9700 01 9700 02 7b", // Each marker ID may only be used once
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+}
+
+func TestEncodeFail(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, 1+2i); err == nil {
+		t.Errorf("Expected encoding a complex number to fail")
+	}
+
+	encoder := NewEncoder(&bytes.Buffer{})
+	encoder.OnMapBegin()
+	encoder.OnInt(1)
+	if err := encoder.OnContainerEnd(); err == nil {
+		t.Errorf("Expected ending a map after a key to fail")
+	}
+
+	encoder = NewEncoder(&bytes.Buffer{})
+	if err := encoder.OnReference(uint64(0)); err == nil {
+		t.Errorf("Expected a reference to an unknown marker to fail")
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", -5*3600))
+	where, _ := url.Parse("http://example.com/a?b=c")
+	expected := CBEStruct{
+		Name:  "a name longer than fifteen bytes",
+		Count: math.MinInt64,
+		Big:   math.MaxUint64,
+		Ratio: 0.1,
+		Data:  []byte{1, 2, 3},
+		When:  when,
+		Where: where,
+		Inner: &CBEStruct{Name: "inner", List: []int{}},
+		List:  []int{1, 2, 3},
+		Map:   map[int]string{-1: "v"},
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, expected); err != nil {
+		t.Fatal(err)
+	}
+	var actual CBEStruct
+	if err := Decode(buffer, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !actual.When.Equal(when) || actual.When.Format(time.RFC3339) != when.Format(time.RFC3339) {
+		t.Errorf("Expected time %v but got %v", when, actual.When)
+	}
+	actual.When = when
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, actual)
+	}
+}
+
+func TestDecodeFail(t *testing.T) {
+	var value interface{}
+	for _, document := range []string{
+		"", "68", "6a01", "6e01020304050607", "83 6162", "90 0a 6162", "90 03 61", "91 02 25",
+		"7a 01", "79 01 7b", "79 01 7f 7b", "7b", "73", "65 00", "6f 0100000000000080",
+		"67 09 010000000000000000", "01 02", "90 ffffffffffffffffff01",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+
+	for _, document := range []string{"", "01", "8102 01", "83 01 01", "81"} {
+		data, _ := hex.DecodeString(document)
+		if err := Decode(bytes.NewReader(data), &value); err == nil {
+			t.Errorf("Expected decoding %v without a valid version specifier to fail", document)
+		}
+	}
+
+	var i int8
+	assertDecodeFails(t, "6ae803", &i)
+	assertDecodeFails(t, "710000c03f", &i)
+
+	err := Decode(bytes.NewReader(decodeHex(t, "7a 01 73")), &value)
+	if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Offset != 5 {
+		t.Errorf("Expected a syntax error at offset 5 but got %v", err)
+	}
+
+	assertDecodeFails(t, strings.Repeat("7a", maxDepth+1), &value)
+}
+
+// Each document has its own version specifier, and marker IDs start over in
+// each one
+func TestDocumentSequence(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	encoder := NewEncoder(buffer)
+	shared := &CBENode{Value: 1}
+	for _, value := range []interface{}{[]*CBENode{shared, shared}, 2, []*CBENode{shared, shared}} {
+		if err := reconstruct.IterateObject(value, true, encoder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := "7a9700798556616c756501844e6578747e7b98007b"
+	expectedHex := header + node + header + "02" + header + node
+	if actual := hex.EncodeToString(buffer.Bytes()); actual != expectedHex {
+		t.Errorf("Expected %v but got %v", expectedHex, actual)
+	}
+
+	decoder := NewDecoder(buffer, &reconstruct.BuilderOptions{UseInt: true, StringKeyedMaps: true})
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	nodeMap := map[string]interface{}{"Value": 1, "Next": nil}
+	list := []interface{}{nodeMap, nodeMap}
+	expected := []interface{}{list, 2, list}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values)
+	}
+
+	// A reference can't refer to a marker in an earlier document
+	decoder = NewDecoder(bytes.NewReader(decodeHex(t, "9700 01 8101 9800")), nil)
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoder.Decode(&value); err == nil {
+		t.Errorf("Expected a reference to a marker in an earlier document to fail")
+	}
+}
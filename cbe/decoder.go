@@ -0,0 +1,541 @@
+package cbe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// How deeply containers may nest before decoding fails.
+const maxDepth = 10000
+
+// SyntaxError describes a malformed CBE document.
+type SyntaxError struct {
+	// The byte offset where the error was detected
+	Offset int64
+	Msg    string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("CBE syntax error at offset %v: %v", this.Offset, this.Msg)
+}
+
+// Decode reads a single CBE document from reader into target, which must be a
+// non-nil pointer. Anything after the document is an error.
+func Decode(reader io.Reader, target interface{}) error {
+	decoder := NewDecoder(reader, nil)
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if _, err := decoder.readByte(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return decoder.syntaxError("Unexpected data after the end of the document")
+	}
+	return nil
+}
+
+// Decoder reads a stream of CBE documents, generating events as it goes rather
+// than building an intermediate representation.
+//
+// Positive integers are passed to OnInt if they fit in an int64, and to OnUint
+// otherwise. Negative integers that don't fit in an int64 cause an error.
+type Decoder struct {
+	reader    *bufio.Reader
+	offset    int64
+	options   reconstruct.BuilderOptions
+	callbacks reconstruct.ObjectIteratorCallbacks
+	depth     int
+	markerIDs map[uint64]bool
+}
+
+// NewDecoder creates a decoder that reads from reader, and builds values using
+// options (nil means use defaults).
+func NewDecoder(reader io.Reader, options *reconstruct.BuilderOptions) *Decoder {
+	this := &Decoder{
+		reader: bufio.NewReader(reader),
+	}
+	if options != nil {
+		this.options = *options
+	}
+	return this
+}
+
+// Decode reads the next CBE document into target, which must be a non-nil
+// pointer. Returns io.EOF if there are no more documents.
+func (this *Decoder) Decode(target interface{}) error {
+	dst := reflect.ValueOf(target)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("Cannot decode into %v: Target must be a non-nil pointer", reflect.TypeOf(target))
+	}
+
+	typeCode, err := this.beginDocument()
+	if err != nil {
+		return err
+	}
+
+	builder := reconstruct.NewBuilderWithOptions(target, &this.options)
+	this.callbacks = builder
+	if err := this.decodeObject(typeCode); err != nil {
+		return err
+	}
+	return builder.StoreBuiltObject(target)
+}
+
+// DecodeEvents reads the next CBE document, passing its events to callbacks.
+// Returns io.EOF if there are no more documents.
+func (this *Decoder) DecodeEvents(callbacks reconstruct.ObjectIteratorCallbacks) error {
+	typeCode, err := this.beginDocument()
+	if err != nil {
+		return err
+	}
+	this.callbacks = callbacks
+	return this.decodeObject(typeCode)
+}
+
+// Read the version specifier, returning the type code of the top-level object.
+func (this *Decoder) beginDocument() (byte, error) {
+	this.depth = 0
+	this.markerIDs = make(map[uint64]bool)
+	b, err := this.readByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != versionSpecifier {
+		return 0, this.syntaxError("Expected version specifier but got %02x", b)
+	}
+	documentVersion, err := this.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if documentVersion != version {
+		return 0, this.syntaxError("Unsupported version %v", documentVersion)
+	}
+	return this.readTypeCode()
+}
+
+func (this *Decoder) syntaxError(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Offset: this.offset,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (this *Decoder) readByte() (byte, error) {
+	b, err := this.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	this.offset++
+	return b, nil
+}
+
+// Read a byte that must exist because we're partway through a document.
+func (this *Decoder) readByteInDocument() (byte, error) {
+	b, err := this.readByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// Read the next type code, skipping padding.
+func (this *Decoder) readTypeCode() (byte, error) {
+	for {
+		b, err := this.readByteInDocument()
+		if err != nil || b != typePadding {
+			return b, err
+		}
+	}
+}
+
+func (this *Decoder) readUvarint() (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := this.readByteInDocument()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if shift == 63 && b > 1 {
+				break
+			}
+			return value, nil
+		}
+	}
+	return 0, this.syntaxError("Variable length integer overflows 64 bits")
+}
+
+// Read a little endian unsigned integer of size bytes.
+func (this *Decoder) readUint(size int) (uint64, error) {
+	var buffer [8]byte
+	n, err := io.ReadFull(this.reader, buffer[:size])
+	this.offset += int64(n)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return binary.LittleEndian.Uint64(buffer[:]), err
+}
+
+// Read the magnitude of a variable width integer, which is preceded by its
+// length in bytes.
+func (this *Decoder) readVariableWidthUint() (uint64, error) {
+	size, err := this.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if size > 8 {
+		return 0, this.syntaxError("Integer of %v bytes is out of range", size)
+	}
+	return this.readUint(int(size))
+}
+
+// Read an array's chunks, growing the buffer as data arrives so that a bogus
+// length can't force a huge allocation.
+func (this *Decoder) readArray() ([]byte, error) {
+	// Start non-nil so that empty data isn't mistaken for nil
+	buffer := bytes.NewBuffer([]byte{})
+	for {
+		header, err := this.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		length := header >> 1
+		if length > math.MaxInt64 {
+			return nil, this.syntaxError("Chunk length %v is too large", length)
+		}
+		n, err := io.CopyN(buffer, this.reader, int64(length))
+		this.offset += n
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header&1 == 0 {
+			return buffer.Bytes(), nil
+		}
+	}
+}
+
+// Read exactly length bytes of data.
+func (this *Decoder) readData(length int) ([]byte, error) {
+	data := make([]byte, length)
+	n, err := io.ReadFull(this.reader, data)
+	this.offset += int64(n)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+func (this *Decoder) readString(data []byte, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", this.syntaxError("String contains invalid UTF-8")
+	}
+	return string(data), nil
+}
+
+func (this *Decoder) enter() error {
+	this.depth++
+	if this.depth > maxDepth {
+		return this.syntaxError("Exceeded maximum nesting depth of %v", maxDepth)
+	}
+	return nil
+}
+
+func (this *Decoder) decodeObject(typeCode byte) error {
+	switch {
+	case typeCode <= maxSmallInt:
+		return this.callbacks.OnInt(int64(typeCode))
+	case int8(typeCode) >= minSmallInt && int8(typeCode) < 0:
+		return this.callbacks.OnInt(int64(int8(typeCode)))
+	case typeCode&0xf0 == typeShortString:
+		value, err := this.readString(this.readData(int(typeCode & 0x0f)))
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnString(value)
+	}
+
+	switch typeCode {
+	case typePosInt, typePosInt8, typePosInt16, typePosInt32, typePosInt64:
+		value, err := this.readMagnitude(typeCode)
+		if err != nil {
+			return err
+		}
+		if value <= math.MaxInt64 {
+			return this.callbacks.OnInt(int64(value))
+		}
+		return this.callbacks.OnUint(value)
+	case typeNegInt, typeNegInt8, typeNegInt16, typeNegInt32, typeNegInt64:
+		magnitude, err := this.readMagnitude(typeCode)
+		if err != nil {
+			return err
+		}
+		if magnitude > 1<<63 {
+			return this.syntaxError("Negative integer -%v is out of range", magnitude)
+		}
+		return this.callbacks.OnInt(int64(-magnitude))
+	case typeFloat16:
+		// bfloat16 is the upper half of a float32
+		value, err := this.readUint(2)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(float64(math.Float32frombits(uint32(value) << 16)))
+	case typeFloat32:
+		value, err := this.readUint(4)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(float64(math.Float32frombits(uint32(value))))
+	case typeFloat64:
+		value, err := this.readUint(8)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(math.Float64frombits(value))
+	case typeFalse:
+		return this.callbacks.OnBool(false)
+	case typeTrue:
+		return this.callbacks.OnBool(true)
+	case typeNull:
+		return this.callbacks.OnNil()
+	case typeString:
+		value, err := this.readString(this.readArray())
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnString(value)
+	case typeURI:
+		str, err := this.readString(this.readArray())
+		if err != nil {
+			return err
+		}
+		value, err := url.Parse(str)
+		if err != nil {
+			return this.syntaxError("Invalid URI %q: %v", str, err)
+		}
+		return this.callbacks.OnURI(value)
+	case typeBytes:
+		value, err := this.readArray()
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnBytes(value)
+	case typeTimestamp:
+		return this.decodeTimestamp()
+	case typeList:
+		if err := this.enter(); err != nil {
+			return err
+		}
+		if err := this.callbacks.OnListBegin(); err != nil {
+			return err
+		}
+		return this.decodeContainerContents(false)
+	case typeMap:
+		if err := this.enter(); err != nil {
+			return err
+		}
+		if err := this.callbacks.OnMapBegin(); err != nil {
+			return err
+		}
+		return this.decodeContainerContents(true)
+	case typeMarker:
+		return this.decodeMarker()
+	case typeReference:
+		id, err := this.readMarkerID()
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnReference(id)
+	case typeEnd:
+		return this.syntaxError("Unexpected end of container")
+	default:
+		return this.syntaxError("Unsupported type code %02x", typeCode)
+	}
+}
+
+// Read the magnitude of an integer whose positive or negative type code has
+// already been read.
+func (this *Decoder) readMagnitude(typeCode byte) (uint64, error) {
+	if typeCode == typePosInt || typeCode == typeNegInt {
+		return this.readVariableWidthUint()
+	}
+	return this.readUint(1 << ((typeCode - typePosInt8) / 2))
+}
+
+// Read a marker ID, which is a non-negative integer.
+func (this *Decoder) readMarkerID() (uint64, error) {
+	typeCode, err := this.readTypeCode()
+	if err != nil {
+		return 0, err
+	}
+	switch typeCode {
+	case typePosInt, typePosInt8, typePosInt16, typePosInt32, typePosInt64:
+		return this.readMagnitude(typeCode)
+	}
+	if typeCode > maxSmallInt {
+		return 0, this.syntaxError("Marker ID must be a non-negative integer, not type %02x", typeCode)
+	}
+	return uint64(typeCode), nil
+}
+
+func (this *Decoder) decodeContainerContents(isMap bool) error {
+	for count := 0; ; count++ {
+		typeCode, err := this.readTypeCode()
+		if err != nil {
+			return err
+		}
+		if typeCode == typeEnd {
+			if isMap && count%2 != 0 {
+				return this.syntaxError("Map ended with a key but no value")
+			}
+			break
+		}
+		if err := this.decodeObject(typeCode); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeMarker() error {
+	id, err := this.readMarkerID()
+	if err != nil {
+		return err
+	}
+	if this.markerIDs[id] {
+		return this.syntaxError("Marker ID %v has already been used", id)
+	}
+	this.markerIDs[id] = true
+	if err := this.callbacks.OnMarker(id); err != nil {
+		return err
+	}
+	typeCode, err := this.readTypeCode()
+	if err != nil {
+		return err
+	}
+	switch typeCode {
+	case typeMarker, typeReference, typeEnd:
+		return this.syntaxError("Marker must be followed by an object")
+	}
+	return this.decodeObject(typeCode)
+}
+
+func (this *Decoder) decodeTimestamp() error {
+	// The magnitude in the first byte determines how many bytes the fixed
+	// fields take.
+	first, err := this.readByteInDocument()
+	if err != nil {
+		return err
+	}
+	magnitude := uint((first >> bitsTimeZone) & 3)
+	bitCount := bitsTimeZone + bitsMagnitude + magnitude*bitsSubsecond +
+		bitsSecond + bitsMinute + bitsHour + bitsDay + bitsMonth
+	fields := uint64(first)
+	for i := uint(8); i < bitCount; i += 8 {
+		b, err := this.readByteInDocument()
+		if err != nil {
+			return err
+		}
+		fields |= uint64(b) << i
+	}
+	field := func(bits uint) int {
+		value := fields & (1<<bits - 1)
+		fields >>= bits
+		return int(value)
+	}
+	hasTimeZone := field(bitsTimeZone) == 1
+	field(bitsMagnitude)
+	subsecond := field(magnitude * bitsSubsecond)
+	second := field(bitsSecond)
+	minute := field(bitsMinute)
+	hour := field(bitsHour)
+	day := field(bitsDay)
+	month := field(bitsMonth)
+
+	zigzagYear, err := this.readUvarint()
+	if err != nil {
+		return err
+	}
+	year := int64(zigzagYear>>1) ^ -int64(zigzagYear&1)
+
+	location := time.UTC
+	if hasTimeZone {
+		if location, err = this.readTimeZone(); err != nil {
+			return err
+		}
+	}
+
+	for i := uint(0); i < 3-magnitude; i++ {
+		subsecond *= 1000
+	}
+	switch {
+	case subsecond >= 1e9:
+		return this.syntaxError("Timestamp subseconds out of range")
+	case second >= 60:
+		return this.syntaxError("Timestamp second %v out of range", second)
+	case minute >= 60:
+		return this.syntaxError("Timestamp minute %v out of range", minute)
+	case hour >= 24:
+		return this.syntaxError("Timestamp hour %v out of range", hour)
+	case month < 1 || month > 12:
+		return this.syntaxError("Timestamp month %v out of range", month)
+	case year < math.MinInt32 || year > math.MaxInt32-yearBias:
+		return this.syntaxError("Timestamp year offset %v out of range", year)
+	}
+	value := time.Date(int(year)+yearBias, time.Month(month), day, hour, minute, second, subsecond, location)
+	if value.Day() != day {
+		return this.syntaxError("Timestamp day %v out of range", day)
+	}
+	return this.callbacks.OnTime(value)
+}
+
+// Read a time zone, which must be a UTC offset such as "+0530" or "-0800".
+func (this *Decoder) readTimeZone() (*time.Location, error) {
+	header, err := this.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if header&1 != 0 {
+		return nil, this.syntaxError("Latitude/longitude time zones are not supported")
+	}
+	if header>>1 > math.MaxUint8 {
+		return nil, this.syntaxError("Time zone length %v is too long", header>>1)
+	}
+	zone, err := this.readString(this.readData(int(header >> 1)))
+	if err != nil {
+		return nil, err
+	}
+	if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return nil, this.syntaxError("Unsupported time zone %q", zone)
+	}
+	hours, hoursErr := strconv.ParseUint(zone[1:3], 10, 8)
+	minutes, minutesErr := strconv.ParseUint(zone[3:5], 10, 8)
+	if hoursErr != nil || minutesErr != nil || hours >= 24 || minutes >= 60 {
+		return nil, this.syntaxError("Invalid UTC offset %q", zone)
+	}
+	offset := int(hours*60+minutes) * 60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset), nil
+}
@@ -0,0 +1,478 @@
+// Package cbe encodes and decodes Concise Binary Encoding (CBE) using
+// reconstruct's iterators and builders.
+//
+// CBE shares its data model with reconstruct's events, including markers and
+// references, so every event except Complex has a native representation. Every
+// top-level object is a document, beginning with the version specifier 81 01.
+// This package supports the following subset of the format:
+//
+//	00 to 64             Integer 0 to 100
+//	9c to ff             Integer -100 to -1
+//	66 / 67              Positive / negative integer, followed by its length in bytes and then
+//	                     its little endian magnitude (decoding only)
+//	68 / 69              Positive / negative integer, 8 bit magnitude
+//	6a / 6b              Positive / negative integer, 16 bit little endian magnitude
+//	6c / 6d              Positive / negative integer, 32 bit little endian magnitude
+//	6e / 6f              Positive / negative integer, 64 bit little endian magnitude
+//	70                   Binary float, 16 bit bfloat16, little endian (decoding only)
+//	71                   Binary float, 32 bit, little endian
+//	72                   Binary float, 64 bit, little endian
+//	79                   Map, followed by alternating keys and values and then end of container
+//	7a                   List, followed by its contents and then end of container
+//	7b                   End of container
+//	7c / 7d              False / true
+//	7e                   Null
+//	7f                   Padding (skipped when decoding)
+//	80 to 8f             String of 0 to 15 bytes, followed by its UTF-8 data
+//	90                   String, followed by its UTF-8 data in chunks
+//	91                   Resource identifier (URI), followed by its UTF-8 data in chunks
+//	94                   Bytes (uint8 array), followed by its data in chunks
+//	97                   Marker, followed by its marker ID and then the marked object
+//	98                   Reference, followed by a marker ID
+//	9b                   Timestamp, in compact time format
+//
+// Each chunk begins with a header holding its length shifted left by one, with
+// the low bit set if another chunk follows. Lengths, chunk headers, and the
+// document version are unsigned LEB128. Marker IDs are ordinary CBE integers,
+// which must not be negative.
+//
+// A compact timestamp begins with these fields, packed little endian from the
+// lowest bit into as few bytes as will hold them:
+//
+//	Time zone flag       1 bit, set if a time zone follows
+//	Magnitude            2 bits, the subsecond precision (0 = none, 1 = milli, 2 = micro, 3 = nano)
+//	Subseconds           10 bits per magnitude
+//	Second               6 bits
+//	Minute               6 bits
+//	Hour                 5 bits
+//	Day                  5 bits
+//	Month                4 bits
+//
+// They're followed by the year's offset from 2000, zigzag encoded and then
+// unsigned LEB128. A time zone is an unsigned LEB128 header holding its length
+// shifted left by one, followed by the zone as UTF-8. This package writes zones
+// as UTC offsets (such as "+0530" or "-0800"), and leaves the zone out for UTC.
+//
+// Marker IDs are assigned in the order that markers are encoded, starting at
+// 0 in each document.
+package cbe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+const (
+	versionSpecifier = 0x81
+	version          = 1
+
+	maxSmallInt = 100
+	minSmallInt = -100
+
+	typePosInt      = 0x66
+	typeNegInt      = 0x67
+	typePosInt8     = 0x68
+	typeNegInt8     = 0x69
+	typePosInt16    = 0x6a
+	typeNegInt16    = 0x6b
+	typePosInt32    = 0x6c
+	typeNegInt32    = 0x6d
+	typePosInt64    = 0x6e
+	typeNegInt64    = 0x6f
+	typeFloat16     = 0x70
+	typeFloat32     = 0x71
+	typeFloat64     = 0x72
+	typeMap         = 0x79
+	typeList        = 0x7a
+	typeEnd         = 0x7b
+	typeFalse       = 0x7c
+	typeTrue        = 0x7d
+	typeNull        = 0x7e
+	typePadding     = 0x7f
+	typeShortString = 0x80
+	typeString      = 0x90
+	typeURI         = 0x91
+	typeBytes       = 0x94
+	typeMarker      = 0x97
+	typeReference   = 0x98
+	typeTimestamp   = 0x9b
+
+	maxShortStringLength = 15
+)
+
+// Compact timestamp fields
+const (
+	yearBias = 2000
+
+	bitsTimeZone  = 1
+	bitsMagnitude = 2
+	bitsSubsecond = 10 // Per magnitude
+	bitsSecond    = 6
+	bitsMinute    = 6
+	bitsHour      = 5
+	bitsDay       = 5
+	bitsMonth     = 4
+)
+
+// Encode writes value to writer as a CBE document. Pointers to the same data
+// are encoded once and then referenced, so values containing cycles can be
+// encoded.
+func Encode(writer io.Writer, value interface{}) error {
+	iterOptions := &reconstruct.IteratorOptions{UseReferences: true}
+	return reconstruct.IterateObjectWithOptions(value, iterOptions, NewEncoder(writer))
+}
+
+// How much output to buffer before writing to the underlying writer
+const flushThreshold = 4096
+
+type encoderContainer struct {
+	isMap bool
+	count int
+}
+
+// Encoder implements ObjectIteratorCallbacks, writing each top-level object as
+// a CBE document.
+type Encoder struct {
+	writer     io.Writer
+	buffer     []byte
+	containers []encoderContainer
+	inDocument bool
+	markerIDs  map[interface{}]uint64
+	err        error
+}
+
+// NewEncoder creates an encoder that writes to writer.
+func NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{
+		writer: writer,
+	}
+}
+
+func (this *Encoder) fail(format string, args ...interface{}) error {
+	this.err = fmt.Errorf(format, args...)
+	return this.err
+}
+
+func (this *Encoder) flush() error {
+	if _, err := this.writer.Write(this.buffer); err != nil {
+		this.err = err
+		return err
+	}
+	this.buffer = this.buffer[:0]
+	return nil
+}
+
+func appendUvarint(buffer []byte, value uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	return append(buffer, encoded[:binary.PutUvarint(encoded[:], value)]...)
+}
+
+// Append an integer with the specified magnitude, using the positive or
+// negative type code for the smallest size that can hold it.
+func appendMagnitude(buffer []byte, magnitude uint64, isNegative bool) []byte {
+	var typeCode byte
+	var size int
+	switch {
+	case magnitude <= math.MaxUint8:
+		typeCode, size = typePosInt8, 1
+	case magnitude <= math.MaxUint16:
+		typeCode, size = typePosInt16, 2
+	case magnitude <= math.MaxUint32:
+		typeCode, size = typePosInt32, 4
+	default:
+		typeCode, size = typePosInt64, 8
+	}
+	if isNegative {
+		typeCode++
+	}
+	buffer = append(buffer, typeCode, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(buffer[len(buffer)-8:], magnitude)
+	return buffer[:len(buffer)-8+size]
+}
+
+func appendInt(buffer []byte, value int64) []byte {
+	switch {
+	case value >= minSmallInt && value <= maxSmallInt:
+		return append(buffer, byte(value))
+	case value < 0:
+		return appendMagnitude(buffer, uint64(^value)+1, true)
+	default:
+		return appendMagnitude(buffer, uint64(value), false)
+	}
+}
+
+func appendUint(buffer []byte, value uint64) []byte {
+	if value <= maxSmallInt {
+		return append(buffer, byte(value))
+	}
+	return appendMagnitude(buffer, value, false)
+}
+
+func appendFloat(buffer []byte, value float64) []byte {
+	if float64(float32(value)) == value || math.IsNaN(value) {
+		buffer = append(buffer, typeFloat32, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buffer[len(buffer)-4:], math.Float32bits(float32(value)))
+		return buffer
+	}
+	buffer = append(buffer, typeFloat64, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(buffer[len(buffer)-8:], math.Float64bits(value))
+	return buffer
+}
+
+// Append an array as a single chunk.
+func appendArray(buffer []byte, typeCode byte, data []byte) []byte {
+	buffer = appendUvarint(append(buffer, typeCode), uint64(len(data))<<1)
+	return append(buffer, data...)
+}
+
+func appendString(buffer []byte, value string) []byte {
+	if len(value) <= maxShortStringLength {
+		buffer = append(buffer, typeShortString|byte(len(value)))
+		return append(buffer, value...)
+	}
+	return appendArray(buffer, typeString, []byte(value))
+}
+
+func appendTimestamp(buffer []byte, value time.Time) []byte {
+	_, offset := value.Zone()
+	var timeZone string
+	if offset != 0 {
+		sign := '+'
+		minutes := offset / 60
+		if minutes < 0 {
+			sign = '-'
+			minutes = -minutes
+		}
+		timeZone = fmt.Sprintf("%c%02d%02d", sign, minutes/60, minutes%60)
+	}
+
+	subsecond := uint64(value.Nanosecond())
+	magnitude := uint64(3)
+	for magnitude > 0 && subsecond%1000 == 0 {
+		subsecond /= 1000
+		magnitude--
+	}
+
+	var fields uint64
+	var bitCount uint
+	addField := func(value uint64, bits uint) {
+		fields |= value << bitCount
+		bitCount += bits
+	}
+	if timeZone != "" {
+		addField(1, bitsTimeZone)
+	} else {
+		addField(0, bitsTimeZone)
+	}
+	addField(magnitude, bitsMagnitude)
+	addField(subsecond, uint(magnitude)*bitsSubsecond)
+	addField(uint64(value.Second()), bitsSecond)
+	addField(uint64(value.Minute()), bitsMinute)
+	addField(uint64(value.Hour()), bitsHour)
+	addField(uint64(value.Day()), bitsDay)
+	addField(uint64(value.Month()), bitsMonth)
+
+	buffer = append(buffer, typeTimestamp)
+	for i := uint(0); i < bitCount; i += 8 {
+		buffer = append(buffer, byte(fields>>i))
+	}
+	year := int64(value.Year() - yearBias)
+	buffer = appendUvarint(buffer, uint64(year<<1)^uint64(year>>63))
+	if timeZone != "" {
+		buffer = appendUvarint(buffer, uint64(len(timeZone))<<1)
+		buffer = append(buffer, timeZone...)
+	}
+	return buffer
+}
+
+// Check for errors and write the version specifier if this is the start of a
+// top-level object.
+func (this *Encoder) beginDocument() error {
+	if this.err != nil {
+		return this.err
+	}
+	if !this.inDocument {
+		this.buffer = append(this.buffer, versionSpecifier, version)
+		this.inDocument = true
+	}
+	return nil
+}
+
+func (this *Encoder) beginValue() error {
+	if err := this.beginDocument(); err != nil {
+		return err
+	}
+	if len(this.containers) > 0 {
+		this.containers[len(this.containers)-1].count++
+	}
+	return nil
+}
+
+func (this *Encoder) endValue() error {
+	if len(this.containers) > 0 {
+		if len(this.buffer) >= flushThreshold {
+			return this.flush()
+		}
+		return nil
+	}
+	this.inDocument = false
+	this.markerIDs = nil
+	return this.flush()
+}
+
+func (this *Encoder) beginContainer(typeCode byte, isMap bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, typeCode)
+	this.containers = append(this.containers, encoderContainer{isMap: isMap})
+	return nil
+}
+
+// -----------------------
+// ObjectIteratorCallbacks
+// -----------------------
+
+func (this *Encoder) OnNil() error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, typeNull)
+	return this.endValue()
+}
+
+func (this *Encoder) OnBool(value bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if value {
+		this.buffer = append(this.buffer, typeTrue)
+	} else {
+		this.buffer = append(this.buffer, typeFalse)
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnInt(value int64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendInt(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnUint(value uint64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendUint(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnFloat(value float64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendFloat(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnComplex(value complex128) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("CBE cannot represent complex value %v", value)
+}
+
+func (this *Encoder) OnString(value string) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendString(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnBytes(value []byte) error {
+	if value == nil {
+		return this.OnNil()
+	}
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendArray(this.buffer, typeBytes, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnURI(value *url.URL) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendArray(this.buffer, typeURI, []byte(value.String()))
+	return this.endValue()
+}
+
+func (this *Encoder) OnTime(value time.Time) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendTimestamp(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnListBegin() error {
+	return this.beginContainer(typeList, false)
+}
+
+func (this *Encoder) OnMapBegin() error {
+	return this.beginContainer(typeMap, true)
+}
+
+func (this *Encoder) OnContainerEnd() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) == 0 {
+		return this.fail("Container end without a matching container begin")
+	}
+	container := this.containers[len(this.containers)-1]
+	if container.isMap && container.count%2 != 0 {
+		return this.fail("CBE map ended with a key but no value")
+	}
+	this.containers = this.containers[:len(this.containers)-1]
+	this.buffer = append(this.buffer, typeEnd)
+	return this.endValue()
+}
+
+func (this *Encoder) OnMarker(id interface{}) error {
+	if err := this.beginDocument(); err != nil {
+		return err
+	}
+	if this.markerIDs == nil {
+		this.markerIDs = make(map[interface{}]uint64)
+	}
+	if _, exists := this.markerIDs[id]; exists {
+		return this.fail("Marker ID %v has already been used", id)
+	}
+	markerID := uint64(len(this.markerIDs))
+	this.markerIDs[id] = markerID
+	this.buffer = appendUint(append(this.buffer, typeMarker), markerID)
+	return nil
+}
+
+func (this *Encoder) OnReference(id interface{}) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	markerID, ok := this.markerIDs[id]
+	if !ok {
+		return this.fail("Reference to unknown marker ID %v", id)
+	}
+	this.buffer = appendUint(append(this.buffer, typeReference), markerID)
+	return this.endValue()
+}
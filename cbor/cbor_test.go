@@ -0,0 +1,468 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// Documents are written in hex, and may contain spaces for readability
+func decodeHex(t *testing.T, document string) []byte {
+	data, err := hex.DecodeString(strings.Replace(document, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func assertEncoded(t *testing.T, value interface{}, options *EncoderOptions, expected string) {
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, value, options); err != nil {
+		t.Errorf("Encoding %v: %v", value, err)
+		return
+	}
+	expected = strings.Replace(expected, " ", "", -1)
+	if actual := hex.EncodeToString(buffer.Bytes()); actual != expected {
+		t.Errorf("Encoding %v: Expected %v but got %v", value, expected, actual)
+	}
+}
+
+func assertDecoded(t *testing.T, document string, target interface{}, expected interface{}) {
+	if err := Decode(bytes.NewReader(decodeHex(t, document)), target); err != nil {
+		t.Errorf("Decoding %v: %v", document, err)
+		return
+	}
+	actual := reflect.ValueOf(target).Elem().Interface()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoding %v: Expected %#v but got %#v", document, expected, actual)
+	}
+}
+
+func assertDecodeFails(t *testing.T, document string, target interface{}) {
+	if err := Decode(bytes.NewReader(decodeHex(t, document)), target); err == nil {
+		t.Errorf("Expected decoding %v to fail", document)
+	}
+}
+
+func uri(str string) *url.URL {
+	value, err := url.Parse(str)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func ints(from, to int64) []interface{} {
+	var values []interface{}
+	for i := from; i <= to; i++ {
+		values = append(values, i)
+	}
+	return values
+}
+
+type CBORStruct struct {
+	Name  string
+	Count int64
+	Big   uint64
+	Ratio float64
+	Data  []byte
+	When  time.Time
+	Where *url.URL
+	Inner *CBORStruct
+	List  []int
+	Map   map[int]string
+}
+
+type CBORNode struct {
+	Value int
+	Next  *CBORNode
+}
+
+// Field order matches the RFC 8949 example {_ "Fun": true, "Amt": -2}
+type FunAmt struct {
+	Fun bool
+	Amt int
+}
+
+type SelfSlice []*SelfSlice
+
+// The examples from RFC 8949 Appendix A that this package can represent,
+// decoded into interface{}.
+var rfc8949Examples = []struct {
+	document string
+	expected interface{}
+}{
+	{"00", int64(0)},
+	{"01", int64(1)},
+	{"0a", int64(10)},
+	{"17", int64(23)},
+	{"1818", int64(24)},
+	{"1819", int64(25)},
+	{"1864", int64(100)},
+	{"1903e8", int64(1000)},
+	{"1a000f4240", int64(1000000)},
+	{"1b000000e8d4a51000", int64(1000000000000)},
+	{"1bffffffffffffffff", uint64(18446744073709551615)},
+	{"20", int64(-1)},
+	{"29", int64(-10)},
+	{"3863", int64(-100)},
+	{"3903e7", int64(-1000)},
+	{"f90000", 0.0},
+	{"f93c00", 1.0},
+	{"fb3ff199999999999a", 1.1},
+	{"f93e00", 1.5},
+	{"f97bff", 65504.0},
+	{"fa47c35000", 100000.0},
+	{"fa7f7fffff", 3.4028234663852886e+38},
+	{"fb7e37e43c8800759c", 1.0e+300},
+	{"f90001", 5.960464477539063e-8},
+	{"f90400", 0.00006103515625},
+	{"f9c400", -4.0},
+	{"fbc010666666666666", -4.1},
+	{"f97c00", math.Inf(1)},
+	{"f9fc00", math.Inf(-1)},
+	{"fa7f800000", math.Inf(1)},
+	{"faff800000", math.Inf(-1)},
+	{"fb7ff0000000000000", math.Inf(1)},
+	{"fbfff0000000000000", math.Inf(-1)},
+	{"f4", false},
+	{"f5", true},
+	{"f6", nil},
+	{"f7", nil},
+	{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+	{"c11a514b67b0", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+	{"c1fb41d452d9ec200000", time.Date(2013, 3, 21, 20, 4, 0, 500000000, time.UTC)},
+	{"d74401020304", []byte{1, 2, 3, 4}},
+	{"d818456449455446", []byte{0x64, 0x49, 0x45, 0x54, 0x46}},
+	{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", uri("http://www.example.com")},
+	{"40", []byte{}},
+	{"4401020304", []byte{1, 2, 3, 4}},
+	{"60", ""},
+	{"6161", "a"},
+	{"6449455446", "IETF"},
+	{"62225c", "\"\\"},
+	{"62c3bc", "ü"},
+	{"63e6b0b4", "水"},
+	{"64f0908591", "\U00010151"},
+	{"80", []interface{}{}},
+	{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+	{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+	{"98190102030405060708090a0b0c0d0e0f101112131415161718181819", ints(1, 25)},
+	{"a0", map[interface{}]interface{}{}},
+	{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+	{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	{"826161a161626163", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}},
+	{"a56161614161626142616361436164614461656145", map[interface{}]interface{}{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}},
+	{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+	{"7f657374726561646d696e67ff", "streaming"},
+	{"9fff", []interface{}{}},
+	{"9f018202039f0405ffff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+	{"9f01820203820405ff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+	{"83018202039f0405ff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+	{"83019f0203ff820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+	{"9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff", ints(1, 25)},
+	{"bf61610161629f0203ffff", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+	{"826161bf61626163ff", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}},
+	{"bf6346756ef563416d7421ff", map[interface{}]interface{}{"Fun": true, "Amt": int64(-2)}},
+}
+
+func TestDecodeRFC8949Examples(t *testing.T) {
+	for _, example := range rfc8949Examples {
+		var value interface{}
+		assertDecoded(t, example.document, &value, example.expected)
+	}
+
+	// Zero compares equal to negative zero, and NaN to nothing
+	var f float64
+	assertDecoded(t, "f98000", &f, 0.0)
+	if !math.Signbit(f) {
+		t.Errorf("Expected -0.0 but got %v", f)
+	}
+	for _, document := range []string{"f97e00", "fa7fc00000", "fb7ff8000000000000"} {
+		if err := Decode(bytes.NewReader(decodeHex(t, document)), &f); err != nil || !math.IsNaN(f) {
+			t.Errorf("Decoding %v: Expected NaN but got %v (%v)", document, f, err)
+		}
+	}
+
+	// Outside of the int64 and uint64 ranges, or not supported
+	var value interface{}
+	for _, document := range []string{"3bffffffffffffffff", "f0", "f8ff"} {
+		assertDecodeFails(t, document, &value)
+	}
+}
+
+// Encoding has one choice of representation per value, so only the examples
+// whose representation is the one this package chooses are checked.
+func TestEncodeRFC8949Examples(t *testing.T) {
+	for _, example := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{0, "00"},
+		{10, "0a"},
+		{23, "17"},
+		{24, "1818"},
+		{100, "1864"},
+		{1000, "1903e8"},
+		{1000000, "1a000f4240"},
+		{1000000000000, "1b000000e8d4a51000"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-100, "3863"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{100000.0, "fa47c35000"},
+		{3.4028234663852886e+38, "fa7f7fffff"},
+		{1.0e+300, "fb7e37e43c8800759c"},
+		{-4.1, "fbc010666666666666"},
+		{math.Inf(1), "fa7f800000"},
+		{math.Inf(-1), "faff800000"},
+		{math.NaN(), "f97e00"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+		{uri("http://www.example.com"), "d82076687474703a2f2f7777772e6578616d706c652e636f6d"},
+		{[]byte{}, "40"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{"\"\\", "62225c"},
+		{"ü", "62c3bc"},
+		{"水", "63e6b0b4"},
+		{"\U00010151", "64f0908591"},
+		{[]int{}, "9fff"},
+		{ints(1, 25), "9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff"},
+		{FunAmt{Fun: true, Amt: -2}, "bf6346756ef563416d7421ff"},
+	} {
+		assertEncoded(t, example.value, nil, example.expected)
+	}
+
+	when := time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)
+	assertEncoded(t, when, &EncoderOptions{TimeFormat: TimeEpoch}, "c11a514b67b0")
+	assertEncoded(t, when.Add(500*time.Millisecond), &EncoderOptions{TimeFormat: TimeEpoch}, "c1fb41d452d9ec200000")
+
+	// Not from the RFC: Nil slices are null rather than empty
+	assertEncoded(t, []byte(nil), nil, "f6")
+}
+
+func TestDecodeChunkedStrings(t *testing.T) {
+	var data []byte
+	assertDecoded(t, "5f ff", &data, []byte{})
+	assertDecoded(t, "5f 40 40 ff", &data, []byte{})
+	assertDecoded(t, "5f 4101 40 420203 ff", &data, []byte{1, 2, 3})
+	var s string
+	assertDecoded(t, "7f ff", &s, "")
+	assertDecoded(t, "7f 62c3bc 60 6161 ff", &s, "üa")
+
+	var value interface{}
+	for _, document := range []string{
+		// Chunks of the wrong type, or indefinite-length chunks
+		"5f 6161 ff", "7f 4161 ff", "5f 01 ff", "5f 5f ff ff", "7f 7f ff ff",
+		// A character split across chunks
+		"7f 61c3 61bc ff",
+		// No break
+		"5f 4101", "7f",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+}
+
+func TestDecodeTags(t *testing.T) {
+	var value interface{}
+	// Self-described CBOR, and tags that are ignored, around each kind of item
+	assertDecoded(t, "d9d9f7 01", &value, int64(1))
+	assertDecoded(t, "d9d9f7 d9d9f7 6161", &value, "a")
+	assertDecoded(t, "c2 4101", &value, []byte{1})
+	assertDecoded(t, "da00010000 82 01 d822 02", &value, []interface{}{int64(1), int64(2)})
+	assertDecoded(t, "d9d9f7 c074323031332d30332d32315432303a30343a30305a", &value,
+		time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC))
+
+	// The contents of tag 0 may be chunked, and tag 1 may be negative
+	assertDecoded(t, "c0 7f 6a323031332d30332d3231 6a5432303a30343a30305a ff", &value,
+		time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC))
+	assertDecoded(t, "c1 20", &value, time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC))
+
+	// Shared values (tags 28 and 29) are numbered in the order that they begin
+	assertDecoded(t, "83 d81c 6161 d81c 6162 d81d 00", &value, []interface{}{"a", "b", "a"})
+
+	for _, document := range []string{
+		"c0 01", "c0 6161", "c1 6161", "c1 fb7ff0000000000000", "d820 01", "d820 6125",
+		"d81d 00", "d81d 6161", "d81c d81d 01", "d81c", "d9d9f7",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+}
+
+func TestEncodeReferences(t *testing.T) {
+	shared := &CBORNode{Value: 1}
+	assertEncoded(t, []*CBORNode{shared, shared}, nil,
+		"9f d81c bf6556616c756501644e657874f6ff d81d00 ff")
+}
+
+func TestEncodeFail(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, 1+2i, nil); err == nil {
+		t.Errorf("Expected encoding a complex number to fail")
+	}
+
+	encoder := NewEncoder(&bytes.Buffer{}, nil)
+	encoder.OnMapBegin()
+	encoder.OnInt(1)
+	if err := encoder.OnContainerEnd(); err == nil {
+		t.Errorf("Expected ending a map after a key to fail")
+	}
+
+	encoder = NewEncoder(&bytes.Buffer{}, nil)
+	if err := encoder.OnReference(uint64(0)); err == nil {
+		t.Errorf("Expected a reference to an unknown marker to fail")
+	}
+}
+
+func TestDecodeTyped(t *testing.T) {
+	var i int
+	assertDecoded(t, "3903e7", &i, -1000)
+	var i64 int64
+	assertDecoded(t, "3b7fffffffffffffff", &i64, int64(math.MinInt64))
+	var p *int
+	assertDecoded(t, "f6", &p, (*int)(nil))
+	assertDecoded(t, "f7", &p, (*int)(nil))
+	var ints []int
+	assertDecoded(t, "83010203", &ints, []int{1, 2, 3})
+	var node CBORNode
+	assertDecoded(t, "a2 6556616c7565 01 644e657874 f6", &node, CBORNode{Value: 1})
+
+	var i8 int8
+	assertDecodeFails(t, "190100", &i8)
+	assertDecodeFails(t, "fa3fc00000", &i8)
+	assertDecodeFails(t, "f6", &i8)
+}
+
+func TestRoundtrip(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", 3600))
+	expected := CBORStruct{
+		Name:  "outer",
+		Count: math.MinInt64,
+		Big:   math.MaxUint64,
+		Ratio: 0.1,
+		Data:  []byte{1, 2, 3},
+		When:  when,
+		Where: uri("http://example.com/a?b=c"),
+		Inner: &CBORStruct{Name: "inner", List: []int{}},
+		List:  []int{1, 2, 3},
+		Map:   map[int]string{-1: "v"},
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, expected, nil); err != nil {
+		t.Fatal(err)
+	}
+	var actual CBORStruct
+	if err := Decode(buffer, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !actual.When.Equal(when) {
+		t.Errorf("Expected time %v but got %v", when, actual.When)
+	}
+	actual.When = when
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, actual)
+	}
+}
+
+func TestRoundtripCycles(t *testing.T) {
+	v := &CBORNode{Value: 1, Next: &CBORNode{Value: 2}}
+	v.Next.Next = v
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, v, nil); err != nil {
+		t.Fatal(err)
+	}
+	var rebuilt *CBORNode
+	if err := Decode(buffer, &rebuilt); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Value != 1 || rebuilt.Next.Value != 2 || rebuilt.Next.Next != rebuilt {
+		t.Errorf("Expected a two element cycle")
+	}
+
+	s := SelfSlice{nil, nil}
+	s[1] = &s
+	buffer.Reset()
+	if err := Encode(buffer, s, nil); err != nil {
+		t.Fatal(err)
+	}
+	var rebuiltSlice SelfSlice
+	if err := Decode(buffer, &rebuiltSlice); err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuiltSlice) != 2 || rebuiltSlice[0] != nil || (*rebuiltSlice[1])[1] != rebuiltSlice[1] {
+		t.Errorf("Expected a slice containing a pointer to itself but got %v", rebuiltSlice)
+	}
+}
+
+// An array marked with tag 28 that refers to itself with tag 29
+func TestDecodeSelfReferencingArray(t *testing.T) {
+	var value []interface{}
+	if err := Decode(bytes.NewReader(decodeHex(t, "d81c 82 01 d81d00")), &value); err != nil {
+		t.Fatal(err)
+	}
+	self, ok := value[1].([]interface{})
+	if len(value) != 2 || !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(value).Pointer() {
+		t.Errorf("Expected an array containing itself but got %v", value)
+	}
+}
+
+func TestDecodeFail(t *testing.T) {
+	var value interface{}
+	for _, document := range []string{
+		"", "18", "19 01", "1c", "44 0102", "62 ff00", "82 01", "9f 01",
+		"a1 01", "bf 01 ff", "ff", "f8 10", "01 02",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+
+	err := Decode(bytes.NewReader(decodeHex(t, "82 01 1c")), &value)
+	if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Offset != 3 {
+		t.Errorf("Expected a syntax error at offset 3 but got %v", err)
+	}
+
+	deep := strings.Repeat("81", maxDepth+1) + "01"
+	assertDecodeFails(t, deep, &value)
+	deep = strings.Repeat("c2", maxDepth+1) + "01"
+	assertDecodeFails(t, deep, &value)
+}
+
+func TestDecoderSequence(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	encoder := NewEncoder(buffer, nil)
+	for _, value := range []interface{}{1, []int{2}, "x"} {
+		if err := reconstruct.IterateObject(value, false, encoder); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := NewDecoder(buffer, &reconstruct.BuilderOptions{UseInt: true})
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	expected := []interface{}{1, []interface{}{2}, "x"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values)
+	}
+}
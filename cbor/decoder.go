@@ -0,0 +1,560 @@
+package cbor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// Arrays, maps and tags nested deeper than this are rejected, since each level
+// of nesting uses stack.
+const maxDepth = 10000
+
+// SyntaxError describes malformed CBOR.
+type SyntaxError struct {
+	// The byte offset where the error was detected
+	Offset int64
+	Msg    string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("CBOR syntax error at offset %v: %v", this.Offset, this.Msg)
+}
+
+// Decode reads a single CBOR data item from reader into target, which must be
+// a non-nil pointer. Anything after the data item is an error.
+func Decode(reader io.Reader, target interface{}) error {
+	decoder := NewDecoder(reader, nil)
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if _, err := decoder.readByte(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return decoder.syntaxError("Unexpected data after top-level item")
+	}
+	return nil
+}
+
+// Decoder reads a stream of CBOR data items, generating events as it goes
+// rather than building an intermediate representation.
+//
+// Unsigned integers are passed to OnInt if they fit in an int64, and to OnUint
+// otherwise. Negative integers that don't fit in an int64 cause an error.
+type Decoder struct {
+	reader      *bufio.Reader
+	offset      int64
+	options     reconstruct.BuilderOptions
+	callbacks   reconstruct.ObjectIteratorCallbacks
+	depth       int
+	sharedCount uint64
+}
+
+// NewDecoder creates a decoder that reads from reader, and builds values using
+// options (nil means use defaults).
+func NewDecoder(reader io.Reader, options *reconstruct.BuilderOptions) *Decoder {
+	this := &Decoder{
+		reader: bufio.NewReader(reader),
+	}
+	if options != nil {
+		this.options = *options
+	}
+	return this
+}
+
+// Decode reads the next CBOR data item into target, which must be a non-nil
+// pointer. Returns io.EOF if there are no more data items.
+func (this *Decoder) Decode(target interface{}) error {
+	dst := reflect.ValueOf(target)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("Cannot decode into %v: Target must be a non-nil pointer", reflect.TypeOf(target))
+	}
+
+	b, err := this.readByte()
+	if err != nil {
+		return err
+	}
+
+	builder := reconstruct.NewBuilderWithOptions(target, &this.options)
+	this.begin(builder)
+	if err := this.decodeItem(b); err != nil {
+		return err
+	}
+	return builder.StoreBuiltObject(target)
+}
+
+// DecodeEvents reads the next CBOR data item, passing its events to callbacks.
+// Returns io.EOF if there are no more data items.
+func (this *Decoder) DecodeEvents(callbacks reconstruct.ObjectIteratorCallbacks) error {
+	b, err := this.readByte()
+	if err != nil {
+		return err
+	}
+	this.begin(callbacks)
+	return this.decodeItem(b)
+}
+
+func (this *Decoder) begin(callbacks reconstruct.ObjectIteratorCallbacks) {
+	this.callbacks = callbacks
+	this.depth = 0
+	this.sharedCount = 0
+}
+
+func (this *Decoder) syntaxError(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Offset: this.offset,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (this *Decoder) readByte() (byte, error) {
+	b, err := this.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	this.offset++
+	return b, nil
+}
+
+// Read a byte that must exist because we're partway through a data item.
+func (this *Decoder) readByteInItem() (byte, error) {
+	b, err := this.readByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (this *Decoder) readFull(buffer []byte) error {
+	n, err := io.ReadFull(this.reader, buffer)
+	this.offset += int64(n)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Read the argument that follows an initial byte's additional info.
+func (this *Decoder) readArgument(info byte) (uint64, error) {
+	if info < infoUint8 {
+		return uint64(info), nil
+	}
+	var buffer [8]byte
+	switch info {
+	case infoUint8:
+		b, err := this.readByteInItem()
+		return uint64(b), err
+	case infoUint16:
+		err := this.readFull(buffer[:2])
+		return uint64(binary.BigEndian.Uint16(buffer[:2])), err
+	case infoUint32:
+		err := this.readFull(buffer[:4])
+		return uint64(binary.BigEndian.Uint32(buffer[:4])), err
+	case infoUint64:
+		err := this.readFull(buffer[:8])
+		return binary.BigEndian.Uint64(buffer[:8]), err
+	default:
+		return 0, this.syntaxError("Invalid additional info %v", info)
+	}
+}
+
+// Returns true and consumes the break code if it's next.
+func (this *Decoder) isAtBreak() (bool, error) {
+	next, err := this.reader.Peek(1)
+	if err == io.EOF {
+		return false, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	if next[0] != codeBreak {
+		return false, nil
+	}
+	this.readByte()
+	return true, nil
+}
+
+func (this *Decoder) enter() error {
+	this.depth++
+	if this.depth > maxDepth {
+		return this.syntaxError("Exceeded maximum nesting depth of %v", maxDepth)
+	}
+	return nil
+}
+
+func (this *Decoder) readNextItem() error {
+	b, err := this.readByteInItem()
+	if err != nil {
+		return err
+	}
+	return this.decodeItem(b)
+}
+
+func (this *Decoder) decodeItem(initial byte) error {
+	major := initial & majorTypeMask
+	info := initial & infoMask
+
+	if info == infoIndefinite {
+		switch major {
+		case majorBytes:
+			value, err := this.readChunks(majorBytes)
+			if err != nil {
+				return err
+			}
+			return this.callbacks.OnBytes(value)
+		case majorText:
+			value, err := this.readChunks(majorText)
+			if err != nil {
+				return err
+			}
+			return this.onText(value)
+		case majorArray:
+			return this.decodeArray(0, true)
+		case majorMap:
+			return this.decodeMap(0, true)
+		case majorSimple:
+			return this.syntaxError("Unexpected break")
+		default:
+			return this.syntaxError("Invalid indefinite length for major type %v", major>>5)
+		}
+	}
+
+	if major == majorSimple {
+		return this.decodeSimple(info)
+	}
+
+	argument, err := this.readArgument(info)
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case majorUint:
+		if argument <= math.MaxInt64 {
+			return this.callbacks.OnInt(int64(argument))
+		}
+		return this.callbacks.OnUint(argument)
+	case majorNegInt:
+		if argument > math.MaxInt64 {
+			return this.syntaxError("Negative integer -1-%v is out of range", argument)
+		}
+		return this.callbacks.OnInt(-1 - int64(argument))
+	case majorBytes:
+		value, err := this.readData(argument)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnBytes(value)
+	case majorText:
+		value, err := this.readData(argument)
+		if err != nil {
+			return err
+		}
+		return this.onText(value)
+	case majorArray:
+		return this.decodeArray(argument, false)
+	case majorMap:
+		return this.decodeMap(argument, false)
+	default:
+		return this.decodeTag(argument)
+	}
+}
+
+func (this *Decoder) decodeSimple(info byte) error {
+	var buffer [8]byte
+	switch info {
+	case simpleFalse:
+		return this.callbacks.OnBool(false)
+	case simpleTrue:
+		return this.callbacks.OnBool(true)
+	case simpleNull, simpleUndefined:
+		return this.callbacks.OnNil()
+	case infoUint16:
+		if err := this.readFull(buffer[:2]); err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(float16ToFloat64(binary.BigEndian.Uint16(buffer[:2])))
+	case infoUint32:
+		if err := this.readFull(buffer[:4]); err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(buffer[:4]))))
+	case infoUint64:
+		if err := this.readFull(buffer[:8]); err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(math.Float64frombits(binary.BigEndian.Uint64(buffer[:8])))
+	default:
+		return this.syntaxError("Unsupported simple value %v", info)
+	}
+}
+
+func float16ToFloat64(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1f
+	mantissa := float64(bits & 0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 0x1f:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+0x400, exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		value = -value
+	}
+	return value
+}
+
+// Read length bytes, growing the buffer as data arrives so that a bogus
+// length can't force a huge allocation.
+func (this *Decoder) readData(length uint64) ([]byte, error) {
+	if length > math.MaxInt64 {
+		return nil, this.syntaxError("Length %v is too large", length)
+	}
+	// Start non-nil so that empty data isn't mistaken for nil
+	buffer := bytes.NewBuffer([]byte{})
+	n, err := io.CopyN(buffer, this.reader, int64(length))
+	this.offset += n
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buffer.Bytes(), err
+}
+
+// Read the chunks of an indefinite-length string.
+func (this *Decoder) readChunks(major byte) ([]byte, error) {
+	value := []byte{}
+	for {
+		b, err := this.readByteInItem()
+		if err != nil {
+			return nil, err
+		}
+		if b == codeBreak {
+			return value, nil
+		}
+		if b&majorTypeMask != major || b&infoMask == infoIndefinite {
+			return nil, this.syntaxError("Invalid chunk type %02x in indefinite-length string", b)
+		}
+		length, err := this.readArgument(b & infoMask)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := this.readData(length)
+		if err != nil {
+			return nil, err
+		}
+		// A character can't be split across chunks of a text string
+		if major == majorText && !utf8.Valid(chunk) {
+			return nil, this.syntaxError("Text string chunk contains invalid UTF-8")
+		}
+		value = append(value, chunk...)
+	}
+}
+
+func (this *Decoder) onText(value []byte) error {
+	if !utf8.Valid(value) {
+		return this.syntaxError("Text string contains invalid UTF-8")
+	}
+	return this.callbacks.OnString(string(value))
+}
+
+// Read an item that must be a text string, such as the contents of a tag.
+func (this *Decoder) readText(context string) (string, error) {
+	b, err := this.readByteInItem()
+	if err != nil {
+		return "", err
+	}
+	if b&majorTypeMask != majorText {
+		return "", this.syntaxError("%v must contain a text string", context)
+	}
+	var value []byte
+	if b&infoMask == infoIndefinite {
+		value, err = this.readChunks(majorText)
+	} else {
+		var length uint64
+		if length, err = this.readArgument(b & infoMask); err == nil {
+			value, err = this.readData(length)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(value) {
+		return "", this.syntaxError("Text string contains invalid UTF-8")
+	}
+	return string(value), nil
+}
+
+func (this *Decoder) decodeArray(length uint64, isIndefinite bool) error {
+	if err := this.enter(); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnListBegin(); err != nil {
+		return err
+	}
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite {
+			if isBreak, err := this.isAtBreak(); isBreak || err != nil {
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+		if err := this.readNextItem(); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeMap(length uint64, isIndefinite bool) error {
+	if err := this.enter(); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnMapBegin(); err != nil {
+		return err
+	}
+	for i := uint64(0); isIndefinite || i < length; i++ {
+		if isIndefinite {
+			if isBreak, err := this.isAtBreak(); isBreak || err != nil {
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+		if err := this.readNextItem(); err != nil {
+			return err
+		}
+		if err := this.readNextItem(); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeTag(tag uint64) error {
+	switch tag {
+	case tagDateTimeString:
+		str, err := this.readText("Tag 0 (date/time string)")
+		if err != nil {
+			return err
+		}
+		value, err := time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return this.syntaxError("Invalid date/time string %q", str)
+		}
+		return this.callbacks.OnTime(value)
+	case tagEpochDateTime:
+		return this.decodeEpochTime()
+	case tagURI:
+		str, err := this.readText("Tag 32 (URI)")
+		if err != nil {
+			return err
+		}
+		value, err := url.Parse(str)
+		if err != nil {
+			return this.syntaxError("Invalid URI %q: %v", str, err)
+		}
+		return this.callbacks.OnURI(value)
+	case tagSharedRef:
+		b, err := this.readByteInItem()
+		if err != nil {
+			return err
+		}
+		if b&majorTypeMask != majorUint {
+			return this.syntaxError("Tag 29 (shared value) must contain an unsigned integer")
+		}
+		index, err := this.readArgument(b & infoMask)
+		if err != nil {
+			return err
+		}
+		if index >= this.sharedCount {
+			return this.syntaxError("Tag 29 refers to nonexistent shared value %v", index)
+		}
+		return this.callbacks.OnReference(index)
+	}
+
+	if err := this.enter(); err != nil {
+		return err
+	}
+	if tag == tagShareable {
+		if err := this.callbacks.OnMarker(this.sharedCount); err != nil {
+			return err
+		}
+		this.sharedCount++
+	}
+	if err := this.readNextItem(); err != nil {
+		return err
+	}
+	this.depth--
+	return nil
+}
+
+func (this *Decoder) decodeEpochTime() error {
+	b, err := this.readByteInItem()
+	if err != nil {
+		return err
+	}
+	info := b & infoMask
+	var buffer [8]byte
+	var seconds float64
+	switch {
+	case b&majorTypeMask == majorUint || b&majorTypeMask == majorNegInt:
+		argument, err := this.readArgument(info)
+		if err != nil {
+			return err
+		}
+		if argument > math.MaxInt64 {
+			return this.syntaxError("Epoch time is out of range")
+		}
+		value := int64(argument)
+		if b&majorTypeMask == majorNegInt {
+			value = -1 - value
+		}
+		return this.callbacks.OnTime(time.Unix(value, 0).UTC())
+	case b == codeFloat16:
+		if err := this.readFull(buffer[:2]); err != nil {
+			return err
+		}
+		seconds = float16ToFloat64(binary.BigEndian.Uint16(buffer[:2]))
+	case b == codeFloat32:
+		if err := this.readFull(buffer[:4]); err != nil {
+			return err
+		}
+		seconds = float64(math.Float32frombits(binary.BigEndian.Uint32(buffer[:4])))
+	case b == codeFloat64:
+		if err := this.readFull(buffer[:8]); err != nil {
+			return err
+		}
+		seconds = math.Float64frombits(binary.BigEndian.Uint64(buffer[:8]))
+	default:
+		return this.syntaxError("Tag 1 (epoch date/time) must contain a number")
+	}
+
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return this.syntaxError("Epoch time %v is not a finite number", seconds)
+	}
+	whole, fraction := math.Modf(seconds)
+	value := time.Unix(int64(whole), int64(math.Round(fraction*1e9))).UTC()
+	return this.callbacks.OnTime(value)
+}
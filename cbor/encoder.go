@@ -0,0 +1,387 @@
+// Package cbor encodes and decodes CBOR (RFC 8949) using reconstruct's
+// iterators and builders.
+//
+// Events map to CBOR as follows:
+//
+//	Nil                  null (simple value 22)
+//	Bool                 false or true (simple values 20 and 21)
+//	Int, Uint            unsigned integer (major type 0) or negative integer (major type 1)
+//	Float                float32 if that's lossless, otherwise float64
+//	String               text string (major type 3)
+//	Bytes                byte string (major type 2), or null if nil
+//	URI                  tag 32 (URI) containing a text string
+//	Time                 tag 0 (RFC 3339 text string) or tag 1 (epoch-based), see TimeFormat
+//	List                 indefinite-length array
+//	Map                  indefinite-length map
+//	Marker               tag 28 (shareable value) preceding the marked value
+//	Reference            tag 29 (shared value) containing the index of the marked value
+//
+// Complex numbers have no CBOR representation, and cause encoding to fail.
+//
+// The decoder also accepts definite-length strings, arrays and maps, indefinite
+// length strings, half-precision floats, and undefined (decoded as Nil). The
+// contents of other tags are decoded as if the tag wasn't there.
+package cbor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+const (
+	majorUint     = 0 << 5
+	majorNegInt   = 1 << 5
+	majorBytes    = 2 << 5
+	majorText     = 3 << 5
+	majorArray    = 4 << 5
+	majorMap      = 5 << 5
+	majorTag      = 6 << 5
+	majorSimple   = 7 << 5
+	majorTypeMask = 7 << 5
+
+	infoUint8      = 24
+	infoUint16     = 25
+	infoUint32     = 26
+	infoUint64     = 27
+	infoIndefinite = 31
+	infoMask       = 0x1f
+
+	simpleFalse     = 20
+	simpleTrue      = 21
+	simpleNull      = 22
+	simpleUndefined = 23
+
+	codeFalse   = majorSimple | simpleFalse
+	codeTrue    = majorSimple | simpleTrue
+	codeNull    = majorSimple | simpleNull
+	codeFloat16 = majorSimple | infoUint16
+	codeFloat32 = majorSimple | infoUint32
+	codeFloat64 = majorSimple | infoUint64
+	codeBreak   = majorSimple | infoIndefinite
+
+	tagDateTimeString = 0
+	tagEpochDateTime  = 1
+	tagShareable      = 28
+	tagSharedRef      = 29
+	tagURI            = 32
+)
+
+// TimeFormat determines which tag the encoder uses for times.
+type TimeFormat int
+
+const (
+	// Tag 0: An RFC 3339 string, which preserves the time zone offset. This is
+	// the default.
+	TimeRFC3339 TimeFormat = iota
+	// Tag 1: Seconds since the Unix epoch in UTC, as an integer if there's no
+	// fractional part, or otherwise a float64 (which loses precision beyond
+	// microseconds).
+	TimeEpoch
+)
+
+type EncoderOptions struct {
+	// How to encode times.
+	TimeFormat TimeFormat
+}
+
+func (this *EncoderOptions) withDefaultsApplied() EncoderOptions {
+	var options EncoderOptions
+	if this != nil {
+		options = *this
+	}
+	return options
+}
+
+// Encode writes value to writer as CBOR. Pointers to the same data are encoded
+// once and then referred to, so values containing cycles can be encoded.
+func Encode(writer io.Writer, value interface{}, options *EncoderOptions) error {
+	iterOptions := &reconstruct.IteratorOptions{UseReferences: true}
+	return reconstruct.IterateObjectWithOptions(value, iterOptions, NewEncoder(writer, options))
+}
+
+// How much output to buffer before writing to the underlying writer
+const flushThreshold = 4096
+
+type encoderContainer struct {
+	isMap bool
+	count int
+}
+
+// Encoder implements ObjectIteratorCallbacks, writing each top-level object as
+// a CBOR data item.
+type Encoder struct {
+	writer        io.Writer
+	options       EncoderOptions
+	buffer        []byte
+	containers    []encoderContainer
+	sharedIndexes map[interface{}]uint64
+	err           error
+}
+
+// NewEncoder creates an encoder that writes to writer, configured by options
+// (nil means use defaults).
+func NewEncoder(writer io.Writer, options *EncoderOptions) *Encoder {
+	return &Encoder{
+		writer:  writer,
+		options: options.withDefaultsApplied(),
+	}
+}
+
+func (this *Encoder) fail(format string, args ...interface{}) error {
+	this.err = fmt.Errorf(format, args...)
+	return this.err
+}
+
+func (this *Encoder) flush() error {
+	if _, err := this.writer.Write(this.buffer); err != nil {
+		this.err = err
+		return err
+	}
+	this.buffer = this.buffer[:0]
+	return nil
+}
+
+func appendHead(buffer []byte, major byte, argument uint64) []byte {
+	switch {
+	case argument < infoUint8:
+		return append(buffer, major|byte(argument))
+	case argument <= math.MaxUint8:
+		return append(buffer, major|infoUint8, byte(argument))
+	case argument <= math.MaxUint16:
+		buffer = append(buffer, major|infoUint16, 0, 0)
+		binary.BigEndian.PutUint16(buffer[len(buffer)-2:], uint16(argument))
+		return buffer
+	case argument <= math.MaxUint32:
+		buffer = append(buffer, major|infoUint32, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buffer[len(buffer)-4:], uint32(argument))
+		return buffer
+	default:
+		buffer = append(buffer, major|infoUint64, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buffer[len(buffer)-8:], argument)
+		return buffer
+	}
+}
+
+func appendInt(buffer []byte, value int64) []byte {
+	if value < 0 {
+		return appendHead(buffer, majorNegInt, uint64(^value))
+	}
+	return appendHead(buffer, majorUint, uint64(value))
+}
+
+func appendFloat(buffer []byte, value float64) []byte {
+	if math.IsNaN(value) {
+		return append(buffer, codeFloat16, 0x7e, 0x00)
+	}
+	if float64(float32(value)) == value {
+		buffer = append(buffer, codeFloat32, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buffer[len(buffer)-4:], math.Float32bits(float32(value)))
+		return buffer
+	}
+	buffer = append(buffer, codeFloat64, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(buffer[len(buffer)-8:], math.Float64bits(value))
+	return buffer
+}
+
+func appendText(buffer []byte, value string) []byte {
+	buffer = appendHead(buffer, majorText, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// Check for errors before encoding a value.
+func (this *Encoder) beginValue() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) > 0 {
+		this.containers[len(this.containers)-1].count++
+	}
+	return nil
+}
+
+func (this *Encoder) endValue() error {
+	if len(this.containers) > 0 {
+		if len(this.buffer) >= flushThreshold {
+			return this.flush()
+		}
+		return nil
+	}
+	this.sharedIndexes = nil
+	return this.flush()
+}
+
+func (this *Encoder) beginContainer(isMap bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if isMap {
+		this.buffer = append(this.buffer, majorMap|infoIndefinite)
+	} else {
+		this.buffer = append(this.buffer, majorArray|infoIndefinite)
+	}
+	this.containers = append(this.containers, encoderContainer{isMap: isMap})
+	return nil
+}
+
+// -----------------------
+// ObjectIteratorCallbacks
+// -----------------------
+
+func (this *Encoder) OnNil() error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, codeNull)
+	return this.endValue()
+}
+
+func (this *Encoder) OnBool(value bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if value {
+		this.buffer = append(this.buffer, codeTrue)
+	} else {
+		this.buffer = append(this.buffer, codeFalse)
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnInt(value int64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendInt(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnUint(value uint64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendHead(this.buffer, majorUint, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnFloat(value float64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendFloat(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnComplex(value complex128) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("CBOR cannot represent complex value %v", value)
+}
+
+func (this *Encoder) OnString(value string) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendText(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnBytes(value []byte) error {
+	if value == nil {
+		return this.OnNil()
+	}
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendHead(this.buffer, majorBytes, uint64(len(value)))
+	this.buffer = append(this.buffer, value...)
+	return this.endValue()
+}
+
+func (this *Encoder) OnURI(value *url.URL) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendHead(this.buffer, majorTag, tagURI)
+	this.buffer = appendText(this.buffer, value.String())
+	return this.endValue()
+}
+
+func (this *Encoder) OnTime(value time.Time) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	switch this.options.TimeFormat {
+	case TimeEpoch:
+		this.buffer = appendHead(this.buffer, majorTag, tagEpochDateTime)
+		if value.Nanosecond() == 0 {
+			this.buffer = appendInt(this.buffer, value.Unix())
+		} else {
+			this.buffer = appendFloat(this.buffer, float64(value.Unix())+float64(value.Nanosecond())/1e9)
+		}
+	default:
+		this.buffer = appendHead(this.buffer, majorTag, tagDateTimeString)
+		this.buffer = appendText(this.buffer, value.Format(time.RFC3339Nano))
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnListBegin() error {
+	return this.beginContainer(false)
+}
+
+func (this *Encoder) OnMapBegin() error {
+	return this.beginContainer(true)
+}
+
+func (this *Encoder) OnContainerEnd() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) == 0 {
+		return this.fail("Container end without a matching container begin")
+	}
+	container := this.containers[len(this.containers)-1]
+	if container.isMap && container.count%2 != 0 {
+		return this.fail("CBOR map ended with a key but no value")
+	}
+	this.containers = this.containers[:len(this.containers)-1]
+	this.buffer = append(this.buffer, codeBreak)
+	return this.endValue()
+}
+
+// Marked values are numbered in the order that they're encoded, which is how
+// the decoder numbers them when it encounters tag 28.
+func (this *Encoder) OnMarker(id interface{}) error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.sharedIndexes == nil {
+		this.sharedIndexes = make(map[interface{}]uint64)
+	}
+	if _, exists := this.sharedIndexes[id]; exists {
+		return this.fail("Marker ID %v has already been used", id)
+	}
+	this.sharedIndexes[id] = uint64(len(this.sharedIndexes))
+	this.buffer = appendHead(this.buffer, majorTag, tagShareable)
+	return nil
+}
+
+func (this *Encoder) OnReference(id interface{}) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	index, ok := this.sharedIndexes[id]
+	if !ok {
+		return this.fail("Reference to unknown marker ID %v", id)
+	}
+	this.buffer = appendHead(this.buffer, majorTag, tagSharedRef)
+	this.buffer = appendHead(this.buffer, majorUint, index)
+	return this.endValue()
+}
@@ -1,11 +1,10 @@
 package reconstruct
 
 import (
+	"encoding"
 	"net/url"
 	"reflect"
 	"time"
-	"unicode"
-	"unicode/utf8"
 )
 
 var (
@@ -13,9 +12,42 @@ var (
 	urlType   = reflect.TypeOf(url.URL{})
 	pURLType  = reflect.TypeOf((*url.URL)(nil))
 	bytesType = reflect.TypeOf([]uint8{})
+
+	selfIteratorType = reflect.TypeOf((*SelfIterator)(nil)).Elem()
+	selfBuilderType  = reflect.TypeOf((*SelfBuilder)(nil)).Elem()
+
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
 )
 
-func isFieldExported(name string) bool {
-	rune, _ := utf8.DecodeRuneInString(name)
-	return unicode.IsUpper(rune)
+// Reports whether values of type t (or pointers to them) implement the
+// marshaler interface iface. Time and URL have their own events, and pointers
+// and interfaces are handled by their own iterators and builders (which
+// eventually get to the underlying type).
+func isMarshalerType(t reflect.Type, iface reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return false
+	}
+	if t == timeType || t == urlType {
+		return false
+	}
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+// Get v as an interface that implements iface, which may require taking the
+// address of v (or of a copy of v).
+func marshalerInterface(v reflect.Value, iface reflect.Type) interface{} {
+	if v.Type().Implements(iface) {
+		return v.Interface()
+	}
+	if v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	vCopy := reflect.New(v.Type())
+	vCopy.Elem().Set(v)
+	return vCopy.Interface()
 }
@@ -0,0 +1,377 @@
+package cte
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// Expected documents are written without their header or trailing newline
+func assertEncoded(t *testing.T, value interface{}, options *EncoderOptions, expected string) {
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, value, options); err != nil {
+		t.Errorf("Encoding %v: %v", value, err)
+		return
+	}
+	expected = "c1\n" + expected + "\n"
+	if actual := buffer.String(); actual != expected {
+		t.Errorf("Encoding %v: Expected %q but got %q", value, expected, actual)
+	}
+}
+
+// Documents include their header, so that what precedes it can be tested
+func assertDecoded(t *testing.T, document string, target interface{}, expected interface{}) {
+	if err := Decode(strings.NewReader(document), target); err != nil {
+		t.Errorf("Decoding %q: %v", document, err)
+		return
+	}
+	actual := reflect.ValueOf(target).Elem().Interface()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoding %q: Expected %#v but got %#v", document, expected, actual)
+	}
+}
+
+func assertDecodeFails(t *testing.T, document string, target interface{}) {
+	if err := Decode(strings.NewReader(document), target); err == nil {
+		t.Errorf("Expected decoding %q to fail", document)
+	}
+}
+
+type CTEStruct struct {
+	Name  string
+	Count int64
+	Big   uint64
+	Ratio float64
+	Data  []byte
+	When  time.Time
+	Where *url.URL
+	Inner *CTEStruct
+	List  []int
+	Map   map[int]string
+}
+
+type CTENode struct {
+	Value int
+	Next  *CTENode
+}
+
+type SelfSlice []*SelfSlice
+
+func TestEncode(t *testing.T) {
+	uri, _ := url.Parse("http://a.b")
+	for _, example := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{-100, "-100"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		// Floats always have a fraction or exponent, so they stay floats
+		{100.0, "100.0"},
+		{1.5, "1.5"},
+		{1e100, "1e+100"},
+		{math.Inf(-1), "-inf"},
+		{math.NaN(), "nan"},
+		{"a\"b\\c\n\r\t\x01\x7fé", `"a\"b\\c\n\r\t\u0001\u007fé"`},
+		{"\xff", `"�"`},
+		{[]byte{1, 0xab}, "@u8x[01 ab]"},
+		{[]byte{}, "@u8x[]"},
+		{[]byte(nil), "null"},
+		{uri, `@"http://a.b"`},
+		{time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC), "2020-01-02/03:04:05.6"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("", -5*3600)), "2020-01-02/03:04:05-05:00"},
+		{[]int{}, "[]"},
+		{[]interface{}{1, []int{2, 3}, map[int]int{}}, "[1 [2 3] {}]"},
+		{map[string]int{"a": 1}, `{"a"=1}`},
+		{CTENode{Value: 1}, `{"Value"=1 "Next"=null}`},
+	} {
+		assertEncoded(t, example.value, nil, example.expected)
+	}
+}
+
+func TestEncodeIndented(t *testing.T) {
+	tab := &EncoderOptions{Indent: "\t"}
+	assertEncoded(t, 1, tab, "1")
+	assertEncoded(t, []int{}, tab, "[]")
+	assertEncoded(t, map[string]int{}, tab, "{}")
+	assertEncoded(t, map[string][]interface{}{"a": {1, []int{}, map[string]int{"b": 2}}}, tab, strings.Join([]string{
+		`{`,
+		`	"a" = [`,
+		`		1`,
+		`		[]`,
+		`		{`,
+		`			"b" = 2`,
+		`		}`,
+		`	]`,
+		`}`,
+	}, "\n"))
+}
+
+func TestDecode(t *testing.T) {
+	for _, example := range []struct {
+		document string
+		expected interface{}
+	}{
+		{"c1 null", nil},
+		{"c1 false", false},
+		{"c1 0x1f", int64(31)},
+		{"c1 -0X1F", int64(-31)},
+		{"c1 -9223372036854775808", int64(math.MinInt64)},
+		{"c1 18446744073709551615", uint64(math.MaxUint64)},
+		{"c1 -1.5e2", -150.0},
+		{"c1 inf", math.Inf(1)},
+		{`c1 "\"\\\n\r\té"`, "\"\\\n\r\té"},
+		{`c1 "\ud800"`, "�"},
+		{"c1 \"\xff\"", "�"},
+		{"c1 @u8x[01abFF 02]", []byte{1, 0xab, 0xff, 2}},
+		{"c1 @u8x[ ]", []byte{}},
+		{"c1 2020-01-02/03:04:05.6", time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC)},
+		{"c1\r\n[\t1 [2\n3]{}]\n", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, map[interface{}]interface{}{}}},
+		{`c1 {"a" = 1 1=true "b"=[]}`, map[interface{}]interface{}{"a": int64(1), int64(1): true, "b": []interface{}{}}},
+	} {
+		var value interface{}
+		assertDecoded(t, example.document, &value, example.expected)
+	}
+
+	var uri *url.URL
+	expectedURI, _ := url.Parse("http://a.b")
+	assertDecoded(t, `c1 @"http://a.b"`, &uri, expectedURI)
+
+	var when time.Time
+	if err := Decode(strings.NewReader("c1 2020-01-02/03:04:05-05:00"), &when); err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2020, 1, 2, 8, 4, 5, 0, time.UTC)
+	if _, offset := when.Zone(); !when.Equal(expected) || offset != -5*3600 {
+		t.Errorf("Expected %v at offset -05:00 but got %v", expected, when)
+	}
+
+	var f float64
+	if err := Decode(strings.NewReader("c1 nan"), &f); err != nil || !math.IsNaN(f) {
+		t.Errorf("Expected nan but got %v (%v)", f, err)
+	}
+	var p *int
+	assertDecoded(t, "c1 null", &p, (*int)(nil))
+	var i int
+	assertDecodeFails(t, "c1 null", &i)
+}
+
+// Comments can go anywhere that whitespace can, including directly after a
+// token.
+func TestDecodeComments(t *testing.T) {
+	for _, example := range []struct {
+		document string
+		expected interface{}
+	}{
+		{"// before\nc1 1", int64(1)},
+		{"c1//c\n1", int64(1)},
+		{"c1 /* a /* nested */ comment */ 1", int64(1)},
+		{"c1 1//c", int64(1)},
+		{"c1 1/*c*/", int64(1)},
+		{"c1 1 // after", int64(1)},
+		{"c1 1 /**/ /* after */", int64(1)},
+		{"c1\n[1//c\n2]", []interface{}{int64(1), int64(2)}},
+		{"c1 [0x3/*c*/4.5/**/true//c\n]", []interface{}{int64(3), 4.5, true}},
+		{"c1 [2020-01-02/03:04:05//c\n2020-01-02/03:04:05/*c*/]", []interface{}{
+			time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"c1 {1/*k*/=/*v*/2//e\n}", map[interface{}]interface{}{int64(1): int64(2)}},
+		{`c1 ["a"/**/@u8x[01]/**/@"x"//c` + "\n]", []interface{}{"a", []byte{1}, &url.URL{Path: "x"}}},
+	} {
+		var value interface{}
+		assertDecoded(t, example.document, &value, example.expected)
+	}
+
+	var value interface{}
+	for _, document := range []string{
+		"c1 /* 1", "c1 /* /* */ 1", "c1 / 1", "c1 1 /", "c1 [1 /]", "c1 1 */",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+	assertDecodeFails(t, "c1 "+strings.Repeat("/*", maxDepth+1)+strings.Repeat("*/", maxDepth+1)+" 1", &value)
+}
+
+func TestMarkersAndReferences(t *testing.T) {
+	shared := &CTENode{Value: 1}
+	assertEncoded(t, []*CTENode{shared, shared}, nil, `[&0:{"Value"=1 "Next"=null} $0]`)
+	assertEncoded(t, []*CTENode{shared, shared}, &EncoderOptions{Indent: " "},
+		"[\n &0:{\n  \"Value\" = 1\n  \"Next\" = null\n }\n $0\n]")
+
+	cycle := &CTENode{Value: 1, Next: &CTENode{Value: 2}}
+	cycle.Next.Next = cycle
+	assertEncoded(t, cycle, nil, `&0:{"Value"=1 "Next"={"Value"=2 "Next"=$0}}`)
+	for _, document := range []string{
+		`c1 &0:{"Value"=1 "Next"={"Value"=2 "Next"=$0}}`,
+		"c1 &5:/*c*/{\n\"Next\" = {\"Next\"=$5//c\n\"Value\"=2}\n\"Value\" = 1\n}",
+	} {
+		var rebuilt *CTENode
+		if err := Decode(strings.NewReader(document), &rebuilt); err != nil {
+			t.Errorf("Decoding %q: %v", document, err)
+			continue
+		}
+		if rebuilt.Value != 1 || rebuilt.Next.Value != 2 || rebuilt.Next.Next != rebuilt {
+			t.Errorf("Decoding %q: Expected a two element cycle", document)
+		}
+	}
+
+	// Lists that contain themselves
+	s := SelfSlice{nil, nil}
+	s[1] = &s
+	assertEncoded(t, s, nil, "&0:[null $0]")
+	var rebuiltSlice SelfSlice
+	if err := Decode(strings.NewReader("c1 &0:[null $0]"), &rebuiltSlice); err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuiltSlice) != 2 || rebuiltSlice[0] != nil || (*rebuiltSlice[1])[1] != rebuiltSlice[1] {
+		t.Errorf("Expected a slice containing a pointer to itself but got %v", rebuiltSlice)
+	}
+
+	l := []interface{}{1}
+	l = append(l, &l)
+	assertEncoded(t, l, nil, "&0:[1 $0]")
+	var list []interface{}
+	if err := Decode(strings.NewReader("c1 &0:[1 $0]"), &list); err != nil {
+		t.Fatal(err)
+	}
+	self, ok := list[1].([]interface{})
+	if len(list) != 2 || !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(list).Pointer() {
+		t.Errorf("Expected a list containing itself but got %v", list)
+	}
+
+	var value interface{}
+	for _, document := range []string{
+		"c1 &0:$0", "c1 [$0 &0:1]", "c1 &a:1", "c1 &0 1", "c1 &0:", "c1 [&0:]", "c1 &0:&1:1", "c1 $", "c1 $x",
+		"c1 [&0:1 &0:2]",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+}
+
+func TestEncodeFail(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, 1+2i, nil); err == nil {
+		t.Errorf("Expected encoding a complex number to fail")
+	}
+
+	encoder := NewEncoder(&bytes.Buffer{}, nil)
+	encoder.OnMapBegin()
+	encoder.OnInt(1)
+	if err := encoder.OnContainerEnd(); err == nil {
+		t.Errorf("Expected ending a map after a key to fail")
+	}
+
+	encoder = NewEncoder(&bytes.Buffer{}, nil)
+	if err := encoder.OnReference(uint64(0)); err == nil {
+		t.Errorf("Expected a reference to an unknown marker to fail")
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", -5*3600))
+	where, _ := url.Parse("http://example.com/a?b=c")
+	expected := CTEStruct{
+		Name:  "outer \"quoted\"\n",
+		Count: math.MinInt64,
+		Big:   math.MaxUint64,
+		Ratio: 0.1,
+		Data:  []byte{1, 2, 3},
+		When:  when,
+		Where: where,
+		Inner: &CTEStruct{Name: "inner", List: []int{}},
+		List:  []int{1, 2, 3},
+		Map:   map[int]string{-1: "v"},
+	}
+
+	for _, options := range []*EncoderOptions{nil, {Indent: "\t"}} {
+		buffer := &bytes.Buffer{}
+		if err := Encode(buffer, expected, options); err != nil {
+			t.Fatal(err)
+		}
+		var actual CTEStruct
+		if err := Decode(buffer, &actual); err != nil {
+			t.Fatal(err)
+		}
+		if !actual.When.Equal(when) || actual.When.Format(time.RFC3339) != when.Format(time.RFC3339) {
+			t.Errorf("Expected time %v but got %v", when, actual.When)
+		}
+		actual.When = when
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %#v but got %#v", expected, actual)
+		}
+	}
+}
+
+func TestDecodeFail(t *testing.T) {
+	var value interface{}
+	for _, document := range []string{
+		"", "1", "c2 1", "c1", "c1 [1", "c1 {1}", "c1 {1=}", "c1 {1 2}", "c1 ]", "c1 \"abc", `c1 "\x"`,
+		`c1 "\u12g4"`, "c1 @u8x[1]", "c1 @u8x[0 1]", "c1 @u16x[01]", "c1 @u8x", `c1 @"%"`, "c1 abc",
+		"c1 1.2.3", "c1 0x", "c1 -18446744073709551615", "c1 1e999", "c1 2020-13-01/00:00:00", "c1 1 2",
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+
+	var i int8
+	assertDecodeFails(t, "c1 1000", &i)
+	assertDecodeFails(t, "c1 1.5", &i)
+
+	err := Decode(strings.NewReader("c1 [1 ="), &value)
+	if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Offset != 7 {
+		t.Errorf("Expected a syntax error at offset 7 but got %v", err)
+	}
+
+	assertDecodeFails(t, "c1 "+strings.Repeat("[", maxDepth+1), &value)
+}
+
+// Each document has its own header, and marker IDs start over in each one
+func TestDocumentSequence(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	encoder := NewEncoder(buffer, nil)
+	shared := &CTENode{Value: 1}
+	for _, value := range []interface{}{[]*CTENode{shared, shared}, 2, []*CTENode{shared, shared}} {
+		if err := reconstruct.IterateObject(value, true, encoder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := `[&0:{"Value"=1 "Next"=null} $0]`
+	expectedText := "c1\n" + node + "\nc1\n2\nc1\n" + node + "\n"
+	if buffer.String() != expectedText {
+		t.Errorf("Expected %q but got %q", expectedText, buffer.String())
+	}
+
+	decoder := NewDecoder(buffer, &reconstruct.BuilderOptions{UseInt: true, StringKeyedMaps: true})
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	nodeMap := map[string]interface{}{"Value": 1, "Next": nil}
+	list := []interface{}{nodeMap, nodeMap}
+	expected := []interface{}{list, 2, list}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values)
+	}
+
+	// A reference can't refer to a marker in an earlier document
+	decoder = NewDecoder(strings.NewReader("c1 &0:1 c1 $0"), nil)
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoder.Decode(&value); err == nil {
+		t.Errorf("Expected a reference to a marker in an earlier document to fail")
+	}
+}
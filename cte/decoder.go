@@ -0,0 +1,631 @@
+package cte
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// How deeply containers and block comments may nest before decoding fails.
+const maxDepth = 10000
+
+// SyntaxError describes a malformed CTE document.
+type SyntaxError struct {
+	// The byte offset where the error was detected
+	Offset int64
+	Msg    string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("CTE syntax error at offset %v: %v", this.Offset, this.Msg)
+}
+
+// Decode reads a single CTE document from reader into target, which must be a
+// non-nil pointer. Anything other than whitespace and comments after the
+// document is an error.
+func Decode(reader io.Reader, target interface{}) error {
+	decoder := NewDecoder(reader, nil)
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if b, err := decoder.skipWhitespace(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return decoder.syntaxError("Unexpected %q after the end of the document", b)
+	}
+	return nil
+}
+
+// Decoder reads a stream of CTE documents, generating events as it goes rather
+// than building an intermediate representation.
+//
+// Positive integers are passed to OnInt if they fit in an int64, and to OnUint
+// otherwise. Negative integers that don't fit in an int64 cause an error.
+type Decoder struct {
+	reader    *bufio.Reader
+	offset    int64
+	options   reconstruct.BuilderOptions
+	callbacks reconstruct.ObjectIteratorCallbacks
+	buffer    []byte
+	depth     int
+	markerIDs map[uint64]bool
+}
+
+// NewDecoder creates a decoder that reads from reader, and builds values using
+// options (nil means use defaults).
+func NewDecoder(reader io.Reader, options *reconstruct.BuilderOptions) *Decoder {
+	this := &Decoder{
+		reader: bufio.NewReader(reader),
+	}
+	if options != nil {
+		this.options = *options
+	}
+	return this
+}
+
+// Decode reads the next CTE document into target, which must be a non-nil
+// pointer. Returns io.EOF if there are no more documents.
+func (this *Decoder) Decode(target interface{}) error {
+	dst := reflect.ValueOf(target)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("Cannot decode into %v: Target must be a non-nil pointer", reflect.TypeOf(target))
+	}
+
+	b, err := this.beginDocument()
+	if err != nil {
+		return err
+	}
+
+	builder := reconstruct.NewBuilderWithOptions(target, &this.options)
+	this.callbacks = builder
+	if err := this.decodeValue(b); err != nil {
+		return err
+	}
+	return builder.StoreBuiltObject(target)
+}
+
+// DecodeEvents reads the next CTE document, passing its events to callbacks.
+// Returns io.EOF if there are no more documents.
+func (this *Decoder) DecodeEvents(callbacks reconstruct.ObjectIteratorCallbacks) error {
+	b, err := this.beginDocument()
+	if err != nil {
+		return err
+	}
+	this.callbacks = callbacks
+	return this.decodeValue(b)
+}
+
+// Read the document header, returning the first byte of the top-level object.
+func (this *Decoder) beginDocument() (byte, error) {
+	this.depth = 0
+	this.markerIDs = make(map[uint64]bool)
+	b, err := this.skipWhitespace()
+	if err != nil {
+		return 0, err
+	}
+	if b != versionHeader[0] {
+		return 0, this.syntaxError("Expected document header but got %q", b)
+	}
+	header, err := this.readToken(b)
+	if err != nil {
+		return 0, err
+	}
+	if header != versionHeader {
+		return 0, this.syntaxError("Unsupported document header %v", header)
+	}
+	return this.skipWhitespaceInDocument()
+}
+
+func (this *Decoder) syntaxError(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Offset: this.offset,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (this *Decoder) readByte() (byte, error) {
+	b, err := this.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	this.offset++
+	return b, nil
+}
+
+// Read a byte that must exist because we're partway through a document.
+func (this *Decoder) readByteInDocument() (byte, error) {
+	b, err := this.readByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (this *Decoder) unreadByte() {
+	this.reader.UnreadByte()
+	this.offset--
+}
+
+func isWhitespace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\r':
+		return true
+	default:
+		return false
+	}
+}
+
+// Tokens (numbers, times, and named values) end at whitespace or at the start
+// or end of something else. They also end where a comment starts, which
+// readToken checks for since '/' is part of a time.
+func isTokenDelimiter(b byte) bool {
+	switch b {
+	case '[', ']', '{', '}', '=', '"':
+		return true
+	default:
+		return isWhitespace(b)
+	}
+}
+
+// Skip whitespace and comments, returning the byte after them.
+func (this *Decoder) skipWhitespace() (byte, error) {
+	for {
+		b, err := this.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case isWhitespace(b):
+		case b == '/':
+			if err := this.skipComment(); err != nil {
+				return 0, err
+			}
+		default:
+			return b, nil
+		}
+	}
+}
+
+func (this *Decoder) skipWhitespaceInDocument() (byte, error) {
+	b, err := this.skipWhitespace()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// Skip the rest of a comment whose opening '/' has already been read.
+func (this *Decoder) skipComment() error {
+	b, err := this.readByteInDocument()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case '/':
+		for b != '\n' {
+			if b, err = this.readByte(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case '*':
+		return this.skipBlockComment()
+	default:
+		return this.syntaxError("Unexpected %q after '/'", b)
+	}
+}
+
+func (this *Decoder) skipBlockComment() error {
+	if err := this.enter(); err != nil {
+		return err
+	}
+	var previous byte
+	for {
+		b, err := this.readByteInDocument()
+		if err != nil {
+			return err
+		}
+		switch {
+		case previous == '*' && b == '/':
+			this.depth--
+			return nil
+		case previous == '/' && b == '*':
+			if err := this.skipBlockComment(); err != nil {
+				return err
+			}
+			b = 0
+		}
+		previous = b
+	}
+}
+
+func (this *Decoder) enter() error {
+	this.depth++
+	if this.depth > maxDepth {
+		return this.syntaxError("Exceeded maximum nesting depth of %v", maxDepth)
+	}
+	return nil
+}
+
+func (this *Decoder) decodeValue(b byte) error {
+	switch b {
+	case '[':
+		if err := this.enter(); err != nil {
+			return err
+		}
+		if err := this.callbacks.OnListBegin(); err != nil {
+			return err
+		}
+		return this.decodeList()
+	case '{':
+		if err := this.enter(); err != nil {
+			return err
+		}
+		if err := this.callbacks.OnMapBegin(); err != nil {
+			return err
+		}
+		return this.decodeMap()
+	case '"':
+		value, err := this.readString()
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnString(value)
+	case '@':
+		return this.decodeTypedValue()
+	case '&':
+		return this.decodeMarker()
+	case '$':
+		id, err := this.readID()
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnReference(id)
+	case ']', '}', '=':
+		return this.syntaxError("Unexpected %q", b)
+	default:
+		token, err := this.readToken(b)
+		if err != nil {
+			return err
+		}
+		return this.decodeToken(token)
+	}
+}
+
+func (this *Decoder) decodeList() error {
+	for {
+		b, err := this.skipWhitespaceInDocument()
+		if err != nil {
+			return err
+		}
+		if b == ']' {
+			break
+		}
+		if err := this.decodeValue(b); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeMap() error {
+	for {
+		b, err := this.skipWhitespaceInDocument()
+		if err != nil {
+			return err
+		}
+		if b == '}' {
+			break
+		}
+		if err := this.decodeValue(b); err != nil {
+			return err
+		}
+		if b, err = this.skipWhitespaceInDocument(); err != nil {
+			return err
+		}
+		if b != '=' {
+			return this.syntaxError("Expected '=' after map key but got %q", b)
+		}
+		if b, err = this.skipWhitespaceInDocument(); err != nil {
+			return err
+		}
+		if b == '}' {
+			return this.syntaxError("Map ended with a key but no value")
+		}
+		if err := this.decodeValue(b); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeMarker() error {
+	id, err := this.readID()
+	if err != nil {
+		return err
+	}
+	b, err := this.readByteInDocument()
+	if err != nil {
+		return err
+	}
+	if b != ':' {
+		return this.syntaxError("Expected ':' after marker ID but got %q", b)
+	}
+	if this.markerIDs[id] {
+		return this.syntaxError("Marker ID %v has already been used", id)
+	}
+	this.markerIDs[id] = true
+	if err := this.callbacks.OnMarker(id); err != nil {
+		return err
+	}
+	if b, err = this.skipWhitespaceInDocument(); err != nil {
+		return err
+	}
+	switch b {
+	case '&', '$', ']', '}', '=':
+		return this.syntaxError("Marker must be followed by an object")
+	}
+	return this.decodeValue(b)
+}
+
+// Read a marker or reference ID, which is an unsigned decimal integer.
+func (this *Decoder) readID() (uint64, error) {
+	this.buffer = this.buffer[:0]
+	for {
+		b, err := this.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if b < '0' || b > '9' {
+			this.unreadByte()
+			break
+		}
+		this.buffer = append(this.buffer, b)
+	}
+	id, err := strconv.ParseUint(string(this.buffer), 10, 64)
+	if err != nil {
+		return 0, this.syntaxError("Invalid marker ID %q", this.buffer)
+	}
+	return id, nil
+}
+
+// Read the rest of a token whose first byte has already been read.
+func (this *Decoder) readToken(first byte) (string, error) {
+	this.buffer = append(this.buffer[:0], first)
+	for !this.isCommentNext() {
+		b, err := this.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if isTokenDelimiter(b) {
+			this.unreadByte()
+			break
+		}
+		this.buffer = append(this.buffer, b)
+	}
+	return string(this.buffer), nil
+}
+
+// Whether the next bytes to be read start a comment.
+func (this *Decoder) isCommentNext() bool {
+	next, err := this.reader.Peek(2)
+	return err == nil && next[0] == '/' && (next[1] == '/' || next[1] == '*')
+}
+
+func (this *Decoder) decodeToken(token string) error {
+	switch token {
+	case "null":
+		return this.callbacks.OnNil()
+	case "true":
+		return this.callbacks.OnBool(true)
+	case "false":
+		return this.callbacks.OnBool(false)
+	case "nan":
+		return this.callbacks.OnFloat(math.NaN())
+	case "inf":
+		return this.callbacks.OnFloat(math.Inf(1))
+	case "-inf":
+		return this.callbacks.OnFloat(math.Inf(-1))
+	}
+
+	if strings.IndexByte(token, '/') >= 0 {
+		return this.decodeTime(token)
+	}
+
+	isNegative := strings.HasPrefix(token, "-")
+	digits := strings.TrimPrefix(token, "-")
+	base := 10
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		base = 16
+		digits = digits[2:]
+	}
+	magnitude, err := strconv.ParseUint(digits, base, 64)
+	if err == nil {
+		switch {
+		case !isNegative && magnitude <= math.MaxInt64:
+			return this.callbacks.OnInt(int64(magnitude))
+		case !isNegative:
+			return this.callbacks.OnUint(magnitude)
+		case magnitude <= 1<<63:
+			return this.callbacks.OnInt(int64(-magnitude))
+		}
+	}
+	if err == nil || err.(*strconv.NumError).Err == strconv.ErrRange {
+		return this.syntaxError("Integer %v is out of range", token)
+	}
+
+	if strings.ContainsAny(token, ".eEpP") {
+		value, err := strconv.ParseFloat(token, 64)
+		if err == nil {
+			return this.callbacks.OnFloat(value)
+		}
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return this.syntaxError("Float %v is out of range", token)
+		}
+	}
+	return this.syntaxError("Invalid value %q", token)
+}
+
+func (this *Decoder) decodeTime(token string) error {
+	layout := timeLayout
+	if len(token) > 6 && (token[len(token)-6] == '+' || token[len(token)-6] == '-') {
+		layout = timeLayoutOffset
+	}
+	value, err := time.Parse(layout, token)
+	if err != nil {
+		return this.syntaxError("Invalid time %v", token)
+	}
+	return this.callbacks.OnTime(value)
+}
+
+// Decode a value whose '@' prefix has already been read, which is either a
+// URI (@"...") or bytes (@u8x[...]).
+func (this *Decoder) decodeTypedValue() error {
+	b, err := this.readByteInDocument()
+	if err != nil {
+		return err
+	}
+	if b == '"' {
+		str, err := this.readString()
+		if err != nil {
+			return err
+		}
+		value, err := url.Parse(str)
+		if err != nil {
+			return this.syntaxError("Invalid URI %q: %v", str, err)
+		}
+		return this.callbacks.OnURI(value)
+	}
+
+	arrayType, err := this.readToken(b)
+	if err != nil {
+		return err
+	}
+	if arrayType != "u8x" {
+		return this.syntaxError("Unsupported array type %v", arrayType)
+	}
+	if b, err = this.readByteInDocument(); err != nil {
+		return err
+	}
+	if b != '[' {
+		return this.syntaxError("Expected '[' after array type but got %q", b)
+	}
+	value, err := this.readHexBytes()
+	if err != nil {
+		return err
+	}
+	return this.callbacks.OnBytes(value)
+}
+
+// Read hex encoded bytes up to the closing ']'. Whitespace may appear between
+// bytes, but not within them.
+func (this *Decoder) readHexBytes() ([]byte, error) {
+	value := []byte{}
+	isHighNibble := true
+	for {
+		b, err := this.readByteInDocument()
+		if err != nil {
+			return nil, err
+		}
+		var nibble byte
+		switch {
+		case (b == ']' || isWhitespace(b)) && isHighNibble:
+			if b == ']' {
+				return value, nil
+			}
+			continue
+		case b >= '0' && b <= '9':
+			nibble = b - '0'
+		case b >= 'a' && b <= 'f':
+			nibble = b - 'a' + 10
+		case b >= 'A' && b <= 'F':
+			nibble = b - 'A' + 10
+		default:
+			return nil, this.syntaxError("Unexpected %q in hex bytes", b)
+		}
+		if isHighNibble {
+			value = append(value, nibble<<4)
+		} else {
+			value[len(value)-1] |= nibble
+		}
+		isHighNibble = !isHighNibble
+	}
+}
+
+// Read the rest of a string whose opening quote has already been read.
+func (this *Decoder) readString() (string, error) {
+	this.buffer = this.buffer[:0]
+	for {
+		b, err := this.readByteInDocument()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '"':
+			if !utf8.Valid(this.buffer) {
+				// Converting to runes replaces invalid bytes with U+FFFD
+				return string([]rune(string(this.buffer))), nil
+			}
+			return string(this.buffer), nil
+		case '\\':
+			if err := this.readEscape(); err != nil {
+				return "", err
+			}
+		default:
+			this.buffer = append(this.buffer, b)
+		}
+	}
+}
+
+func (this *Decoder) readEscape() error {
+	b, err := this.readByteInDocument()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case '"', '\\':
+		this.buffer = append(this.buffer, b)
+	case 'n':
+		this.buffer = append(this.buffer, '\n')
+	case 'r':
+		this.buffer = append(this.buffer, '\r')
+	case 't':
+		this.buffer = append(this.buffer, '\t')
+	case 'u':
+		var r rune
+		for i := 0; i < 4; i++ {
+			if b, err = this.readByteInDocument(); err != nil {
+				return err
+			}
+			digit, err := strconv.ParseUint(string(b), 16, 8)
+			if err != nil {
+				return this.syntaxError("Invalid hex digit %q in unicode escape", b)
+			}
+			r = r<<4 | rune(digit)
+		}
+		// Surrogates can't be encoded, and come out as U+FFFD
+		var encoded [utf8.UTFMax]byte
+		this.buffer = append(this.buffer, encoded[:utf8.EncodeRune(encoded[:], r)]...)
+	default:
+		return this.syntaxError("Invalid escape sequence \\%c", b)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+// Package cte encodes and decodes Concise Text Encoding (CTE) using
+// reconstruct's iterators and builders.
+//
+// CTE is the text counterpart of Concise Binary Encoding (see package cbe),
+// and supports the same data model. Every top-level object is a document,
+// beginning with the version header "c1". This package supports the
+// following subset of the format:
+//
+//	Nil                  null
+//	Bool                 true or false
+//	Int, Uint            decimal integer (hexadecimal such as 0x1f is also accepted when decoding)
+//	Float                decimal float, always with a fraction or exponent, or nan, inf, -inf
+//	String               "quoted", with escapes \" \\ \n \r \t and \uXXXX
+//	Bytes                @u8x[01 02 ff]
+//	URI                  @"http://example.com"
+//	Time                 2020-01-02/03:04:05.6 (UTC) or 2020-01-02/03:04:05.6-05:00
+//	List                 [object object ...]
+//	Map                  {key=value key=value ...}
+//	Marker               &id:object
+//	Reference            $id
+//
+// Objects are separated by whitespace. The decoder also skips comments, which
+// are either from // to the end of the line, or between /* and */ (and can be
+// nested).
+//
+// Marker IDs are assigned in the order that markers are encoded, starting at
+// 0 in each document. When decoding, any unsigned integer can be a marker ID,
+// but each may only be used once per document.
+package cte
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+const (
+	versionHeader = "c1"
+
+	timeLayout       = "2006-01-02/15:04:05.999999999"
+	timeLayoutOffset = timeLayout + "-07:00"
+)
+
+type EncoderOptions struct {
+	// If not empty, the contents of lists and maps are placed on separate
+	// lines, with each level of nesting indented by this string (typically
+	// "    " or "\t").
+	Indent string
+}
+
+func (this *EncoderOptions) withDefaultsApplied() EncoderOptions {
+	var options EncoderOptions
+	if this != nil {
+		options = *this
+	}
+	return options
+}
+
+// Encode writes value to writer as a CTE document. Pointers to the same data
+// are encoded once and then referenced, so values containing cycles can be
+// encoded.
+func Encode(writer io.Writer, value interface{}, options *EncoderOptions) error {
+	iterOptions := &reconstruct.IteratorOptions{UseReferences: true}
+	return reconstruct.IterateObjectWithOptions(value, iterOptions, NewEncoder(writer, options))
+}
+
+// How much output to buffer before writing to the underlying writer
+const flushThreshold = 4096
+
+type encoderContainer struct {
+	isMap bool
+	count int
+}
+
+// Encoder implements ObjectIteratorCallbacks, writing each top-level object as
+// a CTE document followed by a newline.
+type Encoder struct {
+	writer      io.Writer
+	options     EncoderOptions
+	buffer      []byte
+	containers  []encoderContainer
+	inDocument  bool
+	afterMarker bool
+	markerIDs   map[interface{}]uint64
+	err         error
+}
+
+// NewEncoder creates an encoder that writes to writer, configured by options
+// (nil means use defaults).
+func NewEncoder(writer io.Writer, options *EncoderOptions) *Encoder {
+	return &Encoder{
+		writer:  writer,
+		options: options.withDefaultsApplied(),
+	}
+}
+
+func (this *Encoder) fail(format string, args ...interface{}) error {
+	this.err = fmt.Errorf(format, args...)
+	return this.err
+}
+
+func (this *Encoder) flush() error {
+	if _, err := this.writer.Write(this.buffer); err != nil {
+		this.err = err
+		return err
+	}
+	this.buffer = this.buffer[:0]
+	return nil
+}
+
+func (this *Encoder) writeNewline(depth int) {
+	this.buffer = append(this.buffer, '\n')
+	for i := 0; i < depth; i++ {
+		this.buffer = append(this.buffer, this.options.Indent...)
+	}
+}
+
+// Write the document header, or whatever separates the next object from the
+// previous one.
+func (this *Encoder) beginValue() error {
+	if this.err != nil {
+		return this.err
+	}
+	if !this.inDocument {
+		this.buffer = append(this.buffer, versionHeader...)
+		this.buffer = append(this.buffer, '\n')
+		this.inDocument = true
+	}
+	if this.afterMarker {
+		// The separator went before the marker
+		this.afterMarker = false
+		return nil
+	}
+	if len(this.containers) == 0 {
+		return nil
+	}
+
+	container := &this.containers[len(this.containers)-1]
+	switch {
+	case container.isMap && container.count%2 != 0:
+		if this.options.Indent != "" {
+			this.buffer = append(this.buffer, " = "...)
+		} else {
+			this.buffer = append(this.buffer, '=')
+		}
+	case this.options.Indent != "":
+		this.writeNewline(len(this.containers))
+	case container.count > 0:
+		this.buffer = append(this.buffer, ' ')
+	}
+	container.count++
+	return nil
+}
+
+func (this *Encoder) endValue() error {
+	if len(this.containers) > 0 {
+		if len(this.buffer) >= flushThreshold {
+			return this.flush()
+		}
+		return nil
+	}
+	this.buffer = append(this.buffer, '\n')
+	this.inDocument = false
+	this.markerIDs = nil
+	return this.flush()
+}
+
+func (this *Encoder) encodeToken(token string) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, token...)
+	return this.endValue()
+}
+
+func (this *Encoder) beginContainer(opener byte, isMap bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, opener)
+	this.containers = append(this.containers, encoderContainer{isMap: isMap})
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+func appendQuoted(buffer []byte, value string) []byte {
+	buffer = append(buffer, '"')
+	start := 0
+	for i := 0; i < len(value); {
+		b := value[i]
+		if b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != 0x7f {
+				i++
+				continue
+			}
+			buffer = append(buffer, value[start:i]...)
+			switch b {
+			case '"', '\\':
+				buffer = append(buffer, '\\', b)
+			case '\n':
+				buffer = append(buffer, '\\', 'n')
+			case '\r':
+				buffer = append(buffer, '\\', 'r')
+			case '\t':
+				buffer = append(buffer, '\\', 't')
+			default:
+				buffer = append(buffer, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			buffer = append(buffer, value[start:i]...)
+			buffer = append(buffer, "�"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buffer = append(buffer, value[start:]...)
+	return append(buffer, '"')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "nan"
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	}
+	str := strconv.FormatFloat(value, 'g', -1, 64)
+	for _, ch := range str {
+		if ch == '.' || ch == 'e' {
+			return str
+		}
+	}
+	return str + ".0"
+}
+
+func formatTime(value time.Time) string {
+	_, offset := value.Zone()
+	if offset == 0 {
+		return value.UTC().Format(timeLayout)
+	}
+	return value.Format(timeLayoutOffset)
+}
+
+// -----------------------
+// ObjectIteratorCallbacks
+// -----------------------
+
+func (this *Encoder) OnNil() error {
+	return this.encodeToken("null")
+}
+
+func (this *Encoder) OnBool(value bool) error {
+	return this.encodeToken(strconv.FormatBool(value))
+}
+
+func (this *Encoder) OnInt(value int64) error {
+	return this.encodeToken(strconv.FormatInt(value, 10))
+}
+
+func (this *Encoder) OnUint(value uint64) error {
+	return this.encodeToken(strconv.FormatUint(value, 10))
+}
+
+func (this *Encoder) OnFloat(value float64) error {
+	return this.encodeToken(formatFloat(value))
+}
+
+func (this *Encoder) OnComplex(value complex128) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("CTE cannot represent complex value %v", value)
+}
+
+func (this *Encoder) OnString(value string) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendQuoted(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnBytes(value []byte) error {
+	if value == nil {
+		return this.OnNil()
+	}
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, "@u8x["...)
+	for i, b := range value {
+		if i > 0 {
+			this.buffer = append(this.buffer, ' ')
+		}
+		this.buffer = append(this.buffer, hexDigits[b>>4], hexDigits[b&0xf])
+	}
+	this.buffer = append(this.buffer, ']')
+	return this.endValue()
+}
+
+func (this *Encoder) OnURI(value *url.URL) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, '@')
+	this.buffer = appendQuoted(this.buffer, value.String())
+	return this.endValue()
+}
+
+func (this *Encoder) OnTime(value time.Time) error {
+	return this.encodeToken(formatTime(value))
+}
+
+func (this *Encoder) OnListBegin() error {
+	return this.beginContainer('[', false)
+}
+
+func (this *Encoder) OnMapBegin() error {
+	return this.beginContainer('{', true)
+}
+
+func (this *Encoder) OnContainerEnd() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) == 0 {
+		return this.fail("Container end without a matching container begin")
+	}
+	container := this.containers[len(this.containers)-1]
+	if container.isMap && container.count%2 != 0 {
+		return this.fail("CTE map ended with a key but no value")
+	}
+	this.containers = this.containers[:len(this.containers)-1]
+	if container.count > 0 && this.options.Indent != "" {
+		this.writeNewline(len(this.containers))
+	}
+	if container.isMap {
+		this.buffer = append(this.buffer, '}')
+	} else {
+		this.buffer = append(this.buffer, ']')
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnMarker(id interface{}) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if this.markerIDs == nil {
+		this.markerIDs = make(map[interface{}]uint64)
+	}
+	if _, exists := this.markerIDs[id]; exists {
+		return this.fail("Marker ID %v has already been used", id)
+	}
+	markerID := uint64(len(this.markerIDs))
+	this.markerIDs[id] = markerID
+	this.buffer = append(this.buffer, '&')
+	this.buffer = strconv.AppendUint(this.buffer, markerID, 10)
+	this.buffer = append(this.buffer, ':')
+	this.afterMarker = true
+	return nil
+}
+
+func (this *Encoder) OnReference(id interface{}) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	markerID, ok := this.markerIDs[id]
+	if !ok {
+		return this.fail("Reference to unknown marker ID %v", id)
+	}
+	this.buffer = append(this.buffer, '$')
+	this.buffer = strconv.AppendUint(this.buffer, markerID, 10)
+	return this.endValue()
+}
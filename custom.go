@@ -0,0 +1,198 @@
+package reconstruct
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// CustomIterateFunc iterates a value of a custom type, typically by calling a
+// single scalar callback (such as OnString for a decimal type).
+type CustomIterateFunc func(value interface{}, callbacks ObjectIteratorCallbacks) error
+
+// CustomBuildFunc builds a scalar event into dst, which is of the custom type.
+// src holds the event's value: nil, bool, int64, uint64, float64, complex128,
+// string, []byte, *url.URL, or time.Time.
+type CustomBuildFunc func(src interface{}, dst reflect.Value) error
+
+type customCodec struct {
+	iterate CustomIterateFunc
+	build   CustomBuildFunc
+}
+
+var customCodecs sync.Map
+
+// RegisterCustomType changes how values of the same type as template are
+// iterated and built, allowing them to be represented as scalars rather than
+// being walked as structs (or arrays etc). Either function may be nil, in which
+// case that direction uses the default behavior.
+//
+// Types must be registered before they're first iterated or built (such as
+// from an init function), because the generated iterators and builders are
+// cached. Registering a type while another goroutine is using it for the
+// first time is not supported. RegisterCustomType panics if the type is
+// already in use or has already been registered.
+func RegisterCustomType(template interface{}, iterate CustomIterateFunc, build CustomBuildFunc) {
+	t := reflect.TypeOf(template)
+	if t == nil {
+		panic(fmt.Errorf("Cannot register a custom nil type"))
+	}
+	codec := &customCodec{iterate: iterate, build: build}
+	if _, loaded := customCodecs.LoadOrStore(t, codec); loaded {
+		panic(fmt.Errorf("Cannot register custom type %v: It's already registered", t))
+	}
+
+	// Checked after storing the codec, so that anything generated without it
+	// before it became visible is caught.
+	if _, ok := iterators.Load(t); ok {
+		customCodecs.Delete(t)
+		panic(fmt.Errorf("Cannot register custom type %v: It has already been iterated", t))
+	}
+	if _, ok := builders.Load(t); ok {
+		customCodecs.Delete(t)
+		panic(fmt.Errorf("Cannot register custom type %v: It has already been built", t))
+	}
+}
+
+func getCustomCodec(t reflect.Type) *customCodec {
+	if codec, ok := customCodecs.Load(t); ok {
+		return codec.(*customCodec)
+	}
+	return nil
+}
+
+func getCustomIterator(t reflect.Type) ObjectIterator {
+	if codec := getCustomCodec(t); codec != nil && codec.iterate != nil {
+		return &customIterator{iterate: codec.iterate}
+	}
+	return nil
+}
+
+func getCustomBuilder(t reflect.Type) ObjectBuilder {
+	if codec := getCustomCodec(t); codec != nil && codec.build != nil {
+		return &customBuilder{dstType: t, build: codec.build}
+	}
+	return nil
+}
+
+// --------
+// Iterator
+// --------
+
+type customIterator struct {
+	iterate CustomIterateFunc
+	root    *RootObjectIterator
+}
+
+func (this *customIterator) PostCacheInitIterator() {
+}
+
+func (this *customIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
+	return &customIterator{
+		iterate: this.iterate,
+		root:    root,
+	}
+}
+
+func (this *customIterator) Iterate(v reflect.Value) error {
+	if err := this.iterate(v.Interface(), this.root.callbacks); err != nil {
+		return this.root.callbacks.wrapError(err)
+	}
+	return nil
+}
+
+// -------
+// Builder
+// -------
+
+type customBuilder struct {
+	// Const data
+	dstType reflect.Type
+	build   CustomBuildFunc
+}
+
+func (this *customBuilder) PostCacheInitBuilder() {
+}
+
+func (this *customBuilder) CloneFromTemplate(root *RootBuilder, parent ObjectBuilder) ObjectBuilder {
+	return this
+}
+
+func (this *customBuilder) buildFrom(src interface{}, dst reflect.Value) {
+	if err := this.build(src, dst); err != nil {
+		panic(&BuildError{
+			DstType: this.dstType,
+			Reason:  err.Error(),
+		})
+	}
+}
+
+func (this *customBuilder) Nil(dst reflect.Value) {
+	this.buildFrom(nil, dst)
+}
+
+func (this *customBuilder) Bool(value bool, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) Int(value int64, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) Uint(value uint64, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) Float(value float64, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) Complex(value complex128, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) String(value string, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) Bytes(value []byte, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) URI(value *url.URL, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) Time(value time.Time, dst reflect.Value) {
+	this.buildFrom(value, dst)
+}
+
+func (this *customBuilder) List() {
+	builderPanicBadEvent(this, this.dstType, "ListBegin")
+}
+
+func (this *customBuilder) Map() {
+	builderPanicBadEvent(this, this.dstType, "MapBegin")
+}
+
+func (this *customBuilder) End() {
+	builderPanicBadEvent(this, this.dstType, "ContainerEnd")
+}
+
+func (this *customBuilder) Reference(id interface{}) {
+	builderPanicBadEvent(this, this.dstType, "Reference")
+}
+
+func (this *customBuilder) PrepareForListContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForListContents")
+}
+
+func (this *customBuilder) PrepareForMapContents() {
+	builderPanicBadEvent(this, this.dstType, "PrepareForMapContents")
+}
+
+func (this *customBuilder) NotifyChildContainerFinished(value reflect.Value) {
+	builderPanicBadEvent(this, this.dstType, "NotifyChildContainerFinished")
+}
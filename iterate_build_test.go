@@ -1,8 +1,14 @@
 package reconstruct
 
 import (
+	"context"
 	"fmt"
+	"math/big"
+	"net"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,11 +68,961 @@ func TestRoundtripStructWithPointers(t *testing.T) {
 	assertIterateBuild(t, v)
 }
 
+func TestRoundtripSharedPointers(t *testing.T) {
+	v := SharedPointerStruct{A: &SmallStruct{1}}
+	v.B = v.A
+	assertIterateBuild(t, v)
+
+	builder := NewBuilderFor(v)
+	if err := IterateObject(v, true, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	rebuilt := builder.GetBuiltObject().(*SharedPointerStruct)
+	if rebuilt.A != rebuilt.B {
+		t.Errorf("Expected A and B to point to the same object")
+	}
+}
+
+func TestRoundtripCyclicMap(t *testing.T) {
+	v := map[string]interface{}{}
+	v["self"] = v
+
+	builder := NewBuilderFor(v)
+	if err := IterateObject(v, true, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	rebuilt := builder.GetBuiltObject().(map[string]interface{})
+	self, ok := rebuilt["self"].(map[string]interface{})
+	if !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(rebuilt).Pointer() {
+		t.Errorf("Expected map to contain itself")
+	}
+}
+
+type TreeNode struct {
+	Value    int
+	Next     *TreeNode
+	Children []*TreeNode
+	ByName   map[string]TreeNode
+}
+
+func TestRoundtripRecursiveTypes(t *testing.T) {
+	assertIterateBuild(t, TreeNode{})
+	assertIterateBuild(t, TreeNode{
+		Value: 1,
+		Next:  &TreeNode{Value: 2, Next: &TreeNode{Value: 3}},
+		Children: []*TreeNode{
+			&TreeNode{Value: 4},
+			&TreeNode{Value: 5, Children: []*TreeNode{&TreeNode{Value: 6}}},
+		},
+		ByName: map[string]TreeNode{
+			"a": TreeNode{Value: 7, Next: &TreeNode{Value: 8}},
+		},
+	})
+}
+
+func TestRoundtripCyclicList(t *testing.T) {
+	v := &TreeNode{Value: 1, Next: &TreeNode{Value: 2}}
+	v.Next.Next = v
+
+	builder := NewBuilderFor(v)
+	if err := IterateObject(v, true, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	rebuilt := builder.GetBuiltObject().(*TreeNode)
+	if rebuilt.Value != 1 || rebuilt.Next.Value != 2 || rebuilt.Next.Next != rebuilt {
+		t.Errorf("Expected a two element cycle but got %v", describe.D(rebuilt))
+	}
+}
+
+func TestRoundtripCyclicSlice(t *testing.T) {
+	v := SelfSlice{nil, nil}
+	v[1] = &v
+
+	builder := NewBuilderFor(v)
+	if err := IterateObject(v, true, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	rebuilt := builder.GetBuiltObject().(SelfSlice)
+	if len(rebuilt) != 2 || rebuilt[0] != nil || rebuilt[1] == nil || (*rebuilt[1])[1] != rebuilt[1] {
+		t.Errorf("Expected slice to contain a pointer to itself but got %v", describe.D(rebuilt))
+	}
+
+	l := []interface{}{1}
+	l = append(l, &l)
+	builder = NewBuilderFor(l)
+	if err := IterateObject(l, true, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	rebuiltList := builder.GetBuiltObject().([]interface{})
+	self, ok := rebuiltList[1].([]interface{})
+	if !ok || len(self) != 2 || reflect.ValueOf(self).Pointer() != reflect.ValueOf(rebuiltList).Pointer() {
+		t.Errorf("Expected slice to contain itself but got %v", describe.D(rebuiltList))
+	}
+}
+
+func TestPathToString(t *testing.T) {
+	path := []PathElement{
+		PathElement{Key: "a/b"},
+		PathElement{IsIndex: true, Index: 2},
+		PathElement{Key: "~x"},
+		PathElement{Key: 10},
+	}
+	expected := "/a~1b/2/~0x/10"
+	if actual := PathToString(path); actual != expected {
+		t.Errorf("Expected %v but got %v", expected, actual)
+	}
+}
+
+type timeRejectingBuilder struct {
+	*RootBuilder
+}
+
+func (this timeRejectingBuilder) OnTime(value time.Time) error {
+	return fmt.Errorf("Time not allowed")
+}
+
+func TestIterateErrorPath(t *testing.T) {
+	v := map[string][]interface{}{"a": []interface{}{1, time.Now()}}
+	callbacks := timeRejectingBuilder{NewBuilderFor(v)}
+	err := IterateObject(v, false, callbacks)
+	iterErr, ok := err.(*IterateError)
+	if !ok {
+		t.Errorf("Expected an *IterateError but got %v", err)
+		return
+	}
+	if iterErr.PathString() != "/a/1" {
+		t.Errorf("Expected error path /a/1 but got %v", iterErr.PathString())
+	}
+}
+
+func assertIteratesAs(t *testing.T, value interface{}, expected interface{}) {
+	builder := NewBuilderFor(expected)
+	if err := IterateObject(value, false, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	actual := builder.GetBuiltObject()
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+func TestIterateStructTags(t *testing.T) {
+	assertIteratesAs(t, TaggedStruct{Renamed: 1, Skipped: 2, Dash: 3},
+		map[string]interface{}{"renamed": 1, "-": 3, "Untagged": false})
+	assertIteratesAs(t, TaggedStruct{OmitEmpty: "x"},
+		map[string]interface{}{"renamed": 0, "OmitEmpty": "x", "-": 0, "Untagged": false})
+	assertIterateBuild(t, TaggedStruct{Renamed: 1, OmitEmpty: "x", Dash: 3, Untagged: true})
+}
+
+type MultiTaggedStruct struct {
+	Both     int `reconstruct:"r_both" json:"j_both" yaml:"y_both"`
+	JSONOnly int `json:"j_only,omitempty"`
+	YAMLOnly int `yaml:"-"`
+	None     int
+}
+
+func iterateWithResolver(t *testing.T, value interface{}, expected interface{}, resolver FieldNameResolver) {
+	builder := NewBuilderFor(expected)
+	options := &IteratorOptions{FieldNameResolver: resolver}
+	if err := IterateObjectWithOptions(value, options, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	actual := builder.GetBuiltObject()
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+func buildWithResolver(t *testing.T, value interface{}, expected interface{}, resolver FieldNameResolver) {
+	builder := NewBuilderWithOptions(expected, &BuilderOptions{FieldNameResolver: resolver})
+	if err := IterateObject(value, false, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	actual := builder.GetBuiltObject()
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+func TestIterateFieldNameResolver(t *testing.T) {
+	value := MultiTaggedStruct{Both: 1, YAMLOnly: 3, None: 4}
+	iterateWithResolver(t, value,
+		map[string]interface{}{"r_both": 1, "JSONOnly": 0, "YAMLOnly": 3, "None": 4}, nil)
+	iterateWithResolver(t, value,
+		map[string]interface{}{"j_both": 1, "YAMLOnly": 3, "None": 4}, JSONTagResolver)
+	iterateWithResolver(t, value,
+		map[string]interface{}{"y_both": 1, "JSONOnly": 0, "None": 4}, YAMLTagResolver)
+
+	upper := NewFuncFieldNameResolver(func(field reflect.StructField) (FieldTag, bool) {
+		return FieldTag{Name: strings.ToUpper(field.Name)}, true
+	})
+	iterateWithResolver(t, value,
+		map[string]interface{}{"BOTH": 1, "JSONONLY": 0, "YAMLONLY": 3, "NONE": 4}, upper)
+}
+
+func TestBuildFieldNameResolver(t *testing.T) {
+	buildWithResolver(t, map[string]interface{}{"j_both": 1, "j_only": 2, "r_both": 10},
+		MultiTaggedStruct{Both: 1, JSONOnly: 2}, JSONTagResolver)
+	buildWithResolver(t, map[string]interface{}{"y_both": 1, "YAMLOnly": 3, "None": 4},
+		MultiTaggedStruct{Both: 1, None: 4}, YAMLTagResolver)
+
+	// The same type can be built with different resolvers.
+	buildWithResolver(t, map[string]interface{}{"r_both": 1, "j_both": 2},
+		MultiTaggedStruct{Both: 1}, nil)
+}
+
+func TestFallbackFieldNameResolver(t *testing.T) {
+	resolver := NewFallbackFieldNameResolver(ReconstructTagResolver, JSONTagResolver)
+	value := MultiTaggedStruct{Both: 1, JSONOnly: 2, YAMLOnly: 3}
+	iterateWithResolver(t, value,
+		map[string]interface{}{"r_both": 1, "j_only": 2, "YAMLOnly": 3, "None": 0}, resolver)
+	buildWithResolver(t, map[string]interface{}{"r_both": 1, "j_only": 2, "YAMLOnly": 3},
+		value, resolver)
+}
+
+// Resolvers that can't be compared like map keys, and so aren't cached
+type funcResolver func(field reflect.StructField) (FieldTag, bool)
+
+func (this funcResolver) ResolveField(field reflect.StructField) (FieldTag, bool) {
+	return this(field)
+}
+
+type mapResolver struct {
+	names map[string]string
+}
+
+func (this mapResolver) ResolveField(field reflect.StructField) (FieldTag, bool) {
+	name, ok := this.names[field.Name]
+	return FieldTag{Name: name}, ok
+}
+
+type wrappedResolver struct {
+	inner FieldNameResolver
+}
+
+func (this wrappedResolver) ResolveField(field reflect.StructField) (FieldTag, bool) {
+	return this.inner.ResolveField(field)
+}
+
+func TestUncomparableFieldNameResolver(t *testing.T) {
+	value := MultiTaggedStruct{Both: 1, None: 4}
+	lower := funcResolver(func(field reflect.StructField) (FieldTag, bool) {
+		return FieldTag{Name: strings.ToLower(field.Name)}, true
+	})
+	for _, resolver := range []FieldNameResolver{lower, wrappedResolver{lower}} {
+		iterateWithResolver(t, value,
+			map[string]interface{}{"both": 1, "jsononly": 0, "yamlonly": 0, "none": 4}, resolver)
+		buildWithResolver(t, map[string]interface{}{"both": 1, "none": 4}, value, resolver)
+	}
+
+	// Each resolver gets its own fields, even when they're of the same type
+	for _, name := range []string{"x", "y"} {
+		resolver := mapResolver{names: map[string]string{"Both": name}}
+		iterateWithResolver(t, value,
+			map[string]interface{}{name: 1, "JSONOnly": 0, "YAMLOnly": 0, "None": 4}, resolver)
+		buildWithResolver(t, map[string]interface{}{name: 1, "None": 4}, value, resolver)
+	}
+}
+
+type EmbeddedInner struct {
+	A int
+	B int
+}
+
+type EmbeddedOther struct {
+	B int
+	C int
+	E int
+}
+
+type EmbeddedTagged struct {
+	D int
+}
+
+type EmbeddingStruct struct {
+	EmbeddedInner
+	*EmbeddedOther
+	EmbeddedTagged `reconstruct:"tagged"`
+	C              string
+}
+
+func TestIterateEmbedded(t *testing.T) {
+	assertIteratesAs(t, EmbeddingStruct{
+		EmbeddedInner:  EmbeddedInner{A: 1, B: 2},
+		EmbeddedOther:  &EmbeddedOther{B: 3, C: 4, E: 5},
+		EmbeddedTagged: EmbeddedTagged{D: 6},
+		C:              "x",
+	}, map[string]interface{}{
+		"A":      1,
+		"E":      5,
+		"tagged": map[string]interface{}{"D": 6},
+		"C":      "x",
+	})
+	assertIteratesAs(t, EmbeddingStruct{EmbeddedInner: EmbeddedInner{A: 1}},
+		map[string]interface{}{
+			"A":      1,
+			"tagged": map[string]interface{}{"D": 0},
+			"C":      "",
+		})
+}
+
+func TestRoundtripEmbedded(t *testing.T) {
+	assertIterateBuild(t, EmbeddingStruct{
+		EmbeddedInner:  EmbeddedInner{A: 1},
+		EmbeddedOther:  &EmbeddedOther{E: 5},
+		EmbeddedTagged: EmbeddedTagged{D: 6},
+		C:              "x",
+	})
+	assertIterateBuild(t, EmbeddingStruct{EmbeddedInner: EmbeddedInner{A: 1}})
+}
+
+type RemainStruct struct {
+	A     int
+	Extra map[string]interface{} `reconstruct:",remain"`
+}
+
+func TestIterateRemain(t *testing.T) {
+	assertIteratesAs(t, RemainStruct{A: 1, Extra: map[string]interface{}{"A": 5, "b": 2}},
+		map[string]interface{}{"A": 1, "b": 2})
+	assertIteratesAs(t, RemainStruct{A: 1},
+		map[string]interface{}{"A": 1})
+}
+
+func TestRoundtripRemain(t *testing.T) {
+	assertIterateBuild(t, RemainStruct{A: 1})
+	assertIterateBuild(t, RemainStruct{A: 1, Extra: map[string]interface{}{
+		"b": 2,
+		"c": []interface{}{"x", 1.5},
+		"d": map[interface{}]interface{}{"e": true},
+	}})
+}
+
+func TestRoundtripComplex(t *testing.T) {
+	assertIterateBuild(t, complex64(1+2i))
+	assertIterateBuild(t, complex128(-1.5-2.25i))
+	assertIterateBuild(t, []interface{}{1 + 1i, complex64(2)})
+	assertIterateBuild(t, struct{ C complex128 }{C: 1i})
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	Radius float64
+}
+
+func (this *Circle) Area() float64 {
+	return 3 * this.Radius * this.Radius
+}
+
+type Square struct {
+	Side float64
+}
+
+func (this Square) Area() float64 {
+	return this.Side * this.Side
+}
+
+type Drawing struct {
+	Main   Shape
+	Shapes []Shape
+	Any    interface{}
+}
+
+var testTypeRegistry = func() *TypeRegistry {
+	registry := NewTypeRegistry()
+	registry.Register("circle", &Circle{})
+	registry.Register("square", Square{})
+	return registry
+}()
+
+func assertIterateBuildWithRegistry(t *testing.T, expected interface{}) {
+	builder := NewBuilderWithOptions(expected, &BuilderOptions{TypeRegistry: testTypeRegistry})
+	options := &IteratorOptions{UseReferences: true, TypeRegistry: testTypeRegistry}
+	if err := IterateObjectWithOptions(expected, options, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	actual := builder.GetBuiltObject()
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+func TestIterateTypeDiscriminator(t *testing.T) {
+	builder := NewBuilderFor(map[string]interface{}{})
+	options := &IteratorOptions{TypeRegistry: testTypeRegistry, TypeKey: "kind"}
+	value := Drawing{Main: &Circle{Radius: 1}, Any: Square{Side: 2}}
+	if err := IterateObjectWithOptions(value, options, builder); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"Main":   map[string]interface{}{"kind": "circle", "Radius": 1.0},
+		"Shapes": nil,
+		"Any":    map[string]interface{}{"kind": "square", "Side": 2.0},
+	}
+	actual := builder.GetBuiltObject()
+	if !equivalence.IsEquivalent(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+func TestRoundtripPolymorphic(t *testing.T) {
+	circle := &Circle{Radius: 1}
+	assertIterateBuildWithRegistry(t, Drawing{
+		Main:   circle,
+		Shapes: []Shape{circle, Square{Side: 2}, nil, circle},
+		Any:    []interface{}{Square{Side: 3}, map[string]interface{}{"a": 1}},
+	})
+
+	var shape Shape = &Circle{Radius: 5}
+	assertIterateBuildWithRegistry(t, &shape)
+}
+
+type HintedStruct struct {
+	Value  interface{}
+	Values []interface{}
+	Map    map[interface{}]interface{}
+}
+
+type HintedInner struct {
+	A int8
+}
+
+func assertIterateBuildWithTypeHints(t *testing.T, expected interface{}) {
+	builder := NewBuilderFor(expected)
+	options := &IteratorOptions{UseReferences: true, EmitTypeHints: true}
+	if err := IterateObjectWithOptions(expected, options, builder); err != nil {
+		t.Error(err)
+		return
+	}
+	actual := builder.GetBuiltObject()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+}
+
+func TestRoundtripTypeHints(t *testing.T) {
+	assertIterateBuildWithTypeHints(t, &HintedStruct{Value: int8(-5)})
+	assertIterateBuildWithTypeHints(t, &HintedStruct{Value: []string{"a", "b"}})
+	assertIterateBuildWithTypeHints(t, &HintedStruct{Value: map[string]int{"a": 1}})
+	assertIterateBuildWithTypeHints(t, &HintedStruct{Value: &HintedInner{A: 1}})
+	assertIterateBuildWithTypeHints(t, &HintedStruct{Value: (*HintedInner)(nil)})
+	assertIterateBuildWithTypeHints(t, &HintedStruct{Value: [2]uint16{1, 2}})
+	assertIterateBuildWithTypeHints(t, &HintedStruct{
+		Values: []interface{}{float32(1.5), uint8(2), "x", []int16{3}, nil},
+		Map: map[interface{}]interface{}{
+			int32(1):  map[string]interface{}{"a": int64(1), "b": uint(2)},
+			"complex": complex64(1 + 2i),
+		},
+	})
+	assertIterateBuildWithTypeHints(t, []interface{}{int8(1), []interface{}{uint32(2)}})
+	assertIterateBuildWithTypeHints(t, map[string]interface{}{"a": []byte{1}, "b": HintedInner{A: 2}})
+
+	// Shared pointers held by interfaces keep their identity
+	inner := &HintedInner{A: 3}
+	value := &HintedStruct{Values: []interface{}{inner, inner}}
+	assertIterateBuildWithTypeHints(t, value)
+	builder := NewBuilderFor(value)
+	options := &IteratorOptions{UseReferences: true, EmitTypeHints: true}
+	if err := IterateObjectWithOptions(value, options, builder); err != nil {
+		t.Fatal(err)
+	}
+	values := builder.GetBuiltObject().(*HintedStruct).Values
+	if values[0].(*HintedInner) != values[1].(*HintedInner) {
+		t.Errorf("Expected shared pointers to be preserved")
+	}
+}
+
+func TestTypeHintsIgnored(t *testing.T) {
+	// Hints that don't fit the destination are ignored
+	builder := NewBuilderFor(map[string]int64{})
+	options := &IteratorOptions{EmitTypeHints: true}
+	if err := IterateObjectWithOptions(map[string]interface{}{"a": int8(1)}, options, builder); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{"a": 1}
+	if actual := builder.GetBuiltObject(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(expected), describe.D(actual))
+	}
+
+	// Without hints, interfaces hold the default types
+	builder = NewBuilderFor(HintedStruct{})
+	if err := IterateObject(HintedStruct{Value: int8(1)}, false, builder); err != nil {
+		t.Fatal(err)
+	}
+	if actual := builder.GetBuiltObject().(*HintedStruct).Value; actual != int64(1) {
+		t.Errorf("Expected int64(1) but got %v", describe.D(actual))
+	}
+}
+
+// A type with unexported fields, which would otherwise iterate as an empty map
+type CustomMoney struct {
+	cents int64
+}
+
+// An array type, which would otherwise iterate as a list
+type CustomAddr [4]byte
+
+func init() {
+	RegisterCustomType(CustomMoney{},
+		func(value interface{}, callbacks ObjectIteratorCallbacks) error {
+			cents := value.(CustomMoney).cents
+			return callbacks.OnString(fmt.Sprintf("%d.%02d", cents/100, cents%100))
+		},
+		func(src interface{}, dst reflect.Value) error {
+			str, ok := src.(string)
+			if !ok {
+				return fmt.Errorf("Expected a string but got %v", src)
+			}
+			var units, cents int64
+			if _, err := fmt.Sscanf(str, "%d.%02d", &units, &cents); err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(CustomMoney{cents: units*100 + cents}))
+			return nil
+		})
+	RegisterCustomType(CustomAddr{},
+		func(value interface{}, callbacks ObjectIteratorCallbacks) error {
+			addr := value.(CustomAddr)
+			return callbacks.OnString(fmt.Sprintf("%d.%d.%d.%d", addr[0], addr[1], addr[2], addr[3]))
+		},
+		func(src interface{}, dst reflect.Value) error {
+			var addr CustomAddr
+			if _, err := fmt.Sscanf(src.(string), "%d.%d.%d.%d", &addr[0], &addr[1], &addr[2], &addr[3]); err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(addr))
+			return nil
+		})
+}
+
+type CustomStruct struct {
+	Price  CustomMoney
+	Prices []CustomMoney
+	Addr   *CustomAddr
+}
+
+func TestIterateCustomType(t *testing.T) {
+	addr := CustomAddr{10, 0, 0, 1}
+	assertIteratesAs(t, CustomStruct{Price: CustomMoney{150}, Addr: &addr},
+		map[string]interface{}{"Price": "1.50", "Prices": nil, "Addr": "10.0.0.1"})
+	assertIteratesAs(t, []interface{}{CustomMoney{5}}, []interface{}{"0.05"})
+}
+
+func TestRoundtripCustomType(t *testing.T) {
+	addr := CustomAddr{192, 168, 1, 1}
+	assertIterateBuild(t, CustomStruct{
+		Price:  CustomMoney{1234},
+		Prices: []CustomMoney{{1}, {200}},
+		Addr:   &addr,
+	})
+	assertIterateBuild(t, CustomMoney{99})
+	assertIterateBuild(t, addr)
+	assertIterateBuild(t, map[CustomAddr]CustomMoney{addr: {7}})
+
+	builder := NewBuilderFor(CustomMoney{})
+	if err := IterateObject(CustomMoney{250}, false, builder); err != nil {
+		t.Fatal(err)
+	}
+	if actual := builder.GetBuiltObject().(*CustomMoney); *actual != (CustomMoney{250}) {
+		t.Errorf("Expected CustomMoney{250} but got %v", describe.D(actual))
+	}
+}
+
+func TestCustomTypeErrors(t *testing.T) {
+	builder := NewBuilderFor(CustomStruct{})
+	err := IterateObject(map[string]interface{}{"Price": 1}, false, builder)
+	if _, ok := err.(*BuildError); !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+	}
+
+	// A panic that isn't a BuildError is a bug, and isn't disguised as one
+	builder = NewBuilderFor(CustomAddr{})
+	assertPanics(t, func() {
+		builder.OnInt(1)
+	})
+
+	assertPanics(t, func() {
+		RegisterCustomType(CustomMoney{}, nil, nil)
+	})
+	assertPanics(t, func() {
+		RegisterCustomType(int(0), nil, nil)
+	})
+	// A rejected registration must not be left behind
+	if getCustomCodec(reflect.TypeOf(int(0))) != nil {
+		t.Errorf("Expected the rejected registration of int to be removed")
+	}
+}
+
+type TextEnum int
+
+const (
+	TextEnumZero TextEnum = iota
+	TextEnumOne
+)
+
+func (this TextEnum) MarshalText() ([]byte, error) {
+	switch this {
+	case TextEnumZero:
+		return []byte("zero"), nil
+	case TextEnumOne:
+		return []byte("one"), nil
+	default:
+		return nil, fmt.Errorf("Invalid TextEnum %d", int(this))
+	}
+}
+
+func (this *TextEnum) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "zero":
+		*this = TextEnumZero
+	case "one":
+		*this = TextEnumOne
+	default:
+		return fmt.Errorf("Invalid TextEnum %q", text)
+	}
+	return nil
+}
+
+type TextMarshalerStruct struct {
+	Enum    TextEnum
+	Enums   map[TextEnum]TextEnum
+	IP      net.IP
+	Big     big.Int
+	BigPtr  *big.Int
+	NilBig  *big.Int
+	AnyEnum interface{}
+}
+
+func TestIterateTextMarshaler(t *testing.T) {
+	assertIteratesAs(t, TextMarshalerStruct{
+		Enum:    TextEnumOne,
+		Enums:   map[TextEnum]TextEnum{TextEnumZero: TextEnumOne},
+		IP:      net.IPv4(127, 0, 0, 1),
+		Big:     *big.NewInt(-12345),
+		BigPtr:  big.NewInt(7),
+		AnyEnum: TextEnumZero,
+	}, map[string]interface{}{
+		"Enum":    "one",
+		"Enums":   map[string]interface{}{"zero": "one"},
+		"IP":      "127.0.0.1",
+		"Big":     "-12345",
+		"BigPtr":  "7",
+		"NilBig":  nil,
+		"AnyEnum": "zero",
+	})
+
+	err := IterateObject(TextEnum(5), false, NewBuilderFor(""))
+	if _, ok := err.(*IterateError); !ok {
+		t.Errorf("Expected an *IterateError but got %v", err)
+	}
+}
+
+func TestRoundtripTextMarshaler(t *testing.T) {
+	value := &TextMarshalerStruct{
+		Enum:   TextEnumOne,
+		Enums:  map[TextEnum]TextEnum{TextEnumOne: TextEnumZero},
+		IP:     net.ParseIP("::1"),
+		Big:    *new(big.Int).Lsh(big.NewInt(1), 100),
+		BigPtr: big.NewInt(-1),
+	}
+	builder := NewBuilderFor(value)
+	if err := IterateObject(value, false, builder); err != nil {
+		t.Fatal(err)
+	}
+	actual := builder.GetBuiltObject().(*TextMarshalerStruct)
+	if actual.Enum != value.Enum || !reflect.DeepEqual(actual.Enums, value.Enums) ||
+		!actual.IP.Equal(value.IP) || actual.Big.Cmp(&value.Big) != 0 ||
+		actual.BigPtr.Cmp(value.BigPtr) != 0 || actual.NilBig != nil {
+		t.Errorf("Expected %v but got %v", describe.D(value), describe.D(actual))
+	}
+
+	assertIterateBuild(t, []TextEnum{TextEnumOne, TextEnumZero})
+	assertIterateBuild(t, TextEnumOne)
+}
+
+func TestBuildTextUnmarshalerFail(t *testing.T) {
+	builder := NewBuilderFor(TextMarshalerStruct{})
+	err := IterateObject(map[string]interface{}{"Enum": "two"}, false, builder)
+	if _, ok := err.(*BuildError); !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+	}
+}
+
+// An opaque binary type with unexported fields
+type BinaryKey struct {
+	id    uint16
+	check byte
+}
+
+func (this BinaryKey) MarshalBinary() ([]byte, error) {
+	return []byte{byte(this.id >> 8), byte(this.id), this.check}, nil
+}
+
+func (this *BinaryKey) UnmarshalBinary(data []byte) error {
+	if len(data) != 3 {
+		return fmt.Errorf("Expected 3 bytes but got %v", len(data))
+	}
+	this.id = uint16(data[0])<<8 | uint16(data[1])
+	this.check = data[2]
+	return nil
+}
+
+// Implements both, so it's treated as text
+type TextAndBinary struct {
+	value string
+}
+
+func (this TextAndBinary) MarshalText() ([]byte, error) {
+	return []byte(this.value), nil
+}
+
+func (this *TextAndBinary) UnmarshalText(text []byte) error {
+	this.value = string(text)
+	return nil
+}
+
+func (this TextAndBinary) MarshalBinary() ([]byte, error) {
+	return nil, fmt.Errorf("MarshalBinary should not be called")
+}
+
+func (this *TextAndBinary) UnmarshalBinary(data []byte) error {
+	return fmt.Errorf("UnmarshalBinary should not be called")
+}
+
+type BinaryMarshalerStruct struct {
+	Key   BinaryKey
+	Keys  []BinaryKey
+	Ptr   *BinaryKey
+	Both  TextAndBinary
+	Bytes []byte
+}
+
+func TestIterateBinaryMarshaler(t *testing.T) {
+	assertIteratesAs(t, BinaryMarshalerStruct{
+		Key:  BinaryKey{id: 0x102, check: 3},
+		Keys: []BinaryKey{{id: 4, check: 5}},
+		Both: TextAndBinary{value: "x"},
+	}, map[string]interface{}{
+		"Key":   []byte{1, 2, 3},
+		"Keys":  []interface{}{[]byte{0, 4, 5}},
+		"Ptr":   nil,
+		"Both":  "x",
+		"Bytes": []byte(nil),
+	})
+}
+
+func TestRoundtripBinaryMarshaler(t *testing.T) {
+	value := BinaryMarshalerStruct{
+		Key:   BinaryKey{id: 1000, check: 1},
+		Keys:  []BinaryKey{{id: 1, check: 2}, {id: 3, check: 4}},
+		Ptr:   &BinaryKey{id: 5, check: 6},
+		Both:  TextAndBinary{value: "y"},
+		Bytes: []byte{7},
+	}
+	builder := NewBuilderFor(value)
+	if err := IterateObject(value, false, builder); err != nil {
+		t.Fatal(err)
+	}
+	if actual := builder.GetBuiltObject(); !reflect.DeepEqual(&value, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(value), describe.D(actual))
+	}
+
+	builder = NewBuilderFor(BinaryMarshalerStruct{})
+	err := IterateObject(map[string]interface{}{"Key": []byte{1}}, false, builder)
+	if _, ok := err.(*BuildError); !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+	}
+}
+
+// Iterates as a list of its two values, with the values as a nested list if
+// they're numbers.
+type SelfPair struct {
+	first  string
+	second string
+}
+
+func (this SelfPair) IterateSelf(callbacks ObjectIteratorCallbacks) error {
+	if err := callbacks.OnListBegin(); err != nil {
+		return err
+	}
+	if first, err := strconv.Atoi(this.first); err == nil {
+		second, _ := strconv.Atoi(this.second)
+		callbacks.OnListBegin()
+		callbacks.OnInt(int64(first))
+		callbacks.OnInt(int64(second))
+		callbacks.OnContainerEnd()
+	} else {
+		callbacks.OnString(this.first)
+		callbacks.OnString(this.second)
+	}
+	return callbacks.OnContainerEnd()
+}
+
+func (this *SelfPair) NewSelfBuilder() ObjectIteratorCallbacks {
+	return &selfPairBuilder{pair: this}
+}
+
+type selfPairBuilder struct {
+	ObjectIteratorCallbacks // Unused events panic
+	pair                    *SelfPair
+	values                  []string
+}
+
+func (this *selfPairBuilder) OnListBegin() error {
+	return nil
+}
+
+func (this *selfPairBuilder) OnString(value string) error {
+	this.values = append(this.values, value)
+	return nil
+}
+
+func (this *selfPairBuilder) OnInt(value int64) error {
+	return this.OnString(strconv.FormatInt(value, 10))
+}
+
+func (this *selfPairBuilder) OnContainerEnd() error {
+	if len(this.values) == 2 {
+		this.pair.first, this.pair.second = this.values[0], this.values[1]
+	}
+	if len(this.values) > 2 {
+		return fmt.Errorf("Too many values for a pair")
+	}
+	return nil
+}
+
+type SelfStruct struct {
+	Pair  SelfPair
+	Pairs []SelfPair
+	Ptr   *SelfPair
+	Any   interface{}
+}
+
+func TestIterateSelfIterator(t *testing.T) {
+	assertIteratesAs(t, SelfStruct{
+		Pair:  SelfPair{"a", "b"},
+		Pairs: []SelfPair{{"1", "2"}},
+	}, map[string]interface{}{
+		"Pair":  []interface{}{"a", "b"},
+		"Pairs": []interface{}{[]interface{}{[]interface{}{1, 2}}},
+		"Ptr":   nil,
+		"Any":   nil,
+	})
+}
+
+func TestRoundtripSelfIterator(t *testing.T) {
+	value := &SelfStruct{
+		Pair:  SelfPair{"a", "b"},
+		Pairs: []SelfPair{{"1", "2"}, {"c", "d"}},
+		Ptr:   &SelfPair{"3", "4"},
+		Any:   SelfPair{"e", "f"},
+	}
+	builder := NewBuilderFor(value)
+	options := &IteratorOptions{EmitTypeHints: true}
+	if err := IterateObjectWithOptions(value, options, builder); err != nil {
+		t.Fatal(err)
+	}
+	if actual := builder.GetBuiltObject(); !reflect.DeepEqual(value, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(value), describe.D(actual))
+	}
+
+	pair := SelfPair{"5", "6"}
+	builder = NewBuilderFor(pair)
+	if err := IterateObject(pair, false, builder); err != nil {
+		t.Fatal(err)
+	}
+	if actual := builder.GetBuiltObject(); !reflect.DeepEqual(&pair, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(pair), describe.D(actual))
+	}
+}
+
+func TestBuildSelfBuilderFail(t *testing.T) {
+	builder := NewBuilderFor(SelfStruct{})
+	err := IterateObject(map[string]interface{}{"Pair": []string{"a", "b", "c"}}, false, builder)
+	if _, ok := err.(*BuildError); !ok {
+		t.Errorf("Expected a *BuildError but got %v", err)
+	}
+}
+
+// Cancels the context after a number of values
+type cancellingCallbacks struct {
+	*RootBuilder
+	cancel    context.CancelFunc
+	remaining int
+	received  int
+}
+
+func (this *cancellingCallbacks) OnInt(value int64) error {
+	this.received++
+	this.remaining--
+	if this.remaining == 0 {
+		this.cancel()
+	}
+	return this.RootBuilder.OnInt(value)
+}
+
+func TestIterateContext(t *testing.T) {
+	value := map[string][]int{"a": make([]int, 1000), "b": make([]int, 1000)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	callbacks := &cancellingCallbacks{RootBuilder: NewBuilderFor(value), cancel: cancel, remaining: 10}
+	if err := IterateObjectContext(ctx, value, false, callbacks); err != context.Canceled {
+		t.Errorf("Expected %v but got %v", context.Canceled, err)
+	}
+	if callbacks.received >= contextCheckInterval {
+		t.Errorf("Expected iteration to stop within %v events but got %v", contextCheckInterval, callbacks.received)
+	}
+
+	// Checked at the beginning of each container
+	ctx, cancel = context.WithCancel(context.Background())
+	list := [][]int{{1}, {2}, {3}}
+	callbacks = &cancellingCallbacks{RootBuilder: NewBuilderFor(list), cancel: cancel, remaining: 1}
+	if err := IterateObjectContext(ctx, list, false, callbacks); err != context.Canceled {
+		t.Errorf("Expected %v but got %v", context.Canceled, err)
+	}
+	if callbacks.received != 1 {
+		t.Errorf("Expected iteration to stop after 1 value but got %v", callbacks.received)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := IterateObjectContext(ctx, value, false, NewBuilderFor(value)); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+
+	builder := NewBuilderFor(value)
+	if err := IterateObjectContext(context.Background(), value, false, builder); err != nil {
+		t.Error(err)
+	}
+	if actual := builder.GetBuiltObject(); !reflect.DeepEqual(value, actual) {
+		t.Errorf("Expected %v but got %v", describe.D(value), describe.D(actual))
+	}
+}
+
 func TestRoundtripNil(t *testing.T) {
-	// TODO
-	// assertIterateBuild(t, []interface{}{nil})
+	assertIterateBuild(t, []interface{}{nil})
 	assertIterateBuild(t, map[interface{}]interface{}{1: nil})
 	assertIterateBuild(t, PointerStruct{})
+
+	var intf interface{} = 1
+	builder := NewBuilderFor(&intf)
+	if err := IterateObject(nil, false, builder); err != nil {
+		t.Error(err)
+	}
+	if actual := builder.GetBuiltObject(); actual != nil {
+		t.Errorf("Expected nil but got %v", describe.D(actual))
+	}
 }
 
 func TestRoundtripTime(t *testing.T) {
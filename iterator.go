@@ -1,6 +1,7 @@
 package reconstruct
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"reflect"
@@ -28,6 +29,20 @@ type ObjectIteratorCallbacks interface {
 	OnReference(id interface{}) error
 }
 
+// TypeHintCallbacks can be implemented by ObjectIteratorCallbacks to receive
+// the dynamic type of each value held by an interface, just before the value
+// itself. Type hints are only emitted if IteratorOptions.EmitTypeHints is set.
+type TypeHintCallbacks interface {
+	OnTypeHint(t reflect.Type) error
+}
+
+// SelfIterator can be implemented by a type to take full control of how it's
+// iterated. IterateSelf must produce exactly one object, which may be a
+// container of any depth.
+type SelfIterator interface {
+	IterateSelf(callbacks ObjectIteratorCallbacks) error
+}
+
 // Iterate over an object (recursively), calling the callbacks as data is
 // encountered. If useReferences is true, it will also look for duplicate
 // pointers to data, generating marker and reference events rather than walking
@@ -37,6 +52,21 @@ func IterateObject(value interface{}, useReferences bool, callbacks ObjectIterat
 	return iter.Iterate(value)
 }
 
+// IterateObjectContext iterates like IterateObject, but stops and returns
+// ctx.Err() once ctx is cancelled or its deadline passes.
+func IterateObjectContext(ctx context.Context, value interface{}, useReferences bool, callbacks ObjectIteratorCallbacks) error {
+	iter := NewRootObjectIterator(useReferences, callbacks)
+	return iter.IterateContext(ctx, value)
+}
+
+// IterateObjectWithOptions iterates over an object (recursively), calling the
+// callbacks as data is encountered, configured by options (nil means use
+// defaults).
+func IterateObjectWithOptions(value interface{}, options *IteratorOptions, callbacks ObjectIteratorCallbacks) error {
+	iter := NewRootObjectIteratorWithOptions(options, callbacks)
+	return iter.Iterate(value)
+}
+
 // ObjectIterator iterates through a value, calling callback methods as it goes.
 type ObjectIterator interface {
 	// Iterate iterates over a value, potentially calling other iterators as
@@ -86,6 +116,20 @@ func init() {
 }
 
 func generateIteratorForType(t reflect.Type) ObjectIterator {
+	if iterator := getCustomIterator(t); iterator != nil {
+		return iterator
+	}
+	if isMarshalerType(t, selfIteratorType) {
+		return newSelfIterator()
+	}
+	// Text takes precedence over binary if a type supports both
+	if isMarshalerType(t, textMarshalerType) {
+		return newTextMarshalerIterator()
+	}
+	if isMarshalerType(t, binaryMarshalerType) {
+		return newBinaryMarshalerIterator()
+	}
+
 	switch t.Kind() {
 	case reflect.Bool:
 		return newBoolIterator()
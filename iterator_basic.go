@@ -1,6 +1,7 @@
 package reconstruct
 
 import (
+	"encoding"
 	"net/url"
 	"reflect"
 	"time"
@@ -216,3 +217,59 @@ func (this *stringIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIt
 func (this *stringIterator) Iterate(v reflect.Value) error {
 	return this.root.callbacks.OnString(v.String())
 }
+
+// -------------
+// TextMarshaler
+// -------------
+
+type textMarshalerIterator struct {
+	root *RootObjectIterator
+}
+
+func newTextMarshalerIterator() ObjectIterator {
+	return &textMarshalerIterator{}
+}
+
+func (this *textMarshalerIterator) PostCacheInitIterator() {
+}
+
+func (this *textMarshalerIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
+	return &textMarshalerIterator{root: root}
+}
+
+func (this *textMarshalerIterator) Iterate(v reflect.Value) error {
+	marshaler := marshalerInterface(v, textMarshalerType).(encoding.TextMarshaler)
+	text, err := marshaler.MarshalText()
+	if err != nil {
+		return this.root.callbacks.wrapError(err)
+	}
+	return this.root.callbacks.OnString(string(text))
+}
+
+// ---------------
+// BinaryMarshaler
+// ---------------
+
+type binaryMarshalerIterator struct {
+	root *RootObjectIterator
+}
+
+func newBinaryMarshalerIterator() ObjectIterator {
+	return &binaryMarshalerIterator{}
+}
+
+func (this *binaryMarshalerIterator) PostCacheInitIterator() {
+}
+
+func (this *binaryMarshalerIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
+	return &binaryMarshalerIterator{root: root}
+}
+
+func (this *binaryMarshalerIterator) Iterate(v reflect.Value) error {
+	marshaler := marshalerInterface(v, binaryMarshalerType).(encoding.BinaryMarshaler)
+	data, err := marshaler.MarshalBinary()
+	if err != nil {
+		return this.root.callbacks.wrapError(err)
+	}
+	return this.root.callbacks.OnBytes(data)
+}
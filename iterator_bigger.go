@@ -30,6 +30,19 @@ func (this *interfaceIterator) Iterate(v reflect.Value) error {
 		return this.root.callbacks.OnNil()
 	}
 	elem := v.Elem()
+	if registry := this.root.options.TypeRegistry; registry != nil {
+		if name, ok := registry.NameForType(elem.Type()); ok {
+			// Consumed by the struct iterator. The pending name must not
+			// outlive this value (a reference or nil pointer won't consume it).
+			this.root.pendingTypeName = name
+			defer func() { this.root.pendingTypeName = "" }()
+		}
+	}
+	if this.root.options.EmitTypeHints {
+		if err := this.root.callbacks.OnTypeHint(elem.Type()); err != nil {
+			return err
+		}
+	}
 	iter := getIteratorForType(elem.Type()).CloneFromTemplate(this.root)
 	return iter.Iterate(elem)
 }
@@ -39,9 +52,10 @@ func (this *interfaceIterator) Iterate(v reflect.Value) error {
 // -------
 
 type pointerIterator struct {
-	srcType  reflect.Type
-	elemIter ObjectIterator
-	root     *RootObjectIterator
+	srcType      reflect.Type
+	elemTemplate ObjectIterator
+	elemIter     ObjectIterator
+	root         *RootObjectIterator
 }
 
 func newPointerIterator(srcType reflect.Type) ObjectIterator {
@@ -49,25 +63,32 @@ func newPointerIterator(srcType reflect.Type) ObjectIterator {
 }
 
 func (this *pointerIterator) PostCacheInitIterator() {
-	this.elemIter = getIteratorForType(this.srcType.Elem())
+	this.elemTemplate = getIteratorForType(this.srcType.Elem())
 }
 
 func (this *pointerIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
 	return &pointerIterator{
-		srcType:  this.srcType,
-		root:     root,
-		elemIter: this.elemIter.CloneFromTemplate(root),
+		srcType:      this.srcType,
+		root:         root,
+		elemTemplate: this.elemTemplate,
 	}
 }
 
+func (this *pointerIterator) getElemIter() ObjectIterator {
+	if this.elemIter == nil {
+		this.elemIter = this.elemTemplate.CloneFromTemplate(this.root)
+	}
+	return this.elemIter
+}
+
 func (this *pointerIterator) Iterate(v reflect.Value) error {
 	if v.IsNil() {
 		return this.root.callbacks.OnNil()
 	}
-	if this.root.addReference(v) {
-		return nil
+	if didAddReference, err := this.root.addReference(v); didAddReference || err != nil {
+		return err
 	}
-	return this.elemIter.Iterate(v.Elem())
+	return this.getElemIter().Iterate(v.Elem())
 }
 
 // -----------
@@ -130,9 +151,10 @@ func (this *complexIterator) Iterate(v reflect.Value) error {
 // -----
 
 type sliceIterator struct {
-	srcType  reflect.Type
-	elemIter ObjectIterator
-	root     *RootObjectIterator
+	srcType      reflect.Type
+	elemTemplate ObjectIterator
+	elemIter     ObjectIterator
+	root         *RootObjectIterator
 }
 
 func newSliceIterator(srcType reflect.Type) ObjectIterator {
@@ -142,23 +164,30 @@ func newSliceIterator(srcType reflect.Type) ObjectIterator {
 }
 
 func (this *sliceIterator) PostCacheInitIterator() {
-	this.elemIter = getIteratorForType(this.srcType.Elem())
+	this.elemTemplate = getIteratorForType(this.srcType.Elem())
 }
 
 func (this *sliceIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
 	return &sliceIterator{
-		srcType:  this.srcType,
-		root:     root,
-		elemIter: this.elemIter.CloneFromTemplate(root),
+		srcType:      this.srcType,
+		root:         root,
+		elemTemplate: this.elemTemplate,
 	}
 }
 
+func (this *sliceIterator) getElemIter() ObjectIterator {
+	if this.elemIter == nil {
+		this.elemIter = this.elemTemplate.CloneFromTemplate(this.root)
+	}
+	return this.elemIter
+}
+
 func (this *sliceIterator) Iterate(v reflect.Value) (err error) {
 	if v.IsNil() {
 		return this.root.callbacks.OnNil()
 	}
-	if this.root.addReference(v) {
-		return nil
+	if didAddReference, err := this.root.addReference(v); didAddReference || err != nil {
+		return err
 	}
 
 	if err = this.root.callbacks.OnListBegin(); err != nil {
@@ -166,7 +195,7 @@ func (this *sliceIterator) Iterate(v reflect.Value) (err error) {
 	}
 	length := v.Len()
 	for i := 0; i < length; i++ {
-		if err = this.elemIter.Iterate(v.Index(i)); err != nil {
+		if err = this.getElemIter().Iterate(v.Index(i)); err != nil {
 			return
 		}
 	}
@@ -178,9 +207,10 @@ func (this *sliceIterator) Iterate(v reflect.Value) (err error) {
 // -----
 
 type arrayIterator struct {
-	srcType  reflect.Type
-	elemIter ObjectIterator
-	root     *RootObjectIterator
+	srcType      reflect.Type
+	elemTemplate ObjectIterator
+	elemIter     ObjectIterator
+	root         *RootObjectIterator
 }
 
 func newArrayIterator(srcType reflect.Type) ObjectIterator {
@@ -190,15 +220,22 @@ func newArrayIterator(srcType reflect.Type) ObjectIterator {
 }
 
 func (this *arrayIterator) PostCacheInitIterator() {
-	this.elemIter = getIteratorForType(this.srcType.Elem())
+	this.elemTemplate = getIteratorForType(this.srcType.Elem())
 }
 
 func (this *arrayIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
 	return &arrayIterator{
-		srcType:  this.srcType,
-		root:     root,
-		elemIter: this.elemIter.CloneFromTemplate(root),
+		srcType:      this.srcType,
+		root:         root,
+		elemTemplate: this.elemTemplate,
+	}
+}
+
+func (this *arrayIterator) getElemIter() ObjectIterator {
+	if this.elemIter == nil {
+		this.elemIter = this.elemTemplate.CloneFromTemplate(this.root)
 	}
+	return this.elemIter
 }
 
 func (this *arrayIterator) Iterate(v reflect.Value) (err error) {
@@ -207,7 +244,7 @@ func (this *arrayIterator) Iterate(v reflect.Value) (err error) {
 	}
 	length := v.Len()
 	for i := 0; i < length; i++ {
-		if err = this.elemIter.Iterate(v.Index(i)); err != nil {
+		if err = this.getElemIter().Iterate(v.Index(i)); err != nil {
 			return
 		}
 	}
@@ -219,10 +256,12 @@ func (this *arrayIterator) Iterate(v reflect.Value) (err error) {
 // ---
 
 type mapIterator struct {
-	srcType   reflect.Type
-	keyIter   ObjectIterator
-	valueIter ObjectIterator
-	root      *RootObjectIterator
+	srcType       reflect.Type
+	keyTemplate   ObjectIterator
+	valueTemplate ObjectIterator
+	keyIter       ObjectIterator
+	valueIter     ObjectIterator
+	root          *RootObjectIterator
 }
 
 func newMapIterator(srcType reflect.Type) ObjectIterator {
@@ -232,16 +271,16 @@ func newMapIterator(srcType reflect.Type) ObjectIterator {
 }
 
 func (this *mapIterator) PostCacheInitIterator() {
-	this.keyIter = getIteratorForType(this.srcType.Key())
-	this.valueIter = getIteratorForType(this.srcType.Elem())
+	this.keyTemplate = getIteratorForType(this.srcType.Key())
+	this.valueTemplate = getIteratorForType(this.srcType.Elem())
 }
 
 func (this *mapIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
 	return &mapIterator{
-		srcType:   this.srcType,
-		keyIter:   this.keyIter.CloneFromTemplate(root),
-		valueIter: this.valueIter.CloneFromTemplate(root),
-		root:      root,
+		srcType:       this.srcType,
+		keyTemplate:   this.keyTemplate,
+		valueTemplate: this.valueTemplate,
+		root:          root,
 	}
 }
 
@@ -249,14 +288,19 @@ func (this *mapIterator) Iterate(v reflect.Value) (err error) {
 	if v.IsNil() {
 		return this.root.callbacks.OnNil()
 	}
-	if this.root.addReference(v) {
-		return nil
+	if didAddReference, err := this.root.addReference(v); didAddReference || err != nil {
+		return err
 	}
 
 	if err = this.root.callbacks.OnMapBegin(); err != nil {
 		return
 	}
 
+	if this.keyIter == nil {
+		this.keyIter = this.keyTemplate.CloneFromTemplate(this.root)
+		this.valueIter = this.valueTemplate.CloneFromTemplate(this.root)
+	}
+
 	iter := mapRange(v)
 	for iter.Next() {
 		if err = this.keyIter.Iterate(iter.Key()); err != nil {
@@ -275,23 +319,26 @@ func (this *mapIterator) Iterate(v reflect.Value) (err error) {
 // ------
 
 type structIteratorField struct {
-	Name     string
-	Index    int
-	Iterator ObjectIterator
+	Name      string
+	Index     []int
+	OmitEmpty bool
+	Iterator  ObjectIterator
 }
 
-func newStructIteratorField(name string, index int, iterator ObjectIterator) *structIteratorField {
-	return &structIteratorField{
-		Name:     name,
-		Index:    index,
-		Iterator: iterator,
-	}
+type structIteratorFields struct {
+	fields []*structIteratorField
+	names  map[string]bool
+	// Entries are iterated inline (nil if there's no remain field)
+	remain *structIteratorField
 }
 
 type structIterator struct {
-	srcType        reflect.Type
-	fieldIterators []*structIteratorField
-	root           *RootObjectIterator
+	srcType          reflect.Type
+	fieldsByResolver resolverCache
+	fieldTemplates   *structIteratorFields
+	fieldIterators   []ObjectIterator
+	remainIterator   ObjectIterator
+	root             *RootObjectIterator
 }
 
 func newStructIterator(srcType reflect.Type) ObjectIterator {
@@ -301,29 +348,57 @@ func newStructIterator(srcType reflect.Type) ObjectIterator {
 }
 
 func (this *structIterator) PostCacheInitIterator() {
-	for i := 0; i < this.srcType.NumField(); i++ {
-		field := this.srcType.Field(i)
-		if isFieldExported(field.Name) {
-			iterator := &structIteratorField{
-				Name:     field.Name,
-				Index:    i,
-				Iterator: getIteratorForType(field.Type),
-			}
-			this.fieldIterators = append(this.fieldIterators, iterator)
+	this.getFieldTemplates(ReconstructTagResolver)
+}
+
+// Get the field templates as named by resolver, generating them on first use.
+func (this *structIterator) getFieldTemplates(resolver FieldNameResolver) *structIteratorFields {
+	return this.fieldsByResolver.get(resolver, func() interface{} {
+		return this.generateFieldTemplates(resolver)
+	}).(*structIteratorFields)
+}
+
+func (this *structIterator) generateFieldTemplates(resolver FieldNameResolver) *structIteratorFields {
+	fields, remain := getStructFields(this.srcType, resolver)
+	templates := &structIteratorFields{
+		names: make(map[string]bool),
+	}
+	for _, field := range fields {
+		templates.fields = append(templates.fields, &structIteratorField{
+			Name:      field.Name,
+			Index:     field.Index,
+			OmitEmpty: field.OmitEmpty,
+			Iterator:  getIteratorForType(field.Type),
+		})
+		templates.names[field.Name] = true
+	}
+	if remain != nil {
+		templates.remain = &structIteratorField{
+			Name:     remain.Name,
+			Index:    remain.Index,
+			Iterator: getIteratorForType(remain.Type.Elem()),
 		}
 	}
+	return templates
 }
 
 func (this *structIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
-	that := &structIterator{
-		srcType: this.srcType,
-		root:    root,
+	fieldTemplates := this.getFieldTemplates(root.options.FieldNameResolver)
+	return &structIterator{
+		srcType:        this.srcType,
+		fieldTemplates: fieldTemplates,
+		fieldIterators: make([]ObjectIterator, len(fieldTemplates.fields)),
+		root:           root,
 	}
-	that.fieldIterators = make([]*structIteratorField, 0, len(this.fieldIterators))
-	for _, iter := range this.fieldIterators {
-		that.fieldIterators = append(that.fieldIterators, newStructIteratorField(iter.Name, iter.Index, iter.Iterator.CloneFromTemplate(root)))
+}
+
+func (this *structIterator) getFieldIterator(index int) ObjectIterator {
+	iterator := this.fieldIterators[index]
+	if iterator == nil {
+		iterator = this.fieldTemplates.fields[index].Iterator.CloneFromTemplate(this.root)
+		this.fieldIterators[index] = iterator
 	}
-	return that
+	return iterator
 }
 
 func (this *structIterator) Iterate(v reflect.Value) (err error) {
@@ -331,10 +406,90 @@ func (this *structIterator) Iterate(v reflect.Value) (err error) {
 		return
 	}
 
-	for _, iter := range this.fieldIterators {
-		this.root.callbacks.OnString(iter.Name)
-		iter.Iterator.Iterate(v.Field(iter.Index))
+	if typeName := this.root.takePendingTypeName(); typeName != "" {
+		if err = this.root.callbacks.OnString(this.root.options.TypeKey); err != nil {
+			return
+		}
+		if err = this.root.callbacks.OnString(typeName); err != nil {
+			return
+		}
+	}
+
+	for i, field := range this.fieldTemplates.fields {
+		fieldValue := fieldByIndex(v, field.Index)
+		if !fieldValue.IsValid() || field.OmitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+		if err = this.root.callbacks.OnString(field.Name); err != nil {
+			return
+		}
+		if err = this.getFieldIterator(i).Iterate(fieldValue); err != nil {
+			return
+		}
+	}
+
+	if err = this.iterateRemain(v); err != nil {
+		return
 	}
 
 	return this.root.callbacks.OnContainerEnd()
 }
+
+// Iterate the entries of the remain field as if they were regular fields.
+// Entries that would clash with a regular field are skipped.
+func (this *structIterator) iterateRemain(v reflect.Value) (err error) {
+	remain := this.fieldTemplates.remain
+	if remain == nil {
+		return
+	}
+	remainMap := fieldByIndex(v, remain.Index)
+	if !remainMap.IsValid() || remainMap.IsNil() {
+		return
+	}
+
+	if this.remainIterator == nil {
+		this.remainIterator = remain.Iterator.CloneFromTemplate(this.root)
+	}
+
+	iter := mapRange(remainMap)
+	for iter.Next() {
+		key := iter.Key().String()
+		if this.fieldTemplates.names[key] {
+			continue
+		}
+		if err = this.root.callbacks.OnString(key); err != nil {
+			return
+		}
+		if err = this.remainIterator.Iterate(iter.Value()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ------------
+// SelfIterator
+// ------------
+
+type selfIterator struct {
+	root *RootObjectIterator
+}
+
+func newSelfIterator() ObjectIterator {
+	return &selfIterator{}
+}
+
+func (this *selfIterator) PostCacheInitIterator() {
+}
+
+func (this *selfIterator) CloneFromTemplate(root *RootObjectIterator) ObjectIterator {
+	return &selfIterator{root: root}
+}
+
+func (this *selfIterator) Iterate(v reflect.Value) error {
+	iterator := marshalerInterface(v, selfIteratorType).(SelfIterator)
+	if err := iterator.IterateSelf(this.root.callbacks); err != nil {
+		return this.root.callbacks.wrapError(err)
+	}
+	return nil
+}
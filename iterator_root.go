@@ -1,7 +1,11 @@
 package reconstruct
 
 import (
+	"context"
+	"fmt"
+	"net/url"
 	"reflect"
+	"time"
 
 	"github.com/kstenerud/go-duplicates"
 )
@@ -12,12 +16,25 @@ func NewRootObjectIterator(useReferences bool, callbacks ObjectIteratorCallbacks
 	return this
 }
 
+func NewRootObjectIteratorWithOptions(options *IteratorOptions, callbacks ObjectIteratorCallbacks) *RootObjectIterator {
+	this := new(RootObjectIterator)
+	this.InitWithOptions(options, callbacks)
+	return this
+}
+
 func (this *RootObjectIterator) Init(useReferences bool, callbacks ObjectIteratorCallbacks) {
-	this.useReferences = useReferences
-	this.callbacks = callbacks
+	this.InitWithOptions(&IteratorOptions{UseReferences: useReferences}, callbacks)
+}
+
+func (this *RootObjectIterator) InitWithOptions(options *IteratorOptions, callbacks ObjectIteratorCallbacks) {
+	this.options = options.withDefaultsApplied()
+	this.callbacks = &trackingCallbacks{callbacks: callbacks}
 }
 
 func (this *RootObjectIterator) Iterate(value interface{}) error {
+	if value == nil {
+		return this.callbacks.OnNil()
+	}
 	this.findReferences(value)
 	rv := reflect.ValueOf(value)
 	iterator := getIteratorForType(rv.Type())
@@ -25,25 +42,39 @@ func (this *RootObjectIterator) Iterate(value interface{}) error {
 	return iterator.Iterate(rv)
 }
 
+// IterateContext iterates like Iterate, but stops and returns ctx.Err() once
+// ctx is done. The context is checked at the beginning of every container, and
+// after every contextCheckInterval events.
+func (this *RootObjectIterator) IterateContext(ctx context.Context, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	this.callbacks.ctx = ctx
+	this.callbacks.eventCount = 0
+	defer func() { this.callbacks.ctx = nil }()
+	return this.Iterate(value)
+}
+
 // Iterates depth-first recursively through an object, notifying callbacks as it
 // encounters data.
 type RootObjectIterator struct {
 	foundReferences map[duplicates.TypedPointer]bool
 	namedReferences map[duplicates.TypedPointer]uint32
 	nextMarkerName  uint32
-	callbacks       ObjectIteratorCallbacks
-	useReferences   bool
+	callbacks       *trackingCallbacks
+	options         IteratorOptions
+	pendingTypeName string
 }
 
 func (this *RootObjectIterator) findReferences(value interface{}) {
-	if this.useReferences {
+	if this.options.UseReferences {
 		this.foundReferences = duplicates.FindDuplicatePointers(value)
 		this.namedReferences = make(map[duplicates.TypedPointer]uint32)
 	}
 }
 
-func (this *RootObjectIterator) addReference(v reflect.Value) (didAddReferenceObject bool) {
-	if this.useReferences {
+func (this *RootObjectIterator) addReference(v reflect.Value) (didAddReferenceObject bool, err error) {
+	if this.options.UseReferences {
 		ptr := duplicates.TypedPointerOfRV(v)
 		if this.foundReferences[ptr] {
 			var name uint32
@@ -52,13 +83,162 @@ func (this *RootObjectIterator) addReference(v reflect.Value) (didAddReferenceOb
 				name = this.nextMarkerName
 				this.nextMarkerName++
 				this.namedReferences[ptr] = name
-				this.callbacks.OnMarker(uint64(name))
-				return false
+				return false, this.callbacks.OnMarker(uint64(name))
 			} else {
-				this.callbacks.OnReference(uint64(name))
-				return true
+				return true, this.callbacks.OnReference(uint64(name))
 			}
 		}
 	}
-	return false
+	return false, nil
+}
+
+// Take the type name (if any) that the next struct must emit as its
+// discriminator.
+func (this *RootObjectIterator) takePendingTypeName() (name string) {
+	name = this.pendingTypeName
+	this.pendingTypeName = ""
+	return
+}
+
+// IterateError is returned by RootObjectIterator when a callback fails,
+// recording where in the object the failure occurred.
+type IterateError struct {
+	// Where in the object the error occurred
+	Path []PathElement
+	// The error returned by the callback
+	Err error
+}
+
+// PathString returns the error's path as a JSON Pointer style string.
+func (this *IterateError) PathString() string {
+	return PathToString(this.Path)
+}
+
+func (this *IterateError) Error() string {
+	return fmt.Sprintf("Error iterating at %v: %v", this.PathString(), this.Err)
+}
+
+func (this *IterateError) Unwrap() error {
+	return this.Err
+}
+
+// How many events may pass between context checks
+const contextCheckInterval = 100
+
+// Forwards events to the user's callbacks, keeping track of the current path
+// so that it can be attached to any errors the callbacks return. Also checks
+// the context (if any) to see if iteration should stop.
+type trackingCallbacks struct {
+	callbacks  ObjectIteratorCallbacks
+	path       pathTracker
+	ctx        context.Context
+	eventCount int
+}
+
+func (this *trackingCallbacks) checkContext(isContainer bool) error {
+	if this.ctx == nil {
+		return nil
+	}
+	this.eventCount++
+	if isContainer || this.eventCount%contextCheckInterval == 0 {
+		return this.ctx.Err()
+	}
+	return nil
+}
+
+func (this *trackingCallbacks) wrapError(err error) error {
+	switch err.(type) {
+	case *BuildError:
+		// A RootBuilder tracks the same path as we do.
+		return err
+	case *IterateError:
+		return err
+	}
+	return &IterateError{
+		Path: this.path.path(),
+		Err:  err,
+	}
+}
+
+func (this *trackingCallbacks) onValue(value interface{}, err error) error {
+	if err != nil {
+		return this.wrapError(err)
+	}
+	this.path.onValue(value)
+	return this.checkContext(false)
+}
+
+func (this *trackingCallbacks) OnNil() error {
+	return this.onValue(nil, this.callbacks.OnNil())
+}
+func (this *trackingCallbacks) OnBool(value bool) error {
+	return this.onValue(value, this.callbacks.OnBool(value))
+}
+func (this *trackingCallbacks) OnInt(value int64) error {
+	return this.onValue(value, this.callbacks.OnInt(value))
+}
+func (this *trackingCallbacks) OnUint(value uint64) error {
+	return this.onValue(value, this.callbacks.OnUint(value))
+}
+func (this *trackingCallbacks) OnFloat(value float64) error {
+	return this.onValue(value, this.callbacks.OnFloat(value))
+}
+func (this *trackingCallbacks) OnComplex(value complex128) error {
+	return this.onValue(value, this.callbacks.OnComplex(value))
+}
+func (this *trackingCallbacks) OnString(value string) error {
+	return this.onValue(value, this.callbacks.OnString(value))
+}
+func (this *trackingCallbacks) OnBytes(value []byte) error {
+	return this.onValue(value, this.callbacks.OnBytes(value))
+}
+func (this *trackingCallbacks) OnURI(value *url.URL) error {
+	return this.onValue(value, this.callbacks.OnURI(value))
+}
+func (this *trackingCallbacks) OnTime(value time.Time) error {
+	return this.onValue(value, this.callbacks.OnTime(value))
+}
+func (this *trackingCallbacks) OnListBegin() error {
+	if err := this.checkContext(true); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnListBegin(); err != nil {
+		return this.wrapError(err)
+	}
+	this.path.onContainerBegin(false)
+	return nil
+}
+func (this *trackingCallbacks) OnMapBegin() error {
+	if err := this.checkContext(true); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnMapBegin(); err != nil {
+		return this.wrapError(err)
+	}
+	this.path.onContainerBegin(true)
+	return nil
+}
+func (this *trackingCallbacks) OnContainerEnd() error {
+	if err := this.callbacks.OnContainerEnd(); err != nil {
+		return this.wrapError(err)
+	}
+	this.path.onContainerEnd()
+	return nil
+}
+func (this *trackingCallbacks) OnMarker(id interface{}) error {
+	if err := this.callbacks.OnMarker(id); err != nil {
+		return this.wrapError(err)
+	}
+	return nil
+}
+func (this *trackingCallbacks) OnReference(id interface{}) error {
+	return this.onValue(id, this.callbacks.OnReference(id))
+}
+func (this *trackingCallbacks) OnTypeHint(t reflect.Type) error {
+	if hintCallbacks, ok := this.callbacks.(TypeHintCallbacks); ok {
+		if err := hintCallbacks.OnTypeHint(t); err != nil {
+			return this.wrapError(err)
+		}
+	}
+	return nil
 }
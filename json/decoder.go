@@ -0,0 +1,523 @@
+package json
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// The maximum nesting depth of arrays and objects, which guards against
+// running out of stack on hostile input.
+const maxDepth = 10000
+
+// SyntaxError describes malformed JSON.
+type SyntaxError struct {
+	// The byte offset where the error was detected
+	Offset int64
+	Msg    string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("JSON syntax error at offset %v: %v", this.Offset, this.Msg)
+}
+
+// Decode reads a single JSON value from reader into target, which must be a
+// non-nil pointer. Struct fields are named using `json` struct tags. Anything
+// other than whitespace after the value is an error.
+func Decode(reader io.Reader, target interface{}) error {
+	decoder := NewDecoder(reader, nil)
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if b, err := decoder.skipWhitespace(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return decoder.syntaxError("Unexpected %q after top-level value", b)
+	}
+	return nil
+}
+
+// Decoder reads a stream of JSON values, generating events as it goes rather
+// than building an intermediate representation.
+//
+// Numbers are passed to OnInt if they're integers that fit in an int64, to
+// OnUint if they're larger positive integers, and otherwise to OnFloat.
+// Strings (including those representing bytes, URIs and times) are passed to
+// OnString.
+type Decoder struct {
+	reader    *bufio.Reader
+	offset    int64
+	options   reconstruct.BuilderOptions
+	callbacks reconstruct.ObjectIteratorCallbacks
+	buffer    []byte
+	depth     int
+}
+
+// NewDecoder creates a decoder that reads from reader, and builds values using
+// options (nil means use defaults). The FieldNameResolver defaults to
+// JSONTagResolver. ConvertStrings is always enabled so that the encoder's
+// representations of bytes, URIs and times can be decoded, and StringKeyedMaps
+// is always enabled because JSON object keys are always strings.
+func NewDecoder(reader io.Reader, options *reconstruct.BuilderOptions) *Decoder {
+	this := &Decoder{
+		reader: bufio.NewReader(reader),
+	}
+	if options != nil {
+		this.options = *options
+	}
+	if this.options.FieldNameResolver == nil {
+		this.options.FieldNameResolver = reconstruct.JSONTagResolver
+	}
+	this.options.ConvertStrings = true
+	this.options.StringKeyedMaps = true
+	return this
+}
+
+// Decode reads the next JSON value into target, which must be a non-nil
+// pointer. Returns io.EOF if there are no more values.
+func (this *Decoder) Decode(target interface{}) error {
+	dst := reflect.ValueOf(target)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("Cannot decode into %v: Target must be a non-nil pointer", reflect.TypeOf(target))
+	}
+
+	b, err := this.skipWhitespace()
+	if err != nil {
+		return err
+	}
+
+	builder := reconstruct.NewBuilderWithOptions(target, &this.options)
+	this.callbacks = builder
+	this.depth = 0
+	if err := this.decodeValue(b); err != nil {
+		return err
+	}
+	return builder.StoreBuiltObject(target)
+}
+
+// DecodeEvents reads the next JSON value, passing its events to callbacks.
+// Returns io.EOF if there are no more values.
+func (this *Decoder) DecodeEvents(callbacks reconstruct.ObjectIteratorCallbacks) error {
+	this.callbacks = callbacks
+	this.depth = 0
+	b, err := this.skipWhitespace()
+	if err != nil {
+		return err
+	}
+	return this.decodeValue(b)
+}
+
+func (this *Decoder) syntaxError(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Offset: this.offset,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (this *Decoder) readByte() (byte, error) {
+	b, err := this.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	this.offset++
+	return b, nil
+}
+
+// Read a byte that must exist because we're partway through a value.
+func (this *Decoder) readByteInValue() (byte, error) {
+	b, err := this.readByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (this *Decoder) unreadByte() {
+	this.reader.UnreadByte()
+	this.offset--
+}
+
+func (this *Decoder) skipWhitespace() (byte, error) {
+	for {
+		b, err := this.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return b, nil
+		}
+	}
+}
+
+func (this *Decoder) skipWhitespaceInValue() (byte, error) {
+	b, err := this.skipWhitespace()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (this *Decoder) decodeValue(b byte) error {
+	switch {
+	case b == '{':
+		return this.decodeObject()
+	case b == '[':
+		return this.decodeArray()
+	case b == '"':
+		str, err := this.readString()
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnString(str)
+	case b == 't':
+		if err := this.readLiteral("true"); err != nil {
+			return err
+		}
+		return this.callbacks.OnBool(true)
+	case b == 'f':
+		if err := this.readLiteral("false"); err != nil {
+			return err
+		}
+		return this.callbacks.OnBool(false)
+	case b == 'n':
+		if err := this.readLiteral("null"); err != nil {
+			return err
+		}
+		return this.callbacks.OnNil()
+	case b == '-' || (b >= '0' && b <= '9'):
+		return this.decodeNumber(b)
+	default:
+		return this.syntaxError("Unexpected %q", b)
+	}
+}
+
+func (this *Decoder) beginContainer() error {
+	this.depth++
+	if this.depth > maxDepth {
+		return this.syntaxError("Exceeded maximum nesting depth of %v", maxDepth)
+	}
+	return nil
+}
+
+func (this *Decoder) decodeObject() error {
+	if err := this.beginContainer(); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnMapBegin(); err != nil {
+		return err
+	}
+
+	b, err := this.skipWhitespaceInValue()
+	if err != nil {
+		return err
+	}
+	if b != '}' {
+		for {
+			if b != '"' {
+				return this.syntaxError("Expected an object key but got %q", b)
+			}
+			key, err := this.readString()
+			if err != nil {
+				return err
+			}
+			if err := this.callbacks.OnString(key); err != nil {
+				return err
+			}
+			if b, err = this.skipWhitespaceInValue(); err != nil {
+				return err
+			}
+			if b != ':' {
+				return this.syntaxError("Expected ':' but got %q", b)
+			}
+			if b, err = this.skipWhitespaceInValue(); err != nil {
+				return err
+			}
+			if err := this.decodeValue(b); err != nil {
+				return err
+			}
+			if b, err = this.skipWhitespaceInValue(); err != nil {
+				return err
+			}
+			if b == '}' {
+				break
+			}
+			if b != ',' {
+				return this.syntaxError("Expected ',' or '}' but got %q", b)
+			}
+			if b, err = this.skipWhitespaceInValue(); err != nil {
+				return err
+			}
+		}
+	}
+
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeArray() error {
+	if err := this.beginContainer(); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnListBegin(); err != nil {
+		return err
+	}
+
+	b, err := this.skipWhitespaceInValue()
+	if err != nil {
+		return err
+	}
+	if b != ']' {
+		for {
+			if err := this.decodeValue(b); err != nil {
+				return err
+			}
+			if b, err = this.skipWhitespaceInValue(); err != nil {
+				return err
+			}
+			if b == ']' {
+				break
+			}
+			if b != ',' {
+				return this.syntaxError("Expected ',' or ']' but got %q", b)
+			}
+			if b, err = this.skipWhitespaceInValue(); err != nil {
+				return err
+			}
+		}
+	}
+
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+// Read the rest of a literal whose first byte has already been read.
+func (this *Decoder) readLiteral(literal string) error {
+	for i := 1; i < len(literal); i++ {
+		b, err := this.readByteInValue()
+		if err != nil {
+			return err
+		}
+		if b != literal[i] {
+			return this.syntaxError("Expected %v but got %q", literal, b)
+		}
+	}
+	return nil
+}
+
+func isNumberByte(b byte) bool {
+	switch b {
+	case '+', '-', '.', 'e', 'E':
+		return true
+	default:
+		return b >= '0' && b <= '9'
+	}
+}
+
+func (this *Decoder) decodeNumber(first byte) error {
+	this.buffer = append(this.buffer[:0], first)
+	for {
+		b, err := this.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !isNumberByte(b) {
+			this.unreadByte()
+			break
+		}
+		this.buffer = append(this.buffer, b)
+	}
+
+	str := string(this.buffer)
+	isInteger, ok := checkNumber(this.buffer)
+	if !ok {
+		return this.syntaxError("Invalid number %v", str)
+	}
+	if isInteger {
+		if value, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return this.callbacks.OnInt(value)
+		}
+		if value, err := strconv.ParseUint(str, 10, 64); err == nil {
+			return this.callbacks.OnUint(value)
+		}
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return this.syntaxError("Number %v is out of range", str)
+	}
+	return this.callbacks.OnFloat(value)
+}
+
+// Check that number follows the JSON number grammar, and report whether it's
+// an integer (has no fraction or exponent).
+func checkNumber(number []byte) (isInteger bool, ok bool) {
+	i := 0
+	digits := func() bool {
+		start := i
+		for i < len(number) && number[i] >= '0' && number[i] <= '9' {
+			i++
+		}
+		return i > start
+	}
+
+	if i < len(number) && number[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(number) && number[i] == '0':
+		i++
+	case !digits():
+		return false, false
+	}
+	isInteger = true
+	if i < len(number) && number[i] == '.' {
+		i++
+		isInteger = false
+		if !digits() {
+			return false, false
+		}
+	}
+	if i < len(number) && (number[i] == 'e' || number[i] == 'E') {
+		i++
+		isInteger = false
+		if i < len(number) && (number[i] == '+' || number[i] == '-') {
+			i++
+		}
+		if !digits() {
+			return false, false
+		}
+	}
+	return isInteger, i == len(number)
+}
+
+// Read the rest of a string whose opening quote has already been read.
+func (this *Decoder) readString() (string, error) {
+	this.buffer = this.buffer[:0]
+	for {
+		b, err := this.readByteInValue()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case b == '"':
+			if !utf8.Valid(this.buffer) {
+				// Converting to runes replaces invalid bytes with U+FFFD
+				return string([]rune(string(this.buffer))), nil
+			}
+			return string(this.buffer), nil
+		case b == '\\':
+			if err := this.readEscape(); err != nil {
+				return "", err
+			}
+		case b < 0x20:
+			return "", this.syntaxError("Control character %q in string", b)
+		default:
+			this.buffer = append(this.buffer, b)
+		}
+	}
+}
+
+func (this *Decoder) readEscape() error {
+	b, err := this.readByteInValue()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case '"', '\\', '/':
+		this.buffer = append(this.buffer, b)
+	case 'b':
+		this.buffer = append(this.buffer, '\b')
+	case 'f':
+		this.buffer = append(this.buffer, '\f')
+	case 'n':
+		this.buffer = append(this.buffer, '\n')
+	case 'r':
+		this.buffer = append(this.buffer, '\r')
+	case 't':
+		this.buffer = append(this.buffer, '\t')
+	case 'u':
+		r, err := this.readHexRune()
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) {
+			r, err = this.readSurrogatePair(r)
+			if err != nil {
+				return err
+			}
+		}
+		var encoded [utf8.UTFMax]byte
+		this.buffer = append(this.buffer, encoded[:utf8.EncodeRune(encoded[:], r)]...)
+	default:
+		return this.syntaxError("Invalid escape sequence \\%c", b)
+	}
+	return nil
+}
+
+// Read the rest of a surrogate pair that begins with the surrogate r, returning
+// the character that it encodes. Surrogates that aren't part of a pair decode
+// as U+FFFD: those before the last are added to the buffer here, and the last
+// is returned.
+func (this *Decoder) readSurrogatePair(r rune) (rune, error) {
+	const minLowSurrogate = 0xdc00
+	for {
+		if r >= minLowSurrogate {
+			return utf8.RuneError, nil
+		}
+		next, err := this.reader.Peek(2)
+		if err != nil || next[0] != '\\' || next[1] != 'u' {
+			return utf8.RuneError, nil
+		}
+		this.readByte()
+		this.readByte()
+		low, err := this.readHexRune()
+		if err != nil {
+			return 0, err
+		}
+		if pair := utf16.DecodeRune(r, low); pair != utf8.RuneError {
+			return pair, nil
+		}
+
+		// The high surrogate is unpaired, and the second escape may begin a
+		// new pair.
+		var encoded [utf8.UTFMax]byte
+		this.buffer = append(this.buffer, encoded[:utf8.EncodeRune(encoded[:], utf8.RuneError)]...)
+		if !utf16.IsSurrogate(low) {
+			return low, nil
+		}
+		r = low
+	}
+}
+
+func (this *Decoder) readHexRune() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		b, err := this.readByteInValue()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case b >= '0' && b <= '9':
+			b -= '0'
+		case b >= 'a' && b <= 'f':
+			b = b - 'a' + 10
+		case b >= 'A' && b <= 'F':
+			b = b - 'A' + 10
+		default:
+			return 0, this.syntaxError("Invalid hex digit %q in unicode escape", b)
+		}
+		r = r<<4 | rune(b)
+	}
+	return r, nil
+}
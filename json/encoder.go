@@ -0,0 +1,371 @@
+// Package json encodes and decodes JSON (RFC 8259) using reconstruct's
+// iterators and builders.
+//
+// Events map to JSON as follows:
+//
+//	Nil                  null
+//	Bool                 true or false
+//	Int, Uint            number
+//	Float                number, always with a fraction or exponent (see also NaNInfMode)
+//	String               string
+//	Bytes                string containing base64 (standard encoding, padded), or null if nil
+//	URI                  string
+//	Time                 string in RFC 3339 format
+//	List                 array
+//	Map                  object
+//
+// Complex numbers and references have no JSON representation, and cause
+// encoding to fail. Markers are ignored.
+//
+// Since JSON object keys must be strings, map keys that are scalars of other
+// types are encoded as the contents of the string that their value would be
+// encoded as (for example 1 becomes "1", and null becomes "null").
+package json
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// NaNInfMode determines how the encoder handles float values that JSON can't
+// represent.
+type NaNInfMode int
+
+const (
+	// Fail with an error. This is the default.
+	NaNInfError NaNInfMode = iota
+	// Encode as null.
+	NaNInfNull
+	// Encode as the strings "NaN", "Infinity", and "-Infinity".
+	NaNInfString
+)
+
+type EncoderOptions struct {
+	// If not empty, the output is split over multiple lines, with each level
+	// of nesting indented by this string (typically "  " or "\t").
+	Indent string
+
+	// How to encode NaN and infinity.
+	NaNInf NaNInfMode
+}
+
+func (this *EncoderOptions) withDefaultsApplied() EncoderOptions {
+	var options EncoderOptions
+	if this != nil {
+		options = *this
+	}
+	return options
+}
+
+// Encode writes value to writer as JSON, using `json` struct tags to name
+// fields.
+func Encode(writer io.Writer, value interface{}, options *EncoderOptions) error {
+	iterOptions := &reconstruct.IteratorOptions{FieldNameResolver: reconstruct.JSONTagResolver}
+	return reconstruct.IterateObjectWithOptions(value, iterOptions, NewEncoder(writer, options))
+}
+
+// How much output to buffer before writing to the underlying writer
+const flushThreshold = 4096
+
+type encoderContainer struct {
+	isMap     bool
+	nextIsKey bool
+	count     int
+}
+
+// Encoder implements ObjectIteratorCallbacks, writing each top-level object as
+// JSON followed by a newline.
+type Encoder struct {
+	writer     io.Writer
+	options    EncoderOptions
+	buffer     []byte
+	containers []encoderContainer
+	err        error
+}
+
+// NewEncoder creates an encoder that writes to writer, configured by options
+// (nil means use defaults).
+func NewEncoder(writer io.Writer, options *EncoderOptions) *Encoder {
+	return &Encoder{
+		writer:  writer,
+		options: options.withDefaultsApplied(),
+	}
+}
+
+func (this *Encoder) fail(format string, args ...interface{}) error {
+	this.err = fmt.Errorf(format, args...)
+	return this.err
+}
+
+func (this *Encoder) flush() error {
+	if _, err := this.writer.Write(this.buffer); err != nil {
+		this.err = err
+		return err
+	}
+	this.buffer = this.buffer[:0]
+	return nil
+}
+
+func (this *Encoder) isAtKey() bool {
+	if len(this.containers) == 0 {
+		return false
+	}
+	container := this.containers[len(this.containers)-1]
+	return container.isMap && container.nextIsKey
+}
+
+func (this *Encoder) writeNewline(depth int) {
+	if this.options.Indent == "" {
+		return
+	}
+	this.buffer = append(this.buffer, '\n')
+	for i := 0; i < depth; i++ {
+		this.buffer = append(this.buffer, this.options.Indent...)
+	}
+}
+
+// Write any separators and whitespace that go before the next value.
+func (this *Encoder) beginValue() {
+	if len(this.containers) == 0 {
+		return
+	}
+	container := &this.containers[len(this.containers)-1]
+	if container.isMap && !container.nextIsKey {
+		this.buffer = append(this.buffer, ':')
+		if this.options.Indent != "" {
+			this.buffer = append(this.buffer, ' ')
+		}
+	} else {
+		if container.count > 0 {
+			this.buffer = append(this.buffer, ',')
+		}
+		this.writeNewline(len(this.containers))
+		container.count++
+	}
+	if container.isMap {
+		container.nextIsKey = !container.nextIsKey
+	}
+}
+
+func (this *Encoder) endValue() error {
+	if len(this.containers) > 0 {
+		if len(this.buffer) >= flushThreshold {
+			return this.flush()
+		}
+		return nil
+	}
+	this.buffer = append(this.buffer, '\n')
+	return this.flush()
+}
+
+// Encode a value that has no quotes, quoting it instead if it's a map key.
+func (this *Encoder) encodeUnquoted(value string) error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.isAtKey() {
+		return this.encodeString(value)
+	}
+	this.beginValue()
+	this.buffer = append(this.buffer, value...)
+	return this.endValue()
+}
+
+func (this *Encoder) encodeString(value string) error {
+	if this.err != nil {
+		return this.err
+	}
+	this.beginValue()
+	this.buffer = appendQuoted(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) beginContainer(isMap bool) error {
+	if this.err != nil {
+		return this.err
+	}
+	if this.isAtKey() {
+		return this.fail("JSON object keys cannot be containers")
+	}
+	this.beginValue()
+	if isMap {
+		this.buffer = append(this.buffer, '{')
+	} else {
+		this.buffer = append(this.buffer, '[')
+	}
+	this.containers = append(this.containers, encoderContainer{isMap: isMap, nextIsKey: true})
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+func appendQuoted(buffer []byte, value string) []byte {
+	buffer = append(buffer, '"')
+	start := 0
+	for i := 0; i < len(value); {
+		b := value[i]
+		if b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			buffer = append(buffer, value[start:i]...)
+			switch b {
+			case '"', '\\':
+				buffer = append(buffer, '\\', b)
+			case '\n':
+				buffer = append(buffer, '\\', 'n')
+			case '\r':
+				buffer = append(buffer, '\\', 'r')
+			case '\t':
+				buffer = append(buffer, '\\', 't')
+			default:
+				buffer = append(buffer, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			buffer = append(buffer, value[start:i]...)
+			buffer = append(buffer, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buffer = append(buffer, value[start:]...)
+	return append(buffer, '"')
+}
+
+func formatFloat(value float64) string {
+	str := strconv.FormatFloat(value, 'g', -1, 64)
+	for _, ch := range str {
+		if ch == '.' || ch == 'e' {
+			return str
+		}
+	}
+	return str + ".0"
+}
+
+// -----------------------
+// ObjectIteratorCallbacks
+// -----------------------
+
+func (this *Encoder) OnNil() error {
+	return this.encodeUnquoted("null")
+}
+
+func (this *Encoder) OnBool(value bool) error {
+	return this.encodeUnquoted(strconv.FormatBool(value))
+}
+
+func (this *Encoder) OnInt(value int64) error {
+	return this.encodeUnquoted(strconv.FormatInt(value, 10))
+}
+
+func (this *Encoder) OnUint(value uint64) error {
+	return this.encodeUnquoted(strconv.FormatUint(value, 10))
+}
+
+func (this *Encoder) OnFloat(value float64) error {
+	if this.err != nil {
+		return this.err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		switch this.options.NaNInf {
+		case NaNInfNull:
+			return this.OnNil()
+		case NaNInfString:
+			switch {
+			case math.IsNaN(value):
+				return this.encodeString("NaN")
+			case value > 0:
+				return this.encodeString("Infinity")
+			default:
+				return this.encodeString("-Infinity")
+			}
+		default:
+			return this.fail("JSON cannot represent float value %v", value)
+		}
+	}
+	return this.encodeUnquoted(formatFloat(value))
+}
+
+func (this *Encoder) OnComplex(value complex128) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("JSON cannot represent complex value %v", value)
+}
+
+func (this *Encoder) OnString(value string) error {
+	return this.encodeString(value)
+}
+
+func (this *Encoder) OnBytes(value []byte) error {
+	if value == nil {
+		return this.OnNil()
+	}
+	return this.encodeString(base64.StdEncoding.EncodeToString(value))
+}
+
+func (this *Encoder) OnURI(value *url.URL) error {
+	return this.encodeString(value.String())
+}
+
+func (this *Encoder) OnTime(value time.Time) error {
+	return this.encodeString(value.Format(time.RFC3339Nano))
+}
+
+func (this *Encoder) OnListBegin() error {
+	return this.beginContainer(false)
+}
+
+func (this *Encoder) OnMapBegin() error {
+	return this.beginContainer(true)
+}
+
+func (this *Encoder) OnContainerEnd() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) == 0 {
+		return this.fail("Container end without a matching container begin")
+	}
+	container := this.containers[len(this.containers)-1]
+	if container.isMap && !container.nextIsKey {
+		return this.fail("JSON object ended with a key but no value")
+	}
+	this.containers = this.containers[:len(this.containers)-1]
+	if container.count > 0 {
+		this.writeNewline(len(this.containers))
+	}
+	if container.isMap {
+		this.buffer = append(this.buffer, '}')
+	} else {
+		this.buffer = append(this.buffer, ']')
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnMarker(id interface{}) error {
+	return this.err
+}
+
+func (this *Encoder) OnReference(id interface{}) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("JSON cannot represent references (iterate without references)")
+}
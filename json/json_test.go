@@ -0,0 +1,284 @@
+package json
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+func encodeToString(value interface{}, options *EncoderOptions) (string, error) {
+	buffer := &bytes.Buffer{}
+	err := Encode(buffer, value, options)
+	return buffer.String(), err
+}
+
+func assertEncoded(t *testing.T, value interface{}, options *EncoderOptions, expected string) {
+	actual, err := encodeToString(value, options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if actual != expected+"\n" {
+		t.Errorf("Expected %q but got %q", expected+"\n", actual)
+	}
+}
+
+func assertEncodeFails(t *testing.T, value interface{}, options *EncoderOptions) {
+	if _, err := encodeToString(value, options); err == nil {
+		t.Errorf("Expected encoding %v to fail", value)
+	}
+}
+
+type EncodeStruct struct {
+	Name    string            `json:"name"`
+	Count   int               `json:"count,omitempty"`
+	Ratio   float64           `json:"ratio"`
+	Tags    []string          `json:"tags"`
+	Data    []byte            `json:"data"`
+	Inner   *EncodeStruct     `json:"inner,omitempty"`
+	Labels  map[string]string `json:"labels"`
+	Skipped bool              `json:"-"`
+}
+
+func TestEncodeScalars(t *testing.T) {
+	assertEncoded(t, nil, nil, "null")
+	assertEncoded(t, true, nil, "true")
+	assertEncoded(t, -100, nil, "-100")
+	assertEncoded(t, uint64(math.MaxUint64), nil, "18446744073709551615")
+	assertEncoded(t, 1.5, nil, "1.5")
+	assertEncoded(t, 2.0, nil, "2.0")
+	assertEncoded(t, 1e100, nil, "1e+100")
+	assertEncoded(t, "a\"b\\c\n\x01é", nil, `"a\"b\\c\n\u0001`+"é\"")
+	assertEncoded(t, "bad\xffutf8", nil, "\"bad�utf8\"")
+	assertEncoded(t, []byte{1, 2, 3, 4}, nil, `"AQIDBA=="`)
+	assertEncoded(t, time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC), nil, `"2020-01-02T03:04:05.6Z"`)
+	uri, _ := url.Parse("http://example.com/a?b=c")
+	assertEncoded(t, uri, nil, `"http://example.com/a?b=c"`)
+}
+
+func TestEncodeContainers(t *testing.T) {
+	assertEncoded(t, []interface{}{}, nil, "[]")
+	assertEncoded(t, map[string]int{}, nil, "{}")
+	assertEncoded(t, []interface{}{1, "a", nil, []int{2}}, nil, `[1,"a",null,[2]]`)
+	assertEncoded(t, map[string][]int{"a": {1, 2}}, nil, `{"a":[1,2]}`)
+	assertEncoded(t, EncodeStruct{Name: "x", Ratio: 0.5, Tags: []string{"t"}, Skipped: true}, nil,
+		`{"name":"x","ratio":0.5,"tags":["t"],"data":null,"labels":null}`)
+}
+
+func TestEncodeNonStringKeys(t *testing.T) {
+	assertEncoded(t, map[int]int{1: 2}, nil, `{"1":2}`)
+	assertEncoded(t, map[bool]bool{true: false}, nil, `{"true":false}`)
+	assertEncoded(t, map[float64]int{1.5: 1}, nil, `{"1.5":1}`)
+	assertEncoded(t, map[interface{}]int{nil: 1}, nil, `{"null":1}`)
+	assertEncodeFails(t, map[[1]int]int{{1}: 1}, nil)
+}
+
+func TestEncodeIndent(t *testing.T) {
+	options := &EncoderOptions{Indent: "  "}
+	assertEncoded(t, []int{}, options, "[]")
+	assertEncoded(t, EncodeStruct{Name: "x", Tags: []string{"a", "b"}, Labels: map[string]string{}}, options,
+		strings.Join([]string{
+			`{`,
+			`  "name": "x",`,
+			`  "ratio": 0.0,`,
+			`  "tags": [`,
+			`    "a",`,
+			`    "b"`,
+			`  ],`,
+			`  "data": null,`,
+			`  "labels": {}`,
+			`}`,
+		}, "\n"))
+}
+
+func TestEncodeNaNInf(t *testing.T) {
+	assertEncodeFails(t, math.NaN(), nil)
+	assertEncodeFails(t, []float64{math.Inf(1)}, &EncoderOptions{NaNInf: NaNInfError})
+	assertEncoded(t, []float64{math.NaN(), math.Inf(-1)}, &EncoderOptions{NaNInf: NaNInfNull}, "[null,null]")
+	assertEncoded(t, []float64{math.NaN(), math.Inf(1), math.Inf(-1)}, &EncoderOptions{NaNInf: NaNInfString},
+		`["NaN","Infinity","-Infinity"]`)
+}
+
+func TestEncodeFail(t *testing.T) {
+	assertEncodeFails(t, 1+2i, nil)
+
+	shared := &EncodeStruct{Name: "shared"}
+	buffer := &bytes.Buffer{}
+	if err := reconstruct.IterateObject([]*EncodeStruct{shared, shared}, true, NewEncoder(buffer, nil)); err == nil {
+		t.Errorf("Expected encoding references to fail")
+	}
+}
+
+func TestEncoderMultipleValues(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	encoder := NewEncoder(buffer, nil)
+	for _, value := range []interface{}{1, []int{2}} {
+		if err := reconstruct.IterateObject(value, false, encoder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := "1\n[2]\n"; buffer.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buffer.String())
+	}
+}
+
+func assertDecoded(t *testing.T, document string, target interface{}, expected interface{}) {
+	if err := Decode(strings.NewReader(document), target); err != nil {
+		t.Errorf("Decoding %q: %v", document, err)
+		return
+	}
+	actual := reflect.ValueOf(target).Elem().Interface()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoding %q: Expected %#v but got %#v", document, expected, actual)
+	}
+}
+
+func assertDecodeFails(t *testing.T, document string, target interface{}) {
+	if err := Decode(strings.NewReader(document), target); err == nil {
+		t.Errorf("Expected decoding %q to fail", document)
+	}
+}
+
+type DecodeStruct struct {
+	Name  string            `json:"name"`
+	Count int64             `json:"count"`
+	Big   uint64            `json:"big"`
+	Ratio float64           `json:"ratio"`
+	Data  []byte            `json:"data"`
+	When  time.Time         `json:"when"`
+	Where *url.URL          `json:"where"`
+	Inner *DecodeStruct     `json:"inner"`
+	List  []int             `json:"list"`
+	Map   map[string]string `json:"map"`
+}
+
+func TestDecodeScalars(t *testing.T) {
+	var b bool
+	assertDecoded(t, " true ", &b, true)
+	var i int
+	assertDecoded(t, "-100", &i, -100)
+	assertDecoded(t, "0", &i, 0)
+	var i64 int64
+	assertDecoded(t, "9223372036854775807", &i64, int64(math.MaxInt64))
+	assertDecoded(t, "-9223372036854775808", &i64, int64(math.MinInt64))
+	var u64 uint64
+	assertDecoded(t, "18446744073709551615", &u64, uint64(math.MaxUint64))
+	var f float64
+	assertDecoded(t, "1.5e3", &f, 1500.0)
+	assertDecoded(t, "-0.25", &f, -0.25)
+	assertDecoded(t, "100000000000000000000", &f, 1e20)
+	var s string
+	assertDecoded(t, `"a\"b\\c\/\b\f\n\r\té"`, &s, "a\"b\\c/\b\f\n\r\té")
+	assertDecoded(t, `"😀"`, &s, "😀")
+	assertDecoded(t, `"\ud83d\ude00"`, &s, "😀")
+	// Surrogates that aren't part of a pair decode as U+FFFD
+	assertDecoded(t, `"\ud83dx"`, &s, "�x")
+	assertDecoded(t, `"\ud800"`, &s, "\ufffd")
+	assertDecoded(t, `"\ud800\u0041"`, &s, "\ufffdA")
+	assertDecoded(t, `"\ud800\ud800"`, &s, "\ufffd\ufffd")
+	assertDecoded(t, `"\ud800\ud800\udc00"`, &s, "\ufffd\U00010000")
+	assertDecoded(t, `"\udc00\ud800\udc00"`, &s, "\ufffd\U00010000")
+	assertDecoded(t, `"\ud800\ud800\ud800\udc00x"`, &s, "\ufffd\ufffd\U00010000x")
+	assertDecoded(t, "\"bad\xffutf8\"", &s, "bad�utf8")
+	var data []byte
+	assertDecoded(t, `"AQIDBA=="`, &data, []byte{1, 2, 3, 4})
+	var p *int
+	assertDecoded(t, "null", &p, (*int)(nil))
+}
+
+func TestDecodeInterface(t *testing.T) {
+	var value interface{}
+	assertDecoded(t, `[1, -1, 18446744073709551615, 1.5, "x", null, false, {"a": []}]`, &value,
+		[]interface{}{int64(1), int64(-1), uint64(math.MaxUint64), 1.5, "x", nil, false,
+			map[string]interface{}{"a": []interface{}{}}})
+
+	var stringMap map[string]interface{}
+	assertDecoded(t, `{"a": {"b": 1}}`, &stringMap,
+		map[string]interface{}{"a": map[string]interface{}{"b": int64(1)}})
+}
+
+func TestDecodeStruct(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	where, _ := url.Parse("http://example.com/a?b=c")
+	expected := DecodeStruct{
+		Name:  "outer",
+		Count: math.MaxInt64,
+		Big:   math.MaxUint64,
+		Ratio: 0.5,
+		Data:  []byte{1, 2, 3},
+		When:  when,
+		Where: where,
+		Inner: &DecodeStruct{Name: "inner", List: []int{}},
+		List:  []int{1, 2, 3},
+		Map:   map[string]string{"k": "v"},
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, expected, &EncoderOptions{Indent: "\t"}); err != nil {
+		t.Fatal(err)
+	}
+	var actual DecodeStruct
+	if err := Decode(buffer, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !actual.When.Equal(when) {
+		t.Errorf("Expected time %v but got %v", when, actual.When)
+	}
+	actual.When = when
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, actual)
+	}
+
+	var pStruct *DecodeStruct
+	assertDecoded(t, `{"name": "x", "unknown": 1}`, &pStruct, &DecodeStruct{Name: "x"})
+}
+
+func TestDecodeFail(t *testing.T) {
+	var value interface{}
+	for _, document := range []string{
+		"", "[", "[1,]", "[1 2]", "{1:2}", `{"a" 1}`, `{"a":1,}`, "tru", "nul",
+		"01", "1.", "-", "1e", "+1", ".5", `"abc`, `"\x"`, `"\u12"`, "\"\t\"",
+		"1 2", "[]]", "1e999",
+		strings.Repeat("[", maxDepth+1) + strings.Repeat("]", maxDepth+1),
+	} {
+		assertDecodeFails(t, document, &value)
+	}
+
+	var i int8
+	assertDecodeFails(t, "1000", &i)
+	assertDecodeFails(t, "1.5", &i)
+	assertDecodeFails(t, "null", &i)
+	assertDecodeFails(t, "1", value)
+
+	err := Decode(strings.NewReader("[1, x]"), &value)
+	if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Offset != 5 {
+		t.Errorf("Expected a syntax error at offset 5 but got %v", err)
+	}
+}
+
+func TestDecoderMultipleValues(t *testing.T) {
+	decoder := NewDecoder(strings.NewReader(`1 [2] {"a":3}`), nil)
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	expected := []interface{}{int64(1), []interface{}{int64(2)}, map[string]interface{}{"a": int64(3)}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values)
+	}
+}
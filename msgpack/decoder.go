@@ -0,0 +1,391 @@
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// How deeply arrays and maps may nest before decoding fails.
+const maxDepth = 10000
+
+// SyntaxError describes malformed MessagePack.
+type SyntaxError struct {
+	// The byte offset where the error was detected
+	Offset int64
+	Msg    string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("MessagePack syntax error at offset %v: %v", this.Offset, this.Msg)
+}
+
+type DecoderOptions struct {
+	// The extension type holding URIs. Defaults to 0.
+	URIExtension int8
+
+	// How to decode str.
+	StrBin StrBinMode
+}
+
+func (this *DecoderOptions) withDefaultsApplied() DecoderOptions {
+	var options DecoderOptions
+	if this != nil {
+		options = *this
+	}
+	return options
+}
+
+// Decode reads a single MessagePack object from reader into target, which
+// must be a non-nil pointer. Anything after the object is an error.
+func Decode(reader io.Reader, target interface{}) error {
+	decoder := NewDecoder(reader, nil)
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if _, err := decoder.readByte(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return decoder.syntaxError("Unexpected data after top-level object")
+	}
+	return nil
+}
+
+// Decoder reads a stream of MessagePack objects, generating events as it goes
+// rather than building an intermediate representation.
+//
+// Unsigned integers are passed to OnInt if they fit in an int64, and to OnUint
+// otherwise. Extension types other than timestamps and URIs cause an error.
+type Decoder struct {
+	reader         *bufio.Reader
+	offset         int64
+	options        DecoderOptions
+	builderOptions reconstruct.BuilderOptions
+	callbacks      reconstruct.ObjectIteratorCallbacks
+	depth          int
+}
+
+// NewDecoder creates a decoder that reads from reader, and builds values using
+// builderOptions (nil means use defaults).
+func NewDecoder(reader io.Reader, builderOptions *reconstruct.BuilderOptions) *Decoder {
+	return NewDecoderWithOptions(reader, nil, builderOptions)
+}
+
+// NewDecoderWithOptions creates a decoder that reads from reader, configured by
+// options, and builds values using builderOptions (nil means use defaults).
+// StringsAsBytes is always enabled when StrBin is StrBinRaw.
+func NewDecoderWithOptions(reader io.Reader, options *DecoderOptions, builderOptions *reconstruct.BuilderOptions) *Decoder {
+	this := &Decoder{
+		reader:  bufio.NewReader(reader),
+		options: options.withDefaultsApplied(),
+	}
+	if builderOptions != nil {
+		this.builderOptions = *builderOptions
+	}
+	if this.options.StrBin == StrBinRaw {
+		this.builderOptions.StringsAsBytes = true
+	}
+	return this
+}
+
+// Decode reads the next MessagePack object into target, which must be a
+// non-nil pointer. Returns io.EOF if there are no more objects.
+func (this *Decoder) Decode(target interface{}) error {
+	dst := reflect.ValueOf(target)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("Cannot decode into %v: Target must be a non-nil pointer", reflect.TypeOf(target))
+	}
+
+	b, err := this.readByte()
+	if err != nil {
+		return err
+	}
+
+	builder := reconstruct.NewBuilderWithOptions(target, &this.builderOptions)
+	this.callbacks = builder
+	this.depth = 0
+	if err := this.decodeObject(b); err != nil {
+		return err
+	}
+	return builder.StoreBuiltObject(target)
+}
+
+// DecodeEvents reads the next MessagePack object, passing its events to
+// callbacks. Returns io.EOF if there are no more objects.
+func (this *Decoder) DecodeEvents(callbacks reconstruct.ObjectIteratorCallbacks) error {
+	b, err := this.readByte()
+	if err != nil {
+		return err
+	}
+	this.callbacks = callbacks
+	this.depth = 0
+	return this.decodeObject(b)
+}
+
+func (this *Decoder) syntaxError(format string, args ...interface{}) error {
+	return &SyntaxError{
+		Offset: this.offset,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (this *Decoder) readByte() (byte, error) {
+	b, err := this.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	this.offset++
+	return b, nil
+}
+
+// Read a byte that must exist because we're partway through an object.
+func (this *Decoder) readByteInObject() (byte, error) {
+	b, err := this.readByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// Read a big endian unsigned integer of size bytes.
+func (this *Decoder) readUint(size int) (uint64, error) {
+	var buffer [8]byte
+	n, err := io.ReadFull(this.reader, buffer[:size])
+	this.offset += int64(n)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, b := range buffer[:size] {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+// Read length bytes. The length comes from the input, so nothing is allocated
+// up front.
+func (this *Decoder) readData(length uint64) ([]byte, error) {
+	// Empty data must still be non-nil
+	buffer := bytes.NewBuffer([]byte{})
+	n, err := io.CopyN(buffer, this.reader, int64(length))
+	this.offset += n
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buffer.Bytes(), err
+}
+
+func (this *Decoder) readNextObject() error {
+	b, err := this.readByteInObject()
+	if err != nil {
+		return err
+	}
+	return this.decodeObject(b)
+}
+
+func (this *Decoder) decodeObject(code byte) error {
+	switch {
+	case code <= codePosFixintMax:
+		return this.callbacks.OnInt(int64(code))
+	case code >= codeNegFixintMin:
+		return this.callbacks.OnInt(int64(int8(code)))
+	case code&0xf0 == codeFixmap:
+		return this.decodeMap(uint64(code & 0x0f))
+	case code&0xf0 == codeFixarray:
+		return this.decodeArray(uint64(code & 0x0f))
+	case code&0xe0 == codeFixstr:
+		return this.decodeStr(uint64(code & 0x1f))
+	}
+
+	switch code {
+	case codeNil:
+		return this.callbacks.OnNil()
+	case codeFalse:
+		return this.callbacks.OnBool(false)
+	case codeTrue:
+		return this.callbacks.OnBool(true)
+	case codeUint8, codeUint16, codeUint32, codeUint64:
+		value, err := this.readUint(1 << (code - codeUint8))
+		if err != nil {
+			return err
+		}
+		if value <= math.MaxInt64 {
+			return this.callbacks.OnInt(int64(value))
+		}
+		return this.callbacks.OnUint(value)
+	case codeInt8, codeInt16, codeInt32, codeInt64:
+		size := uint(1) << (code - codeInt8)
+		value, err := this.readUint(int(size))
+		if err != nil {
+			return err
+		}
+		// Shift up and back down to sign extend
+		shift := 64 - size*8
+		return this.callbacks.OnInt(int64(value<<shift) >> shift)
+	case codeFloat32:
+		value, err := this.readUint(4)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(float64(math.Float32frombits(uint32(value))))
+	case codeFloat64:
+		value, err := this.readUint(8)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnFloat(math.Float64frombits(value))
+	case codeStr8, codeStr16, codeStr32:
+		length, err := this.readUint(1 << (code - codeStr8))
+		if err != nil {
+			return err
+		}
+		return this.decodeStr(length)
+	case codeBin8, codeBin16, codeBin32:
+		length, err := this.readUint(1 << (code - codeBin8))
+		if err != nil {
+			return err
+		}
+		value, err := this.readData(length)
+		if err != nil {
+			return err
+		}
+		return this.callbacks.OnBytes(value)
+	case codeArray16, codeArray32:
+		length, err := this.readUint(2 << (code - codeArray16))
+		if err != nil {
+			return err
+		}
+		return this.decodeArray(length)
+	case codeMap16, codeMap32:
+		length, err := this.readUint(2 << (code - codeMap16))
+		if err != nil {
+			return err
+		}
+		return this.decodeMap(length)
+	case codeFixext1, codeFixext2, codeFixext4, codeFixext8, codeFixext16:
+		return this.decodeExt(1 << (code - codeFixext1))
+	case codeExt8, codeExt16, codeExt32:
+		length, err := this.readUint(1 << (code - codeExt8))
+		if err != nil {
+			return err
+		}
+		return this.decodeExt(length)
+	default:
+		return this.syntaxError("Invalid code %02x", code)
+	}
+}
+
+func (this *Decoder) decodeStr(length uint64) error {
+	value, err := this.readData(length)
+	if err != nil {
+		return err
+	}
+	if this.options.StrBin != StrBinRaw && !utf8.Valid(value) {
+		return this.syntaxError("str contains invalid UTF-8")
+	}
+	return this.callbacks.OnString(string(value))
+}
+
+func (this *Decoder) enter() error {
+	this.depth++
+	if this.depth > maxDepth {
+		return this.syntaxError("Exceeded maximum nesting depth of %v", maxDepth)
+	}
+	return nil
+}
+
+func (this *Decoder) decodeArray(length uint64) error {
+	if err := this.enter(); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnListBegin(); err != nil {
+		return err
+	}
+	for i := uint64(0); i < length; i++ {
+		if err := this.readNextObject(); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeMap(length uint64) error {
+	if err := this.enter(); err != nil {
+		return err
+	}
+	if err := this.callbacks.OnMapBegin(); err != nil {
+		return err
+	}
+	for i := uint64(0); i < length*2; i++ {
+		if err := this.readNextObject(); err != nil {
+			return err
+		}
+	}
+	this.depth--
+	return this.callbacks.OnContainerEnd()
+}
+
+func (this *Decoder) decodeExt(length uint64) error {
+	extTypeByte, err := this.readByteInObject()
+	if err != nil {
+		return err
+	}
+	extType := int8(extTypeByte)
+	data, err := this.readData(length)
+	if err != nil {
+		return err
+	}
+
+	switch extType {
+	case extTimestamp:
+		value, err := decodeTimestamp(data)
+		if err != nil {
+			return this.syntaxError("%v", err)
+		}
+		return this.callbacks.OnTime(value)
+	case this.options.URIExtension:
+		value, err := url.Parse(string(data))
+		if err != nil {
+			return this.syntaxError("Invalid URI %q: %v", data, err)
+		}
+		return this.callbacks.OnURI(value)
+	default:
+		return this.syntaxError("Unsupported extension type %v", extType)
+	}
+}
+
+func decodeTimestamp(data []byte) (time.Time, error) {
+	var seconds int64
+	var nanoseconds uint32
+	switch len(data) {
+	case 4:
+		seconds = int64(binary.BigEndian.Uint32(data))
+	case 8:
+		value := binary.BigEndian.Uint64(data)
+		nanoseconds = uint32(value >> 34)
+		seconds = int64(value & (1<<34 - 1))
+	case 12:
+		nanoseconds = binary.BigEndian.Uint32(data)
+		seconds = int64(binary.BigEndian.Uint64(data[4:]))
+	default:
+		return time.Time{}, fmt.Errorf("Invalid timestamp length %v", len(data))
+	}
+	if nanoseconds >= 1e9 {
+		return time.Time{}, fmt.Errorf("Timestamp nanoseconds %v out of range", nanoseconds)
+	}
+	return time.Unix(seconds, int64(nanoseconds)).UTC(), nil
+}
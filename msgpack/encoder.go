@@ -0,0 +1,428 @@
+// Package msgpack encodes and decodes MessagePack using reconstruct's
+// iterators and builders.
+//
+// Events map to MessagePack as follows:
+//
+//	Nil                  nil
+//	Bool                 false or true
+//	Int, Uint            the smallest int or uint format that holds the value
+//	Float                float 32 if that's lossless, otherwise float 64
+//	String               str
+//	Bytes                bin (or str, see StrBinMode), or nil if nil
+//	URI                  ext (of a configurable type) containing the URI string
+//	Time                 timestamp extension (type -1)
+//	List                 array
+//	Map                  map
+//
+// Complex numbers and references have no MessagePack representation, and
+// cause encoding to fail. Markers are ignored.
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+const (
+	codePosFixintMax = 0x7f
+	codeFixmap       = 0x80
+	codeFixarray     = 0x90
+	codeFixstr       = 0xa0
+	codeNil          = 0xc0
+	codeFalse        = 0xc2
+	codeTrue         = 0xc3
+	codeBin8         = 0xc4
+	codeBin16        = 0xc5
+	codeBin32        = 0xc6
+	codeExt8         = 0xc7
+	codeExt16        = 0xc8
+	codeExt32        = 0xc9
+	codeFloat32      = 0xca
+	codeFloat64      = 0xcb
+	codeUint8        = 0xcc
+	codeUint16       = 0xcd
+	codeUint32       = 0xce
+	codeUint64       = 0xcf
+	codeInt8         = 0xd0
+	codeInt16        = 0xd1
+	codeInt32        = 0xd2
+	codeInt64        = 0xd3
+	codeFixext1      = 0xd4
+	codeFixext2      = 0xd5
+	codeFixext4      = 0xd6
+	codeFixext8      = 0xd7
+	codeFixext16     = 0xd8
+	codeStr8         = 0xd9
+	codeStr16        = 0xda
+	codeStr32        = 0xdb
+	codeArray16      = 0xdc
+	codeArray32      = 0xdd
+	codeMap16        = 0xde
+	codeMap32        = 0xdf
+	codeNegFixintMin = 0xe0
+
+	maxFixLength    = 15
+	maxFixstrLength = 31
+	minNegFixint    = -32
+
+	extTimestamp = -1
+)
+
+// StrBinMode determines how String and Bytes events map to MessagePack's str
+// and bin families.
+type StrBinMode int
+
+const (
+	// Strings are str and bytes are bin. When decoding, a str that isn't valid
+	// UTF-8 is an error. This is the default.
+	StrBinDistinct StrBinMode = iota
+	// Both strings and bytes are str (the original "raw" family), for peers
+	// that predate bin. When decoding, every str is passed to OnString (even
+	// if it isn't valid UTF-8), and can be built into []byte.
+	StrBinRaw
+)
+
+type EncoderOptions struct {
+	// The extension type for URIs (application-defined types are 0-127).
+	// Defaults to 0.
+	URIExtension int8
+
+	// How to encode strings and bytes.
+	StrBin StrBinMode
+}
+
+func (this *EncoderOptions) withDefaultsApplied() EncoderOptions {
+	var options EncoderOptions
+	if this != nil {
+		options = *this
+	}
+	return options
+}
+
+// Encode writes value to writer as MessagePack.
+func Encode(writer io.Writer, value interface{}, options *EncoderOptions) error {
+	return reconstruct.IterateObject(value, false, NewEncoder(writer, options))
+}
+
+type encoderContainer struct {
+	isMap bool
+	// Where the container's contents begin in the buffer
+	start int
+	count int
+}
+
+// Encoder implements ObjectIteratorCallbacks, writing each top-level object as
+// MessagePack.
+//
+// MessagePack prefixes arrays and maps with their lengths, so each top-level
+// object is held in memory until it's complete.
+type Encoder struct {
+	writer     io.Writer
+	options    EncoderOptions
+	buffer     []byte
+	containers []encoderContainer
+	err        error
+}
+
+// NewEncoder creates an encoder that writes to writer, configured by options
+// (nil means use defaults).
+func NewEncoder(writer io.Writer, options *EncoderOptions) *Encoder {
+	return &Encoder{
+		writer:  writer,
+		options: options.withDefaultsApplied(),
+	}
+}
+
+func (this *Encoder) fail(format string, args ...interface{}) error {
+	this.err = fmt.Errorf(format, args...)
+	return this.err
+}
+
+func appendUint(buffer []byte, value uint64) []byte {
+	switch {
+	case value <= codePosFixintMax:
+		return append(buffer, byte(value))
+	case value <= math.MaxUint8:
+		return append(buffer, codeUint8, byte(value))
+	case value <= math.MaxUint16:
+		return appendUint16(append(buffer, codeUint16), uint16(value))
+	case value <= math.MaxUint32:
+		return appendUint32(append(buffer, codeUint32), uint32(value))
+	default:
+		return appendUint64(append(buffer, codeUint64), value)
+	}
+}
+
+func appendInt(buffer []byte, value int64) []byte {
+	switch {
+	case value >= 0:
+		return appendUint(buffer, uint64(value))
+	case value >= minNegFixint:
+		return append(buffer, byte(value))
+	case value >= math.MinInt8:
+		return append(buffer, codeInt8, byte(value))
+	case value >= math.MinInt16:
+		return appendUint16(append(buffer, codeInt16), uint16(value))
+	case value >= math.MinInt32:
+		return appendUint32(append(buffer, codeInt32), uint32(value))
+	default:
+		return appendUint64(append(buffer, codeInt64), uint64(value))
+	}
+}
+
+func appendUint16(buffer []byte, value uint16) []byte {
+	return append(buffer, byte(value>>8), byte(value))
+}
+
+func appendUint32(buffer []byte, value uint32) []byte {
+	buffer = append(buffer, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buffer[len(buffer)-4:], value)
+	return buffer
+}
+
+func appendUint64(buffer []byte, value uint64) []byte {
+	buffer = append(buffer, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(buffer[len(buffer)-8:], value)
+	return buffer
+}
+
+// Append a length using the fix code (if there is one), or the 8, 16, or 32
+// bit code.
+func appendLength(buffer []byte, length int, fixCode byte, maxFix int, code8, code16, code32 byte) []byte {
+	switch {
+	case fixCode != 0 && length <= maxFix:
+		return append(buffer, fixCode|byte(length))
+	case code8 != 0 && length <= math.MaxUint8:
+		return append(buffer, code8, byte(length))
+	case length <= math.MaxUint16:
+		return appendUint16(append(buffer, code16), uint16(length))
+	default:
+		return appendUint32(append(buffer, code32), uint32(length))
+	}
+}
+
+func appendStr(buffer []byte, value []byte) []byte {
+	buffer = appendLength(buffer, len(value), codeFixstr, maxFixstrLength, codeStr8, codeStr16, codeStr32)
+	return append(buffer, value...)
+}
+
+func appendExt(buffer []byte, extType int8, data []byte) []byte {
+	switch len(data) {
+	case 1:
+		buffer = append(buffer, codeFixext1)
+	case 2:
+		buffer = append(buffer, codeFixext2)
+	case 4:
+		buffer = append(buffer, codeFixext4)
+	case 8:
+		buffer = append(buffer, codeFixext8)
+	case 16:
+		buffer = append(buffer, codeFixext16)
+	default:
+		buffer = appendLength(buffer, len(data), 0, 0, codeExt8, codeExt16, codeExt32)
+	}
+	buffer = append(buffer, byte(extType))
+	return append(buffer, data...)
+}
+
+// Encode a timestamp in the smallest of its three formats that can hold it.
+func appendTimestamp(buffer []byte, value time.Time) []byte {
+	seconds := value.Unix()
+	nanoseconds := uint32(value.Nanosecond())
+	var data []byte
+	switch {
+	case seconds>>34 != 0:
+		data = appendUint32(make([]byte, 0, 12), nanoseconds)
+		data = appendUint64(data, uint64(seconds))
+	case nanoseconds != 0 || seconds > math.MaxUint32:
+		data = appendUint64(make([]byte, 0, 8), uint64(nanoseconds)<<34|uint64(seconds))
+	default:
+		data = appendUint32(make([]byte, 0, 4), uint32(seconds))
+	}
+	return appendExt(buffer, extTimestamp, data)
+}
+
+func (this *Encoder) beginValue() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) > 0 {
+		this.containers[len(this.containers)-1].count++
+	}
+	return nil
+}
+
+func (this *Encoder) endValue() error {
+	if len(this.containers) > 0 {
+		return nil
+	}
+	_, err := this.writer.Write(this.buffer)
+	this.buffer = this.buffer[:0]
+	if err != nil {
+		this.err = err
+	}
+	return err
+}
+
+func (this *Encoder) beginContainer(isMap bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.containers = append(this.containers, encoderContainer{
+		isMap: isMap,
+		start: len(this.buffer),
+	})
+	return nil
+}
+
+// -----------------------
+// ObjectIteratorCallbacks
+// -----------------------
+
+func (this *Encoder) OnNil() error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = append(this.buffer, codeNil)
+	return this.endValue()
+}
+
+func (this *Encoder) OnBool(value bool) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if value {
+		this.buffer = append(this.buffer, codeTrue)
+	} else {
+		this.buffer = append(this.buffer, codeFalse)
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnInt(value int64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendInt(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnUint(value uint64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendUint(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnFloat(value float64) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if float64(float32(value)) == value || math.IsNaN(value) {
+		this.buffer = appendUint32(append(this.buffer, codeFloat32), math.Float32bits(float32(value)))
+	} else {
+		this.buffer = appendUint64(append(this.buffer, codeFloat64), math.Float64bits(value))
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnComplex(value complex128) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("MessagePack cannot represent complex value %v", value)
+}
+
+func (this *Encoder) OnString(value string) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendStr(this.buffer, []byte(value))
+	return this.endValue()
+}
+
+func (this *Encoder) OnBytes(value []byte) error {
+	if value == nil {
+		return this.OnNil()
+	}
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	if this.options.StrBin == StrBinRaw {
+		this.buffer = appendStr(this.buffer, value)
+	} else {
+		this.buffer = appendLength(this.buffer, len(value), 0, 0, codeBin8, codeBin16, codeBin32)
+		this.buffer = append(this.buffer, value...)
+	}
+	return this.endValue()
+}
+
+func (this *Encoder) OnURI(value *url.URL) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendExt(this.buffer, this.options.URIExtension, []byte(value.String()))
+	return this.endValue()
+}
+
+func (this *Encoder) OnTime(value time.Time) error {
+	if err := this.beginValue(); err != nil {
+		return err
+	}
+	this.buffer = appendTimestamp(this.buffer, value)
+	return this.endValue()
+}
+
+func (this *Encoder) OnListBegin() error {
+	return this.beginContainer(false)
+}
+
+func (this *Encoder) OnMapBegin() error {
+	return this.beginContainer(true)
+}
+
+// Now that the container's length is known, insert its header before its
+// contents.
+func (this *Encoder) OnContainerEnd() error {
+	if this.err != nil {
+		return this.err
+	}
+	if len(this.containers) == 0 {
+		return this.fail("Container end without a matching container begin")
+	}
+	container := this.containers[len(this.containers)-1]
+	this.containers = this.containers[:len(this.containers)-1]
+
+	var header []byte
+	if container.isMap {
+		if container.count%2 != 0 {
+			return this.fail("MessagePack map ended with a key but no value")
+		}
+		header = appendLength(nil, container.count/2, codeFixmap, maxFixLength, 0, codeMap16, codeMap32)
+	} else {
+		header = appendLength(nil, container.count, codeFixarray, maxFixLength, 0, codeArray16, codeArray32)
+	}
+	end := len(this.buffer)
+	this.buffer = append(this.buffer, header...)
+	copy(this.buffer[container.start+len(header):], this.buffer[container.start:end])
+	copy(this.buffer[container.start:], header)
+	return this.endValue()
+}
+
+func (this *Encoder) OnMarker(id interface{}) error {
+	return this.err
+}
+
+func (this *Encoder) OnReference(id interface{}) error {
+	if this.err != nil {
+		return this.err
+	}
+	return this.fail("MessagePack cannot represent references (iterate without references)")
+}
@@ -0,0 +1,393 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kstenerud/go-reconstruct"
+)
+
+// Documents are written in hex, and may contain spaces for readability
+func decodeHex(t *testing.T, document string) []byte {
+	data, err := hex.DecodeString(strings.Replace(document, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encodeToHex(t *testing.T, value interface{}, options *EncoderOptions) string {
+	buffer := &bytes.Buffer{}
+	if err := Encode(buffer, value, options); err != nil {
+		t.Errorf("Encoding %v: %v", value, err)
+	}
+	return hex.EncodeToString(buffer.Bytes())
+}
+
+func assertEncoded(t *testing.T, value interface{}, options *EncoderOptions, expected string) {
+	expected = strings.Replace(expected, " ", "", -1)
+	if actual := encodeToHex(t, value, options); actual != expected {
+		t.Errorf("Encoding %v: Expected %v but got %v", value, expected, actual)
+	}
+}
+
+func assertDecoded(t *testing.T, document string, options *DecoderOptions, target interface{}, expected interface{}) {
+	decoder := NewDecoderWithOptions(bytes.NewReader(decodeHex(t, document)), options, nil)
+	if err := decoder.Decode(target); err != nil {
+		t.Errorf("Decoding %v: %v", document, err)
+		return
+	}
+	actual := reflect.ValueOf(target).Elem().Interface()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoding %v: Expected %#v but got %#v", document, expected, actual)
+	}
+}
+
+func assertDecodeFails(t *testing.T, document string, options *DecoderOptions, target interface{}) {
+	decoder := NewDecoderWithOptions(bytes.NewReader(decodeHex(t, document)), options, nil)
+	if err := decoder.Decode(target); err == nil {
+		t.Errorf("Expected decoding %v to fail", document)
+	}
+}
+
+// Encodes value, checks the result, and decodes it back into interface{}.
+func assertRoundtrip(t *testing.T, value interface{}, options *EncoderOptions, expectedHex string, expected interface{}) {
+	assertEncoded(t, value, options, expectedHex)
+	var decodeOptions *DecoderOptions
+	if options != nil {
+		decodeOptions = &DecoderOptions{URIExtension: options.URIExtension, StrBin: options.StrBin}
+	}
+	var actual interface{}
+	assertDecoded(t, expectedHex, decodeOptions, &actual, expected)
+}
+
+type MsgpackStruct struct {
+	Name  string
+	Count int64
+	Big   uint64
+	Ratio float64
+	Data  []byte
+	When  time.Time
+	Where *url.URL
+	Inner *MsgpackStruct
+	List  []int
+	Map   map[int]string
+}
+
+type SelfSlice []*SelfSlice
+
+// Each integer format at the edges of the range that it's chosen for
+func TestIntFormats(t *testing.T) {
+	for _, example := range []struct {
+		value    int64
+		expected string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{255, "ccff"},
+		{256, "cd0100"},
+		{65535, "cdffff"},
+		{65536, "ce00010000"},
+		{math.MaxUint32, "ceffffffff"},
+		{math.MaxUint32 + 1, "cf0000000100000000"},
+		{math.MaxInt64, "cf7fffffffffffffff"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{math.MinInt8, "d080"},
+		{math.MinInt8 - 1, "d1ff7f"},
+		{math.MinInt16, "d18000"},
+		{math.MinInt16 - 1, "d2ffff7fff"},
+		{math.MinInt32, "d280000000"},
+		{math.MinInt32 - 1, "d3ffffffff7fffffff"},
+		{math.MinInt64, "d38000000000000000"},
+	} {
+		assertRoundtrip(t, example.value, nil, example.expected, example.value)
+	}
+	assertRoundtrip(t, uint64(math.MaxUint64), nil, "cfffffffffffffffff", uint64(math.MaxUint64))
+
+	// Values may be stored in larger formats than they need
+	var i int
+	for _, document := range []string{
+		"cc01", "cd0001", "ce00000001", "cf0000000000000001",
+		"d001", "d10001", "d200000001", "d30000000000000001",
+	} {
+		assertDecoded(t, document, nil, &i, 1)
+	}
+	assertDecoded(t, "d0ff", nil, &i, -1)
+	assertDecoded(t, "d1ffff", nil, &i, -1)
+
+	var i8 int8
+	assertDecodeFails(t, "cd0100", nil, &i8)
+	assertDecodeFails(t, "d1ff7f", nil, &i8)
+	var u uint
+	assertDecodeFails(t, "ff", nil, &u)
+}
+
+func TestFloatFormats(t *testing.T) {
+	// Float 32 is only used when it's lossless
+	assertRoundtrip(t, 1.5, nil, "ca3fc00000", 1.5)
+	assertRoundtrip(t, math.Inf(-1), nil, "caff800000", math.Inf(-1))
+	assertRoundtrip(t, 1.1, nil, "cb3ff199999999999a", 1.1)
+	assertRoundtrip(t, math.MaxFloat64, nil, "cb7fefffffffffffff", math.MaxFloat64)
+
+	var f float64
+	if err := Decode(bytes.NewReader(decodeHex(t, "ca7fc00000")), &f); err != nil || !math.IsNaN(f) {
+		t.Errorf("Expected NaN but got %v (%v)", f, err)
+	}
+	var i int
+	assertDecodeFails(t, "ca3fc00000", nil, &i)
+	assertDecoded(t, "ca40000000", nil, &i, 2)
+}
+
+// str and bin at the edges of each length format
+func TestStrBinFormats(t *testing.T) {
+	for _, example := range []struct {
+		length int
+		str    string
+		bin    string
+	}{
+		{0, "a0", "c400"},
+		{31, "bf", "c41f"},
+		{32, "d920", "c420"},
+		{255, "d9ff", "c4ff"},
+		{256, "da0100", "c50100"},
+		{65535, "daffff", "c5ffff"},
+		{65536, "db00010000", "c600010000"},
+	} {
+		str := strings.Repeat("a", example.length)
+		strHex := strings.Repeat("61", example.length)
+		assertRoundtrip(t, str, nil, example.str+strHex, str)
+		bin := []byte(str)
+		assertRoundtrip(t, bin, nil, example.bin+strHex, bin)
+		// In raw mode, bytes use the str formats
+		assertEncoded(t, bin, &EncoderOptions{StrBin: StrBinRaw}, example.str+strHex)
+	}
+	assertEncoded(t, []byte(nil), nil, "c0")
+
+	// str must be UTF-8 unless it's raw, and raw str can be built into strings
+	// or bytes
+	var s string
+	assertDecodeFails(t, "a2ff00", nil, &s)
+	assertDecoded(t, "a2ff00", &DecoderOptions{StrBin: StrBinRaw}, &s, "\xff\x00")
+	var data []byte
+	assertDecoded(t, "a2ff00", &DecoderOptions{StrBin: StrBinRaw}, &data, []byte{0xff, 0})
+	assertDecodeFails(t, "a2ff00", nil, &data)
+	// bin is never a string
+	assertDecodeFails(t, "c4026161", nil, &s)
+}
+
+func TestArrayMapFormats(t *testing.T) {
+	for _, example := range []struct {
+		length int
+		array  string
+		mapHex string
+	}{
+		{0, "90", "80"},
+		{15, "9f", "8f"},
+		{16, "dc0010", "de0010"},
+		{65535, "dcffff", "deffff"},
+		{65536, "dd00010000", "df00010000"},
+	} {
+		list := make([]int, example.length)
+		if actual := encodeToHex(t, list, nil); actual != example.array+strings.Repeat("00", example.length) {
+			t.Errorf("Expected %v elements to use %v", example.length, example.array)
+		}
+
+		// Map ordering isn't stable, so only the header is checked
+		m := make(map[int]int)
+		for i := 0; i < example.length; i++ {
+			m[i] = i
+		}
+		if actual := encodeToHex(t, m, nil); !strings.HasPrefix(actual, example.mapHex) {
+			t.Errorf("Expected %v entries to use %v", example.length, example.mapHex)
+		}
+	}
+
+	var value interface{}
+	assertDecoded(t, "dd00000002 01 dc0000", nil, &value, []interface{}{int64(1), []interface{}{}})
+	assertDecoded(t, "df00000001 a161 de0000", nil, &value,
+		map[interface{}]interface{}{"a": map[interface{}]interface{}{}})
+	assertDecoded(t, "82 a161 01 01 c3", nil, &value, map[interface{}]interface{}{"a": int64(1), int64(1): true})
+}
+
+// The three timestamp formats, each at the edges of the range it's chosen for
+func TestTimestampExtension(t *testing.T) {
+	for _, example := range []struct {
+		value    time.Time
+		expected string
+	}{
+		{time.Unix(0, 0), "d6ff 00000000"},
+		{time.Unix(math.MaxUint32, 0), "d6ff ffffffff"},
+		{time.Unix(1, 1), "d7ff 00000004 00000001"},
+		{time.Unix(math.MaxUint32+1, 0), "d7ff 00000001 00000000"},
+		{time.Unix(1<<34-1, 999999999), "d7ff ee6b27ff ffffffff"},
+		{time.Unix(1<<34, 0), "c70cff 00000000 0000000400000000"},
+		{time.Unix(-1, 0), "c70cff 00000000 ffffffffffffffff"},
+		{time.Unix(-62135596800, 999999999), "c70cff 3b9ac9ff fffffff1886e0900"},
+	} {
+		assertRoundtrip(t, example.value, nil, example.expected, example.value.UTC())
+	}
+
+	// A timestamp in a larger format than it needs
+	var when time.Time
+	assertDecoded(t, "c70cff 00000000 0000000000000001", nil, &when, time.Unix(1, 0).UTC())
+	assertDecoded(t, "c708ff 00000000 00000001", nil, &when, time.Unix(1, 0).UTC())
+
+	for _, document := range []string{
+		// Invalid lengths
+		"d4ff 00", "d5ff 0000", "d8ff 00000000000000000000000000000000", "c700ff", "c705ff 0000000000",
+		// Nanoseconds out of range
+		"d7ff ee6b2800 00000000", "c70cff 3b9aca00 0000000000000000",
+		// Truncated
+		"d6ff 0000", "c70cff 00000000",
+	} {
+		assertDecodeFails(t, document, nil, &when)
+	}
+}
+
+func TestURIExtension(t *testing.T) {
+	short, _ := url.Parse("/a")
+	assertRoundtrip(t, short, nil, "d500 2f61", short)
+	four, _ := url.Parse("/abc")
+	assertRoundtrip(t, four, nil, "d600 2f616263", four)
+	other, _ := url.Parse("http://a.b")
+	assertRoundtrip(t, other, nil, "c70a00 687474703a2f2f612e62", other)
+	assertRoundtrip(t, other, &EncoderOptions{URIExtension: 5}, "c70a05 687474703a2f2f612e62", other)
+	assertRoundtrip(t, other, &EncoderOptions{URIExtension: -100}, "c70a9c 687474703a2f2f612e62", other)
+
+	// Every ext format can hold a URI
+	var uri *url.URL
+	assertDecoded(t, "c8 0002 00 2f61", nil, &uri, short)
+	assertDecoded(t, "c9 00000002 00 2f61", nil, &uri, short)
+
+	// Other extension types, in each ext format
+	for _, document := range []string{
+		"d405 00", "d505 0000", "d605 00000000", "d705 0000000000000000",
+		"d805 00000000000000000000000000000000", "c70005", "c8000105 00", "c90000000105 00",
+		"d500 2561",
+	} {
+		assertDecodeFails(t, document, nil, &uri)
+	}
+	assertDecodeFails(t, "d500 2f61", &DecoderOptions{URIExtension: 5}, &uri)
+}
+
+func TestNil(t *testing.T) {
+	var p *int
+	assertDecoded(t, "c0", nil, &p, (*int)(nil))
+	var list []int
+	assertDecoded(t, "c0", nil, &list, []int(nil))
+	var i int
+	assertDecodeFails(t, "c0", nil, &i)
+}
+
+func TestRoundtrip(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	where, _ := url.Parse("http://example.com/a?b=c")
+	expected := MsgpackStruct{
+		Name:  "outer",
+		Count: math.MinInt64,
+		Big:   math.MaxUint64,
+		Ratio: 0.1,
+		Data:  []byte{1, 2, 3},
+		When:  when,
+		Where: where,
+		Inner: &MsgpackStruct{Name: "inner", List: []int{}},
+		List:  []int{1, 2, 3},
+		Map:   map[int]string{-1: "v"},
+	}
+
+	for _, strBin := range []StrBinMode{StrBinDistinct, StrBinRaw} {
+		buffer := &bytes.Buffer{}
+		if err := Encode(buffer, expected, &EncoderOptions{URIExtension: 10, StrBin: strBin}); err != nil {
+			t.Fatal(err)
+		}
+		var actual MsgpackStruct
+		decoder := NewDecoderWithOptions(buffer, &DecoderOptions{URIExtension: 10, StrBin: strBin}, nil)
+		if err := decoder.Decode(&actual); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %#v but got %#v", expected, actual)
+		}
+	}
+}
+
+// MessagePack has no references, so shared data is duplicated and cycles can't
+// be encoded.
+func TestReferences(t *testing.T) {
+	shared := []int{1}
+	assertEncoded(t, [][]int{shared, shared}, nil, "92 9101 9101")
+
+	s := SelfSlice{nil, nil}
+	s[1] = &s
+	if err := reconstruct.IterateObject(s, true, NewEncoder(&bytes.Buffer{}, nil)); err == nil {
+		t.Errorf("Expected encoding a slice that contains itself to fail")
+	}
+}
+
+func TestEncodeFail(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, 1+2i, nil); err == nil {
+		t.Errorf("Expected encoding a complex number to fail")
+	}
+
+	encoder := NewEncoder(&bytes.Buffer{}, nil)
+	encoder.OnMapBegin()
+	encoder.OnInt(1)
+	if err := encoder.OnContainerEnd(); err == nil {
+		t.Errorf("Expected ending a map after a key to fail")
+	}
+}
+
+func TestDecodeFail(t *testing.T) {
+	var value interface{}
+	for _, document := range []string{
+		// Never used
+		"c1",
+		// Truncated in each family
+		"", "cc", "cd01", "ce000000", "cf00000000000000", "d0", "d3000000000000", "ca0000", "cb00000000000000",
+		"a261", "d902 61", "da0002 61", "c402 01", "c5", "c6000000", "92 01", "dc0002 01", "81 01", "de0001 01",
+	} {
+		assertDecodeFails(t, document, nil, &value)
+	}
+	if err := Decode(bytes.NewReader(decodeHex(t, "01 02")), &value); err == nil {
+		t.Errorf("Expected decoding trailing data to fail")
+	}
+
+	err := Decode(bytes.NewReader(decodeHex(t, "92 01 c1")), &value)
+	if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Offset != 3 {
+		t.Errorf("Expected a syntax error at offset 3 but got %v", err)
+	}
+
+	deep := strings.Repeat("91", maxDepth+1) + "01"
+	assertDecodeFails(t, deep, nil, &value)
+}
+
+func TestDecoderSequence(t *testing.T) {
+	decoder := NewDecoder(bytes.NewReader(decodeHex(t, "01 91 02 81 a161 03")),
+		&reconstruct.BuilderOptions{StringKeyedMaps: true})
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	expected := []interface{}{int64(1), []interface{}{int64(2)}, map[string]interface{}{"a": int64(3)}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values)
+	}
+}
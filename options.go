@@ -0,0 +1,98 @@
+package reconstruct
+
+// BuilderOptions configures how a RootBuilder builds objects. A nil
+// *BuilderOptions means use the defaults.
+type BuilderOptions struct {
+	// Determines how struct fields are named. Defaults to ReconstructTagResolver.
+	FieldNameResolver FieldNameResolver
+
+	// If true, a key that doesn't match any field of the destination struct
+	// causes the build to fail rather than being ignored.
+	DisallowUnknownFields bool
+
+	// If set, maps built into interfaces are checked for a type discriminator
+	// naming the concrete type to build.
+	TypeRegistry *TypeRegistry
+
+	// The map key holding type discriminators. Defaults to DefaultTypeKey.
+	TypeKey string
+
+	// If true, maps built into interface{} are map[string]interface{} rather
+	// than map[interface{}]interface{}, provided that all keys are strings.
+	StringKeyedMaps bool
+
+	// If set, maps built into interface{} are built into the OrderedMap that
+	// this function returns rather than into a Go map. Takes precedence over
+	// StringKeyedMaps.
+	NewOrderedMap func() OrderedMap
+
+	// If true, integers built into interfaces are int rather than int64 or
+	// uint64 (when they fit).
+	UseInt bool
+
+	// If true, numbers built into interfaces are json.Number, which preserves
+	// their exact values. Takes precedence over UseInt.
+	UseNumber bool
+
+	// If true, String events can be built into types that normally have their
+	// own events: time.Time (RFC 3339), URLs, and []byte (standard base64).
+	// This is how text formats such as JSON represent these types.
+	ConvertStrings bool
+
+	// If true, String events can be built into []byte as the string's raw
+	// bytes, for formats that don't distinguish text from binary data (such
+	// as MessagePack's original raw type). Takes precedence over
+	// ConvertStrings.
+	StringsAsBytes bool
+}
+
+func (this *BuilderOptions) withDefaultsApplied() BuilderOptions {
+	var options BuilderOptions
+	if this != nil {
+		options = *this
+	}
+	if options.FieldNameResolver == nil {
+		options.FieldNameResolver = ReconstructTagResolver
+	}
+	if options.TypeKey == "" {
+		options.TypeKey = DefaultTypeKey
+	}
+	return options
+}
+
+// IteratorOptions configures how a RootObjectIterator iterates objects. A nil
+// *IteratorOptions means use the defaults.
+type IteratorOptions struct {
+	// If true, look for duplicate pointers to data, generating marker and
+	// reference events rather than walking the object again.
+	UseReferences bool
+
+	// Determines how struct fields are named. Defaults to ReconstructTagResolver.
+	FieldNameResolver FieldNameResolver
+
+	// If set, registered structs held by interfaces are iterated with a type
+	// discriminator so that they can be rebuilt as the same type.
+	TypeRegistry *TypeRegistry
+
+	// The map key holding type discriminators. Defaults to DefaultTypeKey.
+	TypeKey string
+
+	// If true, values held by interfaces are preceded by a type hint (if the
+	// callbacks implement TypeHintCallbacks). A RootBuilder uses type hints
+	// to rebuild such values as their original types.
+	EmitTypeHints bool
+}
+
+func (this *IteratorOptions) withDefaultsApplied() IteratorOptions {
+	var options IteratorOptions
+	if this != nil {
+		options = *this
+	}
+	if options.FieldNameResolver == nil {
+		options.FieldNameResolver = ReconstructTagResolver
+	}
+	if options.TypeKey == "" {
+		options.TypeKey = DefaultTypeKey
+	}
+	return options
+}
@@ -0,0 +1,105 @@
+package reconstruct
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PathElement is one step along the path from the top-level object to a value
+// inside of it. It's either a list index or a map key (struct fields are map
+// keys, since that's how they appear in the event stream).
+type PathElement struct {
+	// True if this element is a list index rather than a map key
+	IsIndex bool
+	// The list index (when IsIndex is true)
+	Index int
+	// The map key (when IsIndex is false)
+	Key interface{}
+}
+
+func (this PathElement) String() string {
+	if this.IsIndex {
+		return fmt.Sprintf("%v", this.Index)
+	}
+	return fmt.Sprintf("%v", this.Key)
+}
+
+var pathEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// PathToString converts a path to a JSON Pointer (RFC 6901) style string. Map
+// keys that aren't strings are converted using their default format.
+func PathToString(path []PathElement) string {
+	var builder strings.Builder
+	for _, elem := range path {
+		builder.WriteByte('/')
+		builder.WriteString(pathEscaper.Replace(elem.String()))
+	}
+	return builder.String()
+}
+
+// Tracks the current path within an object by watching the event stream.
+type pathTracker struct {
+	containers []pathContainer
+}
+
+type pathContainer struct {
+	isMap     bool
+	nextIsKey bool
+	index     int
+	key       interface{}
+}
+
+func (this *pathTracker) top() *pathContainer {
+	if len(this.containers) == 0 {
+		return nil
+	}
+	return &this.containers[len(this.containers)-1]
+}
+
+func (this *pathTracker) finishValue() {
+	if top := this.top(); top != nil {
+		if top.isMap {
+			top.nextIsKey = true
+		} else {
+			top.index++
+		}
+	}
+}
+
+func (this *pathTracker) onValue(value interface{}) {
+	if top := this.top(); top != nil && top.isMap && top.nextIsKey {
+		top.key = value
+		top.nextIsKey = false
+		return
+	}
+	this.finishValue()
+}
+
+func (this *pathTracker) onContainerBegin(isMap bool) {
+	this.containers = append(this.containers, pathContainer{
+		isMap:     isMap,
+		nextIsKey: isMap,
+	})
+}
+
+func (this *pathTracker) onContainerEnd() {
+	if len(this.containers) > 0 {
+		this.containers = this.containers[:len(this.containers)-1]
+	}
+	this.finishValue()
+}
+
+// Get a copy of the current path. A map that is waiting for its next key
+// doesn't contribute an element.
+func (this *pathTracker) path() []PathElement {
+	path := make([]PathElement, 0, len(this.containers))
+	for _, container := range this.containers {
+		switch {
+		case !container.isMap:
+			path = append(path, PathElement{IsIndex: true, Index: container.index})
+		case !container.nextIsKey:
+			path = append(path, PathElement{Key: container.key})
+		}
+	}
+	return path
+}
@@ -0,0 +1,382 @@
+package reconstruct
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FieldTag holds the naming information for a struct field, as determined by
+// a FieldNameResolver.
+type FieldTag struct {
+	// The key to use for the field. If empty, the Go field name is used.
+	Name string
+	// Options affecting how the field is treated. Currently supported:
+	//  * omitempty: Don't iterate the field if it's empty.
+	//  * required:  Building fails if the field doesn't receive a value.
+	//  * remain:    This field is a map with string keys that receives any
+	//               unrecognized keys when building, and whose entries are
+	//               iterated inline with the other fields. Ignored on fields
+	//               of any other type.
+	Options []string
+	// If true, the field doesn't take part in iteration or building.
+	Skip bool
+}
+
+func (this FieldTag) hasOption(option string) bool {
+	for _, opt := range this.Options {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldNameResolver determines how struct fields are named when iterating and
+// building.
+//
+// Field tables are cached per resolver, so a resolver should be created once
+// and reused rather than being created for each iteration or build. Resolvers
+// are compared like map keys, so one that can't be (such as a func type, or a
+// struct holding a slice) isn't cached, and has its fields looked up every time
+// it's used.
+type FieldNameResolver interface {
+	// ResolveField returns the naming information for a field, or ok=false if
+	// the resolver has no information about it (in which case the Go field
+	// name is used).
+	ResolveField(field reflect.StructField) (tag FieldTag, ok bool)
+}
+
+type tagFieldNameResolver struct {
+	tagName string
+}
+
+// NewTagFieldNameResolver creates a resolver that reads struct tags with the
+// specified name, following the same conventions as encoding/json:
+//
+//	Field int `tagName:"name"`           // Use "name" as the key
+//	Field int `tagName:",omitempty"`     // Don't iterate if empty
+//	Field int `tagName:"name,omitempty"` // Both
+//	Field int `tagName:"-"`              // Ignore this field
+//	Field int `tagName:"-,"`             // Use "-" as the key
+func NewTagFieldNameResolver(tagName string) FieldNameResolver {
+	return &tagFieldNameResolver{tagName: tagName}
+}
+
+func (this *tagFieldNameResolver) ResolveField(field reflect.StructField) (tag FieldTag, ok bool) {
+	value, ok := field.Tag.Lookup(this.tagName)
+	if !ok {
+		return
+	}
+	if value == "-" {
+		tag.Skip = true
+		return
+	}
+	parts := strings.Split(value, ",")
+	tag.Name = parts[0]
+	tag.Options = parts[1:]
+	return
+}
+
+type fallbackFieldNameResolver struct {
+	resolvers []FieldNameResolver
+}
+
+// NewFallbackFieldNameResolver creates a resolver that uses the first of the
+// specified resolvers to have information about a field.
+func NewFallbackFieldNameResolver(resolvers ...FieldNameResolver) FieldNameResolver {
+	return &fallbackFieldNameResolver{resolvers: resolvers}
+}
+
+func (this *fallbackFieldNameResolver) ResolveField(field reflect.StructField) (tag FieldTag, ok bool) {
+	for _, resolver := range this.resolvers {
+		if tag, ok = resolver.ResolveField(field); ok {
+			return
+		}
+	}
+	return
+}
+
+type funcFieldNameResolver struct {
+	resolve func(field reflect.StructField) (tag FieldTag, ok bool)
+}
+
+// NewFuncFieldNameResolver creates a resolver that calls a custom function.
+func NewFuncFieldNameResolver(resolve func(field reflect.StructField) (tag FieldTag, ok bool)) FieldNameResolver {
+	return &funcFieldNameResolver{resolve: resolve}
+}
+
+func (this *funcFieldNameResolver) ResolveField(field reflect.StructField) (tag FieldTag, ok bool) {
+	return this.resolve(field)
+}
+
+var (
+	// ReconstructTagResolver reads `reconstruct:"..."` tags. This is the default.
+	ReconstructTagResolver = NewTagFieldNameResolver("reconstruct")
+
+	// JSONTagResolver reads `json:"..."` tags.
+	JSONTagResolver = NewTagFieldNameResolver("json")
+
+	// YAMLTagResolver reads `yaml:"..."` tags.
+	YAMLTagResolver = NewTagFieldNameResolver("yaml")
+)
+
+// resolverCache holds the field tables that have been generated for a struct
+// type, keyed by the resolver that named their fields.
+type resolverCache struct {
+	tables sync.Map
+}
+
+// Get the field table for resolver, calling generate if it isn't cached.
+func (this *resolverCache) get(resolver FieldNameResolver, generate func() interface{}) interface{} {
+	if !isComparable(reflect.ValueOf(resolver)) {
+		return generate()
+	}
+	if table, ok := this.tables.Load(resolver); ok {
+		return table
+	}
+	table, _ := this.tables.LoadOrStore(resolver, generate())
+	return table
+}
+
+// Whether value can be used as a map key without panicking. Unlike
+// reflect.Type.Comparable, this checks the values held in interfaces.
+func isComparable(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Func, reflect.Map, reflect.Slice:
+		return false
+	case reflect.Interface:
+		return value.IsNil() || isComparable(value.Elem())
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if !isComparable(value.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !isComparable(value.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// The struct tag holding a field's default value, which is applied when
+// building if the field doesn't receive a value:
+//
+//	Port int `default:"8080"`
+//
+// Only bool, string, numeric (except complex), time.Time, and url.URL fields
+// (or pointers to them) can have defaults. Building a struct with a default on
+// any other field fails.
+const defaultTagName = "default"
+
+// structField describes how a struct field is named and treated when
+// iterating and building.
+type structField struct {
+	Name      string
+	Index     []int
+	Type      reflect.Type
+	OmitEmpty bool
+	Required  bool
+	// Set from the `default:"..."` tag
+	Default    string
+	HasDefault bool
+	tagged     bool
+}
+
+// Get the fields of a struct that take part in iteration and building, and the
+// field (if any) that receives all remaining keys.
+//
+// The fields of anonymous (embedded) structs and struct pointers are promoted
+// into the parent using the same rules as encoding/json: a shallower field
+// shadows deeper fields of the same name, a tagged field shadows untagged
+// fields at the same depth, and any remaining conflicts cause all fields of
+// that name to be dropped. An embedded struct that is given a name by its tag
+// is treated as a regular field.
+func getStructFields(structType reflect.Type, resolver FieldNameResolver) (fields []*structField, remain *structField) {
+	// Embedded structs are walked breadth first so that shallower fields are
+	// always found first.
+	var current []*structField
+	next := []*structField{{Type: structType}}
+	var count, nextCount map[reflect.Type]int
+	visited := make(map[reflect.Type]bool)
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, make(map[reflect.Type]int)
+
+		for _, embedded := range current {
+			if visited[embedded.Type] {
+				continue
+			}
+			visited[embedded.Type] = true
+
+			for i := 0; i < embedded.Type.NumField(); i++ {
+				field := embedded.Type.Field(i)
+				fieldType := field.Type
+				if field.Anonymous {
+					if fieldType.Kind() == reflect.Ptr {
+						if field.PkgPath != "" {
+							// An unexported embedded pointer can't be allocated.
+							continue
+						}
+						fieldType = fieldType.Elem()
+					}
+					if field.PkgPath != "" && fieldType.Kind() != reflect.Struct {
+						continue
+					}
+				} else if field.PkgPath != "" {
+					continue
+				}
+
+				tag, _ := resolver.ResolveField(field)
+				if tag.Skip {
+					continue
+				}
+
+				index := make([]int, len(embedded.Index)+1)
+				copy(index, embedded.Index)
+				index[len(embedded.Index)] = i
+
+				if tag.Name != "" || !field.Anonymous || fieldType.Kind() != reflect.Struct {
+					name := tag.Name
+					if name == "" {
+						name = field.Name
+					}
+					if tag.hasOption("remain") && isRemainType(field.Type) {
+						if remain == nil {
+							remain = &structField{Name: name, Index: index, Type: field.Type}
+						}
+						continue
+					}
+					defaultValue, hasDefault := field.Tag.Lookup(defaultTagName)
+					fields = append(fields, &structField{
+						Name:       name,
+						Index:      index,
+						Type:       field.Type,
+						OmitEmpty:  tag.hasOption("omitempty"),
+						Required:   tag.hasOption("required"),
+						Default:    defaultValue,
+						HasDefault: hasDefault,
+						tagged:     tag.Name != "",
+					})
+					if count[embedded.Type] > 1 {
+						// The same struct was embedded more than once at this
+						// depth, so its fields conflict with each other.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				nextCount[fieldType]++
+				if nextCount[fieldType] == 1 {
+					next = append(next, &structField{Index: index, Type: fieldType})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if len(a.Index) != len(b.Index) {
+			return len(a.Index) < len(b.Index)
+		}
+		if a.tagged != b.tagged {
+			return a.tagged
+		}
+		return indexLess(a.Index, b.Index)
+	})
+
+	dominant := fields[:0]
+	for i := 0; i < len(fields); {
+		end := i + 1
+		for end < len(fields) && fields[end].Name == fields[i].Name {
+			end++
+		}
+		if end-i == 1 || len(fields[i].Index) != len(fields[i+1].Index) || fields[i].tagged != fields[i+1].tagged {
+			dominant = append(dominant, fields[i])
+		}
+		i = end
+	}
+	fields = dominant
+
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].Index, fields[j].Index)
+	})
+	return
+}
+
+func isRemainType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+}
+
+func indexLess(a, b []int) bool {
+	for i, v := range a {
+		if i >= len(b) {
+			return false
+		}
+		if v != b[i] {
+			return v < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// Get a (possibly promoted) field, or an invalid value if the field is inside
+// of a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, fieldIndex := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fieldIndex)
+	}
+	return v
+}
+
+// Get a (possibly promoted) field, allocating any nil embedded pointers along
+// the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, fieldIndex := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fieldIndex)
+	}
+	return v
+}
+
+// Determine if a value is "empty" for the purposes of omitempty (using the
+// same rules as encoding/json).
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package reconstruct
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DefaultTypeKey is the map key used for type discriminators when none is
+// specified in the options.
+const DefaultTypeKey = "@type"
+
+// TypeRegistry maps names to concrete types so that values stored in
+// interfaces can be iterated along with a type discriminator, and then rebuilt
+// as the same concrete type (even into non-empty interfaces).
+//
+// When iterating, a registered struct (or struct pointer) held by an interface
+// is emitted as a map whose first key is the type key, with the type's name as
+// its value. When building a map into an interface, a first key matching the
+// type key selects the registered type to build.
+type TypeRegistry struct {
+	mutex        sync.RWMutex
+	namesToTypes map[string]reflect.Type
+	typesToNames map[reflect.Type]string
+}
+
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		namesToTypes: make(map[string]reflect.Type),
+		typesToNames: make(map[reflect.Type]string),
+	}
+}
+
+// Register associates name with the type of template, which must be a struct
+// or a pointer to a struct. The registered type is exactly what gets built, so
+// register &MyStruct{} if the interface will hold pointers.
+//
+// Register panics if the name or type is already registered, or if the type
+// isn't supported.
+func (this *TypeRegistry) Register(name string, template interface{}) {
+	t := reflect.TypeOf(template)
+	structType := t
+	if structType != nil && structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType == nil || structType.Kind() != reflect.Struct {
+		panic(fmt.Errorf("Cannot register type %v: Only structs and struct pointers can be registered", t))
+	}
+	if name == "" {
+		panic(fmt.Errorf("Cannot register type %v: Name must not be empty", t))
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if existing, ok := this.namesToTypes[name]; ok {
+		panic(fmt.Errorf("Cannot register type %v: Name %v is already registered to %v", t, name, existing))
+	}
+	if existing, ok := this.typesToNames[t]; ok {
+		panic(fmt.Errorf("Cannot register type %v: Type is already registered as %v", t, existing))
+	}
+	this.namesToTypes[name] = t
+	this.typesToNames[t] = name
+}
+
+// TypeForName returns the type registered under name.
+func (this *TypeRegistry) TypeForName(name string) (t reflect.Type, ok bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	t, ok = this.namesToTypes[name]
+	return
+}
+
+// NameForType returns the name that t is registered under.
+func (this *TypeRegistry) NameForType(t reflect.Type) (name string, ok bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	name, ok = this.typesToNames[t]
+	return
+}